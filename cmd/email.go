@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
+	"github.com/spf13/cobra"
+)
+
+var emailCmd = &cobra.Command{
+	Use:   "email",
+	Short: "Email utilities",
+}
+
+var emailPreviewCmd = &cobra.Command{
+	Use:       "preview <template>",
+	Short:     "Render an email template with sample data",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: comm.TemplateNames(),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		locale, _ := cmd.Flags().GetString("locale")
+		dir, _ := cmd.Flags().GetString("template-dir")
+		text, _ := cmd.Flags().GetBool("text")
+		if !cmd.Flags().Changed("template-dir") {
+			godotenv.Load()
+			dir = os.Getenv("EMAIL_TEMPLATE_DIR")
+		}
+
+		return EmailPreview(os.Stdout, dir, locale, args[0], text)
+	},
+}
+
+func init() {
+	emailPreviewCmd.Flags().String("locale", comm.DefaultLocale, "Locale to render the template in")
+	emailPreviewCmd.Flags().String("template-dir", "", "Directory with template overrides, defaults to EMAIL_TEMPLATE_DIR")
+	emailPreviewCmd.Flags().Bool("text", false, "Print the plain text part instead of the html one")
+	emailCmd.AddCommand(emailPreviewCmd)
+}
+
+func EmailPreview(w io.Writer, dir string, locale string, name string, text bool) error {
+	msg, err := comm.Preview(dir, locale, name)
+	if err != nil {
+		return err
+	}
+	body := msg.Html
+	if text {
+		body = msg.Text
+	}
+	_, err = fmt.Fprintf(w, "Subject: %s\n\n%s\n", msg.Subject, body)
+
+	return err
+}
@@ -16,7 +16,7 @@ func Version(version string) {
 }
 
 func Execute() error {
-	rootCmd.AddCommand(schemaCmd, srvStartCmd)
+	rootCmd.AddCommand(schemaCmd, srvStartCmd, emailCmd)
 
 	return rootCmd.Execute()
 }
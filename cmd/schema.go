@@ -40,6 +40,50 @@ func loadEnums(sb *strings.Builder) *strings.Builder {
 			'GOOGLE',
 			'APPLE'
 		);`,
+		`CREATE TYPE user_status AS ENUM (
+			'ACTIVE',
+			'SUSPENDED',
+			'PENDING_DELETION'
+		);`,
+		`CREATE TYPE audit_event_type AS ENUM (
+			'OTP_REQUESTED',
+			'OTP_VERIFIED',
+			'OTP_FAILED',
+			'SIGN_IN',
+			'TOKEN_ISSUED',
+			'TOKEN_REVOKED',
+			'EMAIL_CHANGED',
+			'EMAIL_CHANGE_REQUESTED',
+			'EMAIL_CHANGE_UNDONE',
+			'PROFILE_UPDATED',
+			'USER_SUSPENDED',
+			'USER_REACTIVATED'
+		);`,
+		`CREATE TYPE audit_outcome AS ENUM (
+			'SUCCESS',
+			'FAILURE'
+		);`,
+		`CREATE TYPE webhook_event_type AS ENUM (
+			'user.created',
+			'user.updated',
+			'email.changed',
+			'user.deleted'
+		);`,
+		`CREATE TYPE webhook_delivery_status AS ENUM (
+			'PENDING',
+			'SUCCEEDED',
+			'FAILED'
+		);`,
+		`CREATE TYPE email_status AS ENUM (
+			'PENDING',
+			'SENT',
+			'DEAD'
+		);`,
+		`CREATE TYPE data_export_status AS ENUM (
+			'PENDING',
+			'READY',
+			'FAILED'
+		);`,
 	}
 	for _, enum := range enums {
 		sb.WriteString(enum)
@@ -51,6 +95,11 @@ func loadEnums(sb *strings.Builder) *strings.Builder {
 func loadModels(sb *strings.Builder) *strings.Builder {
 	models := []interface{}{
 		&model.User{},
+		&model.AuditEvent{},
+		&model.WebhookEndpoint{},
+		&model.WebhookDelivery{},
+		&model.EmailMessage{},
+		&model.DataExport{},
 	}
 	stmts, err := gormschema.New("postgres").Load(models...)
 	if err != nil {
@@ -3,7 +3,7 @@ package cmd
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,12 +12,20 @@ import (
 
 	"github.com/nkbhasker/go-auth-starter/config"
 	"github.com/nkbhasker/go-auth-starter/internal/api"
+	"github.com/nkbhasker/go-auth-starter/internal/blob"
 	"github.com/nkbhasker/go-auth-starter/internal/comm"
 	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/job"
+	"github.com/nkbhasker/go-auth-starter/internal/logger"
+	"github.com/nkbhasker/go-auth-starter/internal/metadata"
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
 	"github.com/nkbhasker/go-auth-starter/internal/misc"
 	"github.com/nkbhasker/go-auth-starter/internal/repo"
 	"github.com/nkbhasker/go-auth-starter/internal/storage"
 	"github.com/nkbhasker/go-auth-starter/internal/uid"
+	"github.com/nkbhasker/go-auth-starter/internal/webhook"
+	"github.com/nkbhasker/go-auth-starter/internal/worker"
 	"github.com/spf13/cobra"
 
 	_ "github.com/nkbhasker/go-auth-starter/internal/errors"
@@ -36,17 +44,64 @@ func SrvStart(version string) error {
 	if err != nil {
 		return err
 	}
+	log, err := logger.New(os.Stdout, logger.Options{Level: cfg.LogLevel})
+	if err != nil {
+		return err
+	}
+	// For the packages logging without a logger of their own
+	slog.SetDefault(log)
+	signInOtpPolicy, err := misc.NewOtpPolicy(
+		cfg.SignInOtpLength,
+		misc.OtpAlphabetEnum(cfg.SignInOtpAlphabet),
+		cfg.SignInOtpExpiryInMinutes,
+	)
+	if err != nil {
+		return err
+	}
+	emailUpdateOtpPolicy, err := misc.NewOtpPolicy(
+		cfg.EmailUpdateOtpLength,
+		misc.OtpAlphabetEnum(cfg.EmailUpdateOtpAlphabet),
+		cfg.EmailUpdateOtpExpiryInMinutes,
+	)
+	if err != nil {
+		return err
+	}
+	metadataValidator, err := metadata.NewValidator(map[enum.MetadataFieldEnum]metadata.Options{
+		enum.MetadataFieldPublic:  {MaxBytes: cfg.PublicMetadataMaxBytes, SchemaPath: cfg.PublicMetadataSchema},
+		enum.MetadataFieldPrivate: {MaxBytes: cfg.PrivateMetadataMaxBytes, SchemaPath: cfg.PrivateMetadataSchema},
+		enum.MetadataFieldApp:     {MaxBytes: cfg.AppMetadataMaxBytes, SchemaPath: cfg.AppMetadataSchema},
+	})
+	if err != nil {
+		return err
+	}
+	metadataClaims, err := metadata.ParseProjection(cfg.JwtMetadataClaims)
+	if err != nil {
+		return err
+	}
 	idGenerator := uid.NewIdGenerator()
-	dbStore, err := storage.InitDBStore(cfg.PostgresUrl)
+	dbStore, err := storage.InitDBStore(storage.DBOptions{
+		Url:              cfg.PostgresUrl,
+		ReplicaUrls:      cfg.PostgresReplicaUrls,
+		MaxOpenConns:     cfg.DBMaxOpenConns,
+		MaxIdleConns:     cfg.DBMaxIdleConns,
+		ConnMaxLifetime:  time.Duration(cfg.DBConnMaxLifetimeInSeconds * int(time.Second)),
+		ConnMaxIdleTime:  time.Duration(cfg.DBConnMaxIdleTimeInSeconds * int(time.Second)),
+		StatementTimeout: time.Duration(cfg.DBStatementTimeoutInMs * int(time.Millisecond)),
+		LogLevel:         cfg.DBLogLevel,
+	})
 	if err != nil {
 		return err
 	}
 	defer dbStore.CloseDB()
-	cacheStore, err := storage.InitCacheStore(cfg.RedisUrl)
+	cacheStore, err := newCacheStore(cfg)
 	if err != nil {
 		return err
 	}
 	defer cacheStore.CloseDB()
+	err = registerPoolMetrics(dbStore, cacheStore)
+	if err != nil {
+		return err
+	}
 	jwtHelper, err := misc.NewJwtHelper(cfg.Host, cfg.JwtPrivateKey)
 	if err != nil {
 		return err
@@ -57,20 +112,23 @@ func SrvStart(version string) error {
 		IdGenerator:                idGenerator,
 		JwtHelper:                  jwtHelper,
 		AccessTokenExpiryInMinutes: cfg.AccessTokenExpiryInMinutes,
-		OtpExpiryInMinutes:         cfg.OtpExpiryInMinutes,
-	})
-	awsSession, err := core.NewAwsSession(core.AwsSessionOptions{
-		Region:          cfg.AwsRegion,
-		AccessKeyId:     cfg.AwsAccessKeyId,
-		SecretAccessKey: cfg.AwsSecretAccessKey,
+		OtpMaxAttempts:             cfg.OtpMaxAttempts,
+		UserStatusExpiryInSeconds:  cfg.UserStatusExpiryInSeconds,
 	})
+	emailClient, err := newEmailClient(cfg)
 	if err != nil {
 		return err
 	}
-	emailer, err := comm.NewEmailer(comm.NewSES(awsSession.Session, cfg.AwsSesSender))
+	// Emails are queued in the outbox and sent by the email delivery job
+	emailer, err := comm.NewEmailer(comm.NewOutbox(repos.EmailOutboxRepo()), cfg.EmailTemplateDir)
 	if err != nil {
 		return err
 	}
+	blobStore, err := newBlobStore(cfg)
+	if err != nil {
+		return err
+	}
+	bgWorker := worker.NewWorker()
 	app := core.NewApp(core.AppOption{
 		Version:     version,
 		DBStore:     dbStore,
@@ -79,33 +137,103 @@ func SrvStart(version string) error {
 		IdGenerator: idGenerator,
 		Emailer:     emailer,
 		Validate:    core.NewValidate(),
+		Logger:      log,
 	})
-	otpGenerateRateLimiter := core.NewRateLimiter(
-		cacheStore,
-		core.RateLimiterKindOtpGenerate,
-		cfg.OtpGenerateRateLimit,
-		cfg.OtpGenerateRateLimitWindow,
+	bgWorker.Schedule(
+		"account_deletion",
+		time.Duration(cfg.AccountDeletionIntervalInMinutes*int(time.Minute)),
+		job.AccountDeletion(app, blobStore),
+	)
+	emailEncryptionKey := cfg.EmailEncryptionKey
+	if emailEncryptionKey == nil {
+		emailEncryptionKey = misc.DeriveKey(cfg.JwtPrivateKey, "email encryption")
+	}
+	emailSealer, err := misc.NewSealer(emailEncryptionKey)
+	if err != nil {
+		return err
+	}
+	bgWorker.Schedule(
+		"email_delivery",
+		time.Duration(cfg.EmailIntervalInSeconds*int(time.Second)),
+		job.EmailDelivery(app, emailClient, emailSealer, cfg.EmailWorkers, cfg.EmailMaxAttempts),
+	)
+	bgWorker.Schedule("audit_event_retention", 24*time.Hour, job.AuditEventRetention(app, cfg.AuditEventRetentionInDays))
+	bgWorker.Schedule("email_retention", 24*time.Hour, job.EmailRetention(app, cfg.EmailRetentionInDays))
+	bgWorker.Schedule(
+		"webhook_delivery",
+		time.Duration(cfg.WebhookIntervalInSeconds*int(time.Second)),
+		job.WebhookDelivery(app, webhook.NewSender(cfg.WebhookTimeoutInSeconds), cfg.WebhookMaxAttempts),
 	)
-	otpVerifyRateLimiter := core.NewRateLimiter(
-		cacheStore,
-		core.RateLimiterKindOtpVerify,
-		cfg.OtpVerifyRateLimit,
-		cfg.OtpVerifyRateLimitWindow,
+	bgWorker.Schedule(
+		"data_export",
+		time.Duration(cfg.DataExportIntervalInSeconds*int(time.Second)),
+		job.DataExport(app, blobStore, cfg.DataExportUrl, time.Duration(cfg.DataExportExpiryInHours*int(time.Hour))),
 	)
+	otpGenerateRateLimiter, err := core.NewRateLimiter(cacheStore, core.RateLimiterOptions{
+		Kind:                core.RateLimiterKindOtpGenerate,
+		Algorithm:           core.RateLimitAlgorithmEnum(cfg.OtpGenerateRateLimitAlgorithm),
+		Limit:               cfg.OtpGenerateRateLimit,
+		TimeWindowInSeconds: cfg.OtpGenerateRateLimitWindow,
+	})
+	if err != nil {
+		return err
+	}
+	otpVerifyRateLimiter, err := core.NewRateLimiter(cacheStore, core.RateLimiterOptions{
+		Kind:                core.RateLimiterKindOtpVerify,
+		Algorithm:           core.RateLimitAlgorithmEnum(cfg.OtpVerifyRateLimitAlgorithm),
+		Limit:               cfg.OtpVerifyRateLimit,
+		TimeWindowInSeconds: cfg.OtpVerifyRateLimitWindow,
+	})
+	if err != nil {
+		return err
+	}
+	// The dev mailbox is only exposed when it is the email client
+	devMailbox, _ := emailClient.(comm.Mailbox)
 	handler := api.SetupRouter(api.RouterOptions{
-		App:                    app,
-		JwtHelper:              jwtHelper,
-		OtpGenerateRateLimiter: otpGenerateRateLimiter,
-		OtpVerifyRateLimiter:   otpVerifyRateLimiter,
+		App:                              app,
+		JwtHelper:                        jwtHelper,
+		OtpGenerateRateLimiter:           otpGenerateRateLimiter,
+		OtpVerifyRateLimiter:             otpVerifyRateLimiter,
+		SignInOtpPolicy:                  signInOtpPolicy,
+		EmailUpdateOtpPolicy:             emailUpdateOtpPolicy,
+		EmailChangeUndoUrl:               cfg.EmailChangeUndoUrl,
+		EmailChangeUndoExpiryInHours:     cfg.EmailChangeUndoExpiryInHours,
+		MetadataValidator:                metadataValidator,
+		MetadataClaims:                   metadataClaims,
+		AccountDeletionGracePeriodInDays: cfg.AccountDeletionGracePeriodInDays,
+		AdminApiKey:                      cfg.AdminApiKey,
+		DevMode:                          cfg.DevMode,
+		DevMailbox:                       devMailbox,
+		BlobStore:                        blobStore,
+		AvatarMaxBytes:                   cfg.AvatarMaxBytes,
+		TrustedProxies:                   cfg.TrustedProxies,
+		MetricsPort:                      cfg.MetricsPort,
 	})
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
 		Handler: handler,
 	}
+	// Metrics get their own listener when a port is set for them, to be
+	// scraped without the admin api key from the private network only
+	var metricsSrv *http.Server
+	if cfg.MetricsPort != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsSrv = &http.Server{
+			Addr:    ":" + cfg.MetricsPort,
+			Handler: metricsMux,
+		}
+	}
 
 	// Server run context
 	srvCtx, serverStopCtx := context.WithCancel(context.Background())
 
+	// Background jobs run until the server stops
+	workerCtx, stopWorker := context.WithCancel(context.Background())
+	bgWorker.Start(workerCtx)
+	defer bgWorker.Wait()
+	defer stopWorker()
+
 	errch := make(chan error, 1)
 	sigch := make(chan os.Signal, 1)
 	// Listen for syscall signals for process to interrupt/quit
@@ -124,6 +252,10 @@ func SrvStart(version string) error {
 		}()
 
 		// Trigger graceful shutdown
+		if metricsSrv != nil {
+			// Only shutdown errors of the main server are reported
+			_ = metricsSrv.Shutdown(shutdownCtx)
+		}
 		err := srv.Shutdown(shutdownCtx)
 		if err != nil {
 			errch <- err
@@ -131,13 +263,27 @@ func SrvStart(version string) error {
 		serverStopCtx()
 	}()
 
-	log.Printf("Server running on port %s\n", cfg.Port)
-	log.Println("Press ctrl+c to stop")
+	if metricsSrv != nil {
+		go func() {
+			log.Info("metrics server running", "port", cfg.MetricsPort)
+			err := metricsSrv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				// Stop the server too rather than run without metrics
+				log.Error("metrics server failed", "error", err)
+				select {
+				case sigch <- syscall.SIGTERM:
+				default:
+				}
+			}
+		}()
+	}
+
+	log.Info("server running", "port", cfg.Port, "version", version)
 	err = srv.ListenAndServe()
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		errch <- err
 	} else {
-		log.Println("Server stopped")
+		log.Info("server stopped")
 	}
 	// Wait to be marked done
 	<-srvCtx.Done()
@@ -149,3 +295,80 @@ func SrvStart(version string) error {
 		return nil
 	}
 }
+
+func newEmailClient(cfg *config.SrvConfig) (comm.EmailClient, error) {
+	if cfg.EmailProvider == config.EmailProviderDev {
+		return comm.NewDevMailbox(cfg.DevMailboxDir, cfg.SmtpSender, cfg.DevMailboxSize)
+	}
+	if cfg.EmailProvider == config.EmailProviderSMTP {
+		return comm.NewSMTP(comm.SmtpOptions{
+			Host:     cfg.SmtpHost,
+			Port:     cfg.SmtpPort,
+			Username: cfg.SmtpUsername,
+			Password: cfg.SmtpPassword,
+			Sender:   cfg.SmtpSender,
+			Tls:      comm.SmtpTlsEnum(cfg.SmtpTls),
+			Auth:     comm.SmtpAuthEnum(cfg.SmtpAuth),
+			PoolSize: cfg.SmtpPoolSize,
+		})
+	}
+	awsSession, err := core.NewAwsSession(core.AwsSessionOptions{
+		Region:          cfg.AwsRegion,
+		AccessKeyId:     cfg.AwsAccessKeyId,
+		SecretAccessKey: cfg.AwsSecretAccessKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return comm.NewSES(awsSession.Session, cfg.AwsSesSender), nil
+}
+
+func newCacheStore(cfg *config.SrvConfig) (storage.CacheStore, error) {
+	if cfg.CacheStore == config.CacheStoreMemory {
+		return storage.NewMemoryCacheStore(), nil
+	}
+
+	return storage.InitCacheStore(storage.RedisOptions{
+		Mode:             storage.RedisModeEnum(cfg.RedisMode),
+		Url:              cfg.RedisUrl,
+		Addrs:            cfg.RedisAddrs,
+		MasterName:       cfg.RedisMasterName,
+		Username:         cfg.RedisUsername,
+		Password:         cfg.RedisPassword,
+		SentinelPassword: cfg.RedisSentinelPassword,
+		DB:               cfg.RedisDB,
+	})
+}
+
+func newBlobStore(cfg *config.SrvConfig) (blob.Store, error) {
+	if cfg.BlobProvider == config.BlobProviderLocal {
+		return blob.NewLocal(cfg.BlobDir, cfg.BlobBaseUrl)
+	}
+	awsSession, err := core.NewAwsSession(core.AwsSessionOptions{
+		Region:          cfg.AwsRegion,
+		AccessKeyId:     cfg.AwsAccessKeyId,
+		SecretAccessKey: cfg.AwsSecretAccessKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blob.NewS3(awsSession.Session, cfg.S3Bucket, cfg.BlobBaseUrl), nil
+}
+
+// registerPoolMetrics exposes the stats of the connection pools of the
+// primary database, of every replica and of redis, when used.
+func registerPoolMetrics(dbStore storage.DBStore, cacheStore storage.CacheStore) error {
+	for name, sqlDB := range dbStore.Pools() {
+		err := metrics.RegisterDBStats(sqlDB, name)
+		if err != nil {
+			return err
+		}
+	}
+	if redisStore, ok := cacheStore.(storage.RedisCacheStore); ok {
+		return metrics.RegisterRedisStats(redisStore.DB())
+	}
+
+	return nil
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
@@ -9,22 +12,107 @@ import (
 	"github.com/joho/godotenv"
 )
 
+const (
+	EmailProviderSES  = "ses"
+	EmailProviderSMTP = "smtp"
+	EmailProviderDev  = "dev"
+)
+
+const (
+	CacheStoreRedis  = "redis"
+	CacheStoreMemory = "memory"
+)
+
+const (
+	BlobProviderLocal = "local"
+	BlobProviderS3    = "s3"
+)
+
 type SrvConfig struct {
-	Host                       string
-	Port                       string
-	PostgresUrl                string
-	RedisUrl                   string
-	JwtPrivateKey              string
-	AccessTokenExpiryInMinutes int
-	OtpExpiryInMinutes         int
-	OtpGenerateRateLimit       int
-	OtpGenerateRateLimitWindow int
-	OtpVerifyRateLimit         int
-	OtpVerifyRateLimitWindow   int
-	AwsRegion                  string
-	AwsAccessKeyId             string
-	AwsSecretAccessKey         string
-	AwsSesSender               string
+	Host                             string
+	Port                             string
+	MetricsPort                      string
+	TrustedProxies                   []netip.Prefix
+	LogLevel                         string
+	PostgresUrl                      string
+	PostgresReplicaUrls              []string
+	DBMaxOpenConns                   int
+	DBMaxIdleConns                   int
+	DBConnMaxLifetimeInSeconds       int
+	DBConnMaxIdleTimeInSeconds       int
+	DBStatementTimeoutInMs           int
+	DBLogLevel                       string
+	CacheStore                       string
+	RedisMode                        string
+	RedisUrl                         string
+	RedisAddrs                       []string
+	RedisMasterName                  string
+	RedisUsername                    string
+	RedisPassword                    string
+	RedisSentinelPassword            string
+	RedisDB                          int
+	JwtPrivateKey                    string
+	AccessTokenExpiryInMinutes       int
+	SignInOtpLength                  int
+	SignInOtpAlphabet                string
+	SignInOtpExpiryInMinutes         int
+	EmailUpdateOtpLength             int
+	EmailUpdateOtpAlphabet           string
+	EmailUpdateOtpExpiryInMinutes    int
+	EmailChangeUndoUrl               string
+	EmailChangeUndoExpiryInHours     int
+	PublicMetadataMaxBytes           int
+	PublicMetadataSchema             string
+	PrivateMetadataMaxBytes          int
+	PrivateMetadataSchema            string
+	AppMetadataMaxBytes              int
+	AppMetadataSchema                string
+	JwtMetadataClaims                string
+	OtpMaxAttempts                   int
+	UserStatusExpiryInSeconds        int
+	AccountDeletionGracePeriodInDays int
+	AccountDeletionIntervalInMinutes int
+	AuditEventRetentionInDays        int
+	DataExportUrl                    string
+	DataExportExpiryInHours          int
+	DataExportIntervalInSeconds      int
+	AdminApiKey                      string
+	WebhookMaxAttempts               int
+	WebhookIntervalInSeconds         int
+	WebhookTimeoutInSeconds          int
+	EmailWorkers                     int
+	EmailMaxAttempts                 int
+	EmailIntervalInSeconds           int
+	EmailRetentionInDays             int
+	EmailEncryptionKey               []byte
+	OtpGenerateRateLimit             int
+	OtpGenerateRateLimitWindow       int
+	OtpGenerateRateLimitAlgorithm    string
+	OtpVerifyRateLimit               int
+	OtpVerifyRateLimitWindow         int
+	OtpVerifyRateLimitAlgorithm      string
+	AwsRegion                        string
+	AwsAccessKeyId                   string
+	AwsSecretAccessKey               string
+	AwsSesSender                     string
+	EmailProvider                    string
+	SmtpHost                         string
+	SmtpPort                         int
+	SmtpUsername                     string
+	SmtpPassword                     string
+	SmtpSender                       string
+	SmtpTls                          string
+	SmtpAuth                         string
+	SmtpPoolSize                     int
+	DevMode                          bool
+	DevMailboxDir                    string
+	DevMailboxSize                   int
+	EmailTemplateDir                 string
+	BlobProvider                     string
+	BlobDir                          string
+	BlobBaseUrl                      string
+	S3Bucket                         string
+	AvatarMaxBytes                   int
 }
 
 func InitSrvConfig() (*SrvConfig, error) {
@@ -41,14 +129,94 @@ func InitSrvConfig() (*SrvConfig, error) {
 	if port == "" {
 		port = "8080"
 	}
+	metricsPort := os.Getenv("METRICS_PORT")
+	if metricsPort != "" && metricsPort == port {
+		envErrors = append(envErrors, "metrics port must differ from port")
+	}
+	// Comma separated addresses or ranges of the proxies in front of the
+	// server, whose Forwarded and X-Forwarded-For headers are trusted
+	trustedProxies := []netip.Prefix{}
+	for _, item := range parseList(os.Getenv("TRUSTED_PROXIES")) {
+		prefix, err := parsePrefix(item)
+		if err != nil {
+			envErrors = append(envErrors, fmt.Sprintf("trusted proxy %q must be an ip address or range", item))
+			continue
+		}
+		trustedProxies = append(trustedProxies, prefix)
+	}
 	postgresUrl := os.Getenv("POSTGRES_URL")
 	if postgresUrl == "" {
 		envErrors = append(envErrors, "postgres url is required")
 	}
+	// Comma separated, reads that can be stale go to the replicas
+	postgresReplicaUrls := parseList(os.Getenv("POSTGRES_REPLICA_URLS"))
+	dbMaxOpenConns, ok := parseInt(os.Getenv("DB_MAX_OPEN_CONNS"))
+	if !ok {
+		dbMaxOpenConns = 25
+	}
+	dbMaxIdleConns, ok := parseInt(os.Getenv("DB_MAX_IDLE_CONNS"))
+	if !ok {
+		dbMaxIdleConns = 10
+	}
+	dbConnMaxLifetimeInSeconds, ok := parseInt(os.Getenv("DB_CONN_MAX_LIFETIME_IN_SECONDS"))
+	if !ok {
+		dbConnMaxLifetimeInSeconds = 1800
+	}
+	dbConnMaxIdleTimeInSeconds, ok := parseInt(os.Getenv("DB_CONN_MAX_IDLE_TIME_IN_SECONDS"))
+	if !ok {
+		dbConnMaxIdleTimeInSeconds = 300
+	}
+	dbStatementTimeoutInMs, ok := parseInt(os.Getenv("DB_STATEMENT_TIMEOUT_IN_MS"))
+	if !ok {
+		dbStatementTimeoutInMs = 10000
+	}
+	// debug, info, warn or error
+	logLevel := os.Getenv("LOG_LEVEL")
+	if logLevel == "" {
+		logLevel = "info"
+	}
+	// silent, error, warn or info
+	dbLogLevel := os.Getenv("DB_LOG_LEVEL")
+	if dbLogLevel == "" {
+		dbLogLevel = "warn"
+	}
+	// The memory cache store only suits a single server
+	cacheStore := os.Getenv("CACHE_STORE")
+	if cacheStore == "" {
+		cacheStore = CacheStoreRedis
+	}
+	if cacheStore != CacheStoreRedis && cacheStore != CacheStoreMemory {
+		envErrors = append(envErrors, "cache store must be one of redis, memory")
+	}
+	// standalone, sentinel or cluster
+	redisMode := os.Getenv("REDIS_MODE")
+	if redisMode == "" {
+		redisMode = "standalone"
+	}
+	if redisMode != "standalone" && redisMode != "sentinel" && redisMode != "cluster" {
+		envErrors = append(envErrors, "redis mode must be one of standalone, sentinel, cluster")
+	}
+	useRedis := cacheStore == CacheStoreRedis
 	redisUrl := os.Getenv("REDIS_URL")
-	if redisUrl == "" {
+	if redisUrl == "" && useRedis && redisMode == "standalone" {
 		envErrors = append(envErrors, "redis url is required")
 	}
+	// Sentinel addresses in sentinel mode, seed nodes in cluster mode
+	redisAddrs := parseList(os.Getenv("REDIS_ADDRS"))
+	if len(redisAddrs) == 0 && useRedis && redisMode != "standalone" {
+		envErrors = append(envErrors, "redis addrs are required")
+	}
+	redisMasterName := os.Getenv("REDIS_MASTER_NAME")
+	if redisMasterName == "" && useRedis && redisMode == "sentinel" {
+		envErrors = append(envErrors, "redis master name is required")
+	}
+	redisUsername := os.Getenv("REDIS_USERNAME")
+	redisPassword := os.Getenv("REDIS_PASSWORD")
+	redisSentinelPassword := os.Getenv("REDIS_SENTINEL_PASSWORD")
+	redisDB, ok := parseInt(os.Getenv("REDIS_DB"))
+	if !ok {
+		redisDB = 0
+	}
 	jwtPrivateKey := os.Getenv("JWT_BASE64_ENCODED_PRIVATE_KEY")
 	if jwtPrivateKey == "" {
 		envErrors = append(envErrors, "jwt private key is required")
@@ -57,15 +225,193 @@ func InitSrvConfig() (*SrvConfig, error) {
 	if !ok {
 		accessTokenExpiryInMinutes = 86400
 	}
+	// OTP_* apply to every otp scope unless overridden for the scope
+	otpLength, ok := parseInt(os.Getenv("OTP_LENGTH"))
+	if !ok {
+		otpLength = 6
+	}
+	otpAlphabet := os.Getenv("OTP_ALPHABET")
+	if otpAlphabet == "" {
+		otpAlphabet = "numeric"
+	}
 	otpExpiryInMinutes, ok := parseInt(os.Getenv("OTP_EXPIRY_IN_MINUTES"))
 	if !ok {
 		otpExpiryInMinutes = 5
 	}
-	otpGenerateRateLimit, ok := parseInt(os.Getenv("OTP_VERIFY_RATE_LIMIT"))
+	signInOtpLength, ok := parseInt(os.Getenv("SIGN_IN_OTP_LENGTH"))
+	if !ok {
+		signInOtpLength = otpLength
+	}
+	signInOtpAlphabet := os.Getenv("SIGN_IN_OTP_ALPHABET")
+	if signInOtpAlphabet == "" {
+		signInOtpAlphabet = otpAlphabet
+	}
+	signInOtpExpiryInMinutes, ok := parseInt(os.Getenv("SIGN_IN_OTP_EXPIRY_IN_MINUTES"))
+	if !ok {
+		signInOtpExpiryInMinutes = otpExpiryInMinutes
+	}
+	emailUpdateOtpLength, ok := parseInt(os.Getenv("EMAIL_UPDATE_OTP_LENGTH"))
+	if !ok {
+		emailUpdateOtpLength = otpLength
+	}
+	emailUpdateOtpAlphabet := os.Getenv("EMAIL_UPDATE_OTP_ALPHABET")
+	if emailUpdateOtpAlphabet == "" {
+		emailUpdateOtpAlphabet = otpAlphabet
+	}
+	emailUpdateOtpExpiryInMinutes, ok := parseInt(os.Getenv("EMAIL_UPDATE_OTP_EXPIRY_IN_MINUTES"))
+	if !ok {
+		emailUpdateOtpExpiryInMinutes = otpExpiryInMinutes
+	}
+	otpMaxAttempts, ok := parseInt(os.Getenv("OTP_MAX_ATTEMPTS"))
+	if !ok {
+		otpMaxAttempts = 5
+	}
+	if otpMaxAttempts < 1 {
+		envErrors = append(envErrors, "otp max attempts must be at least 1")
+	}
+	// Usually a page of the client app posting the token to /user/email/undo
+	emailChangeUndoUrl := os.Getenv("EMAIL_CHANGE_UNDO_URL")
+	if emailChangeUndoUrl == "" {
+		emailChangeUndoUrl = host + "/user/email/undo"
+	}
+	emailChangeUndoExpiryInHours, ok := parseInt(os.Getenv("EMAIL_CHANGE_UNDO_EXPIRY_IN_HOURS"))
+	if !ok {
+		emailChangeUndoExpiryInHours = 72
+	}
+	if emailChangeUndoExpiryInHours < 1 {
+		envErrors = append(envErrors, "email change undo expiry in hours must be at least 1")
+	}
+	publicMetadataMaxBytes, ok := parseInt(os.Getenv("PUBLIC_METADATA_MAX_BYTES"))
+	if !ok {
+		publicMetadataMaxBytes = 4096
+	}
+	privateMetadataMaxBytes, ok := parseInt(os.Getenv("PRIVATE_METADATA_MAX_BYTES"))
+	if !ok {
+		privateMetadataMaxBytes = 8192
+	}
+	appMetadataMaxBytes, ok := parseInt(os.Getenv("APP_METADATA_MAX_BYTES"))
+	if !ok {
+		appMetadataMaxBytes = 8192
+	}
+	// Optional JSON Schema files the metadata fields must satisfy
+	publicMetadataSchema := os.Getenv("PUBLIC_METADATA_SCHEMA")
+	privateMetadataSchema := os.Getenv("PRIVATE_METADATA_SCHEMA")
+	appMetadataSchema := os.Getenv("APP_METADATA_SCHEMA")
+	// Metadata keys copied into access tokens, e.g. public.plan,app.roles
+	jwtMetadataClaims := os.Getenv("JWT_METADATA_CLAIMS")
+	userStatusExpiryInSeconds, ok := parseInt(os.Getenv("USER_STATUS_EXPIRY_IN_SECONDS"))
+	if !ok {
+		userStatusExpiryInSeconds = 30
+	}
+	if userStatusExpiryInSeconds < 1 {
+		envErrors = append(envErrors, "user status expiry in seconds must be at least 1")
+	}
+	accountDeletionGracePeriodInDays, ok := parseInt(os.Getenv("ACCOUNT_DELETION_GRACE_PERIOD_IN_DAYS"))
+	if !ok {
+		accountDeletionGracePeriodInDays = 30
+	}
+	if accountDeletionGracePeriodInDays < 1 {
+		envErrors = append(envErrors, "account deletion grace period in days must be at least 1")
+	}
+	accountDeletionIntervalInMinutes, ok := parseInt(os.Getenv("ACCOUNT_DELETION_INTERVAL_IN_MINUTES"))
+	if !ok {
+		accountDeletionIntervalInMinutes = 60
+	}
+	if accountDeletionIntervalInMinutes < 1 {
+		envErrors = append(envErrors, "account deletion interval in minutes must be at least 1")
+	}
+	auditEventRetentionInDays, ok := parseInt(os.Getenv("AUDIT_EVENT_RETENTION_IN_DAYS"))
+	if !ok {
+		auditEventRetentionInDays = 365
+	}
+	// The retention job deletes every event older than the period
+	if auditEventRetentionInDays < 1 {
+		envErrors = append(envErrors, "audit event retention in days must be at least 1")
+	}
+	// Usually a page of the client app posting the token to /user/export/download
+	dataExportUrl := os.Getenv("DATA_EXPORT_URL")
+	if dataExportUrl == "" {
+		dataExportUrl = host + "/user/export/download"
+	}
+	dataExportExpiryInHours, ok := parseInt(os.Getenv("DATA_EXPORT_EXPIRY_IN_HOURS"))
+	if !ok {
+		dataExportExpiryInHours = 72
+	}
+	dataExportIntervalInSeconds, ok := parseInt(os.Getenv("DATA_EXPORT_INTERVAL_IN_SECONDS"))
+	if !ok {
+		dataExportIntervalInSeconds = 30
+	}
+	if dataExportExpiryInHours < 1 {
+		envErrors = append(envErrors, "data export expiry in hours must be at least 1")
+	}
+	if dataExportIntervalInSeconds < 1 {
+		envErrors = append(envErrors, "data export interval in seconds must be at least 1")
+	}
+	adminApiKey := os.Getenv("ADMIN_API_KEY")
+	webhookMaxAttempts, ok := parseInt(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if !ok {
+		webhookMaxAttempts = 8
+	}
+	webhookIntervalInSeconds, ok := parseInt(os.Getenv("WEBHOOK_INTERVAL_IN_SECONDS"))
+	if !ok {
+		webhookIntervalInSeconds = 10
+	}
+	webhookTimeoutInSeconds, ok := parseInt(os.Getenv("WEBHOOK_TIMEOUT_IN_SECONDS"))
+	if !ok {
+		webhookTimeoutInSeconds = 10
+	}
+	if webhookMaxAttempts < 1 {
+		envErrors = append(envErrors, "webhook max attempts must be at least 1")
+	}
+	if webhookIntervalInSeconds < 1 {
+		envErrors = append(envErrors, "webhook interval in seconds must be at least 1")
+	}
+	if webhookTimeoutInSeconds < 1 {
+		envErrors = append(envErrors, "webhook timeout in seconds must be at least 1")
+	}
+	emailWorkers, ok := parseInt(os.Getenv("EMAIL_WORKERS"))
+	if !ok {
+		emailWorkers = 4
+	}
+	emailMaxAttempts, ok := parseInt(os.Getenv("EMAIL_MAX_ATTEMPTS"))
+	if !ok {
+		emailMaxAttempts = 5
+	}
+	emailIntervalInSeconds, ok := parseInt(os.Getenv("EMAIL_INTERVAL_IN_SECONDS"))
+	if !ok {
+		emailIntervalInSeconds = 2
+	}
+	if emailWorkers < 1 {
+		envErrors = append(envErrors, "email workers must be at least 1")
+	}
+	if emailMaxAttempts < 1 {
+		envErrors = append(envErrors, "email max attempts must be at least 1")
+	}
+	if emailIntervalInSeconds < 1 {
+		envErrors = append(envErrors, "email interval in seconds must be at least 1")
+	}
+	emailRetentionInDays, ok := parseInt(os.Getenv("EMAIL_RETENTION_IN_DAYS"))
+	if !ok {
+		emailRetentionInDays = 30
+	}
+	// The retention job deletes every email sent before the period
+	if emailRetentionInDays < 1 {
+		envErrors = append(envErrors, "email retention in days must be at least 1")
+	}
+	// Seals dead lettered emails, derived from the jwt private key when unset
+	var emailEncryptionKey []byte
+	if v := os.Getenv("EMAIL_ENCRYPTION_KEY"); v != "" {
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(key) != 32 {
+			envErrors = append(envErrors, "email encryption key must be 32 base64 encoded bytes")
+		}
+		emailEncryptionKey = key
+	}
+	otpGenerateRateLimit, ok := parseInt(os.Getenv("OTP_GENERATE_RATE_LIMIT"))
 	if !ok {
 		otpGenerateRateLimit = 3
 	}
-	otpGenerateRateLimitWindow, ok := parseInt(os.Getenv("OTP_VERIFY_RATE_LIMIT_WINDOW"))
+	otpGenerateRateLimitWindow, ok := parseInt(os.Getenv("OTP_GENERATE_RATE_LIMIT_WINDOW"))
 	if !ok {
 		otpGenerateRateLimitWindow = 7200
 	}
@@ -77,46 +423,229 @@ func InitSrvConfig() (*SrvConfig, error) {
 	if !ok {
 		otpVerifyRateLimitWindow = 86400
 	}
+	// sliding_log, fixed_window, token_bucket or gcra
+	rateLimitAlgorithm := os.Getenv("RATE_LIMIT_ALGORITHM")
+	if rateLimitAlgorithm == "" {
+		rateLimitAlgorithm = "sliding_log"
+	}
+	otpGenerateRateLimitAlgorithm := os.Getenv("OTP_GENERATE_RATE_LIMIT_ALGORITHM")
+	if otpGenerateRateLimitAlgorithm == "" {
+		otpGenerateRateLimitAlgorithm = rateLimitAlgorithm
+	}
+	otpVerifyRateLimitAlgorithm := os.Getenv("OTP_VERIFY_RATE_LIMIT_ALGORITHM")
+	if otpVerifyRateLimitAlgorithm == "" {
+		otpVerifyRateLimitAlgorithm = rateLimitAlgorithm
+	}
+
+	emailProvider := os.Getenv("EMAIL_PROVIDER")
+	if emailProvider == "" {
+		emailProvider = EmailProviderSES
+	}
+	if emailProvider != EmailProviderSES && emailProvider != EmailProviderSMTP && emailProvider != EmailProviderDev {
+		envErrors = append(envErrors, "email provider must be one of ses, smtp, dev")
+	}
+
+	blobProvider := os.Getenv("BLOB_PROVIDER")
+	if blobProvider == "" {
+		blobProvider = BlobProviderLocal
+	}
+	if blobProvider != BlobProviderLocal && blobProvider != BlobProviderS3 {
+		envErrors = append(envErrors, "blob provider must be one of local, s3")
+	}
+	useAws := emailProvider == EmailProviderSES || blobProvider == BlobProviderS3
 
 	awsRegion := os.Getenv("AWS_REGION")
 	if awsRegion == "" {
 		awsRegion = "ap-south-1"
 	}
 	awsAccessKeyId := os.Getenv("AWS_ACCESS_KEY_ID")
-	if awsAccessKeyId == "" {
+	if awsAccessKeyId == "" && useAws {
 		envErrors = append(envErrors, "aws access key id is required")
 	}
 	awsSecretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	if awsSecretAccessKey == "" {
+	if awsSecretAccessKey == "" && useAws {
 		envErrors = append(envErrors, "aws secret access key is required")
 	}
 	awsSesSender := os.Getenv("AWS_SES_SENDER")
 	if awsSesSender == "" {
 		awsSesSender = "auth@elevatr.in"
 	}
+
+	smtpHost := os.Getenv("SMTP_HOST")
+	if smtpHost == "" && emailProvider == EmailProviderSMTP {
+		envErrors = append(envErrors, "smtp host is required")
+	}
+	smtpPort, ok := parseInt(os.Getenv("SMTP_PORT"))
+	if !ok {
+		smtpPort = 587
+	}
+	smtpUsername := os.Getenv("SMTP_USERNAME")
+	smtpPassword := os.Getenv("SMTP_PASSWORD")
+	smtpSender := os.Getenv("SMTP_SENDER")
+	if smtpSender == "" {
+		smtpSender = awsSesSender
+	}
+	smtpTls := os.Getenv("SMTP_TLS")
+	if smtpTls == "" {
+		smtpTls = "starttls"
+	}
+	smtpAuth := os.Getenv("SMTP_AUTH")
+	if smtpAuth == "" {
+		smtpAuth = "plain"
+	}
+	smtpPoolSize, ok := parseInt(os.Getenv("SMTP_POOL_SIZE"))
+	if !ok {
+		smtpPoolSize = 2
+	}
+	// Serves development helpers such as the dev mailbox without credentials
+	devMode, _ := strconv.ParseBool(os.Getenv("DEV_MODE"))
+	devMailboxDir := os.Getenv("DEV_MAILBOX_DIR")
+	if devMailboxDir == "" {
+		devMailboxDir = "tmp/mailbox"
+	}
+	devMailboxSize, ok := parseInt(os.Getenv("DEV_MAILBOX_SIZE"))
+	if !ok {
+		devMailboxSize = 50
+	}
+	// Optional directory with templates overriding the embedded ones
+	emailTemplateDir := os.Getenv("EMAIL_TEMPLATE_DIR")
+	blobDir := os.Getenv("BLOB_DIR")
+	if blobDir == "" {
+		blobDir = "tmp/blobs"
+	}
+	// Where stored objects are served from, the bucket itself when empty for s3
+	blobBaseUrl := os.Getenv("BLOB_BASE_URL")
+	if blobBaseUrl == "" && blobProvider == BlobProviderLocal {
+		blobBaseUrl = host + "/blobs"
+	}
+	s3Bucket := os.Getenv("S3_BUCKET")
+	if s3Bucket == "" && blobProvider == BlobProviderS3 {
+		envErrors = append(envErrors, "s3 bucket is required")
+	}
+	avatarMaxBytes, ok := parseInt(os.Getenv("AVATAR_MAX_BYTES"))
+	if !ok {
+		avatarMaxBytes = 5 << 20
+	}
 	if len(envErrors) != 0 {
 		return nil, errors.New(strings.Join(envErrors, "\n"))
 	}
 
 	return &SrvConfig{
-		Host:                       host,
-		Port:                       port,
-		PostgresUrl:                postgresUrl,
-		RedisUrl:                   redisUrl,
-		JwtPrivateKey:              jwtPrivateKey,
-		AccessTokenExpiryInMinutes: accessTokenExpiryInMinutes,
-		OtpExpiryInMinutes:         otpExpiryInMinutes,
-		OtpGenerateRateLimit:       otpGenerateRateLimit,
-		OtpGenerateRateLimitWindow: otpGenerateRateLimitWindow,
-		OtpVerifyRateLimit:         otpVerifyRateLimit,
-		OtpVerifyRateLimitWindow:   otpVerifyRateLimitWindow,
-		AwsRegion:                  awsRegion,
-		AwsAccessKeyId:             awsAccessKeyId,
-		AwsSecretAccessKey:         awsSecretAccessKey,
-		AwsSesSender:               awsSesSender,
+		Host:                             host,
+		Port:                             port,
+		MetricsPort:                      metricsPort,
+		TrustedProxies:                   trustedProxies,
+		LogLevel:                         logLevel,
+		PostgresUrl:                      postgresUrl,
+		PostgresReplicaUrls:              postgresReplicaUrls,
+		DBMaxOpenConns:                   dbMaxOpenConns,
+		DBMaxIdleConns:                   dbMaxIdleConns,
+		DBConnMaxLifetimeInSeconds:       dbConnMaxLifetimeInSeconds,
+		DBConnMaxIdleTimeInSeconds:       dbConnMaxIdleTimeInSeconds,
+		DBStatementTimeoutInMs:           dbStatementTimeoutInMs,
+		DBLogLevel:                       dbLogLevel,
+		CacheStore:                       cacheStore,
+		RedisMode:                        redisMode,
+		RedisUrl:                         redisUrl,
+		RedisAddrs:                       redisAddrs,
+		RedisMasterName:                  redisMasterName,
+		RedisUsername:                    redisUsername,
+		RedisPassword:                    redisPassword,
+		RedisSentinelPassword:            redisSentinelPassword,
+		RedisDB:                          redisDB,
+		JwtPrivateKey:                    jwtPrivateKey,
+		AccessTokenExpiryInMinutes:       accessTokenExpiryInMinutes,
+		SignInOtpLength:                  signInOtpLength,
+		SignInOtpAlphabet:                signInOtpAlphabet,
+		SignInOtpExpiryInMinutes:         signInOtpExpiryInMinutes,
+		EmailUpdateOtpLength:             emailUpdateOtpLength,
+		EmailUpdateOtpAlphabet:           emailUpdateOtpAlphabet,
+		EmailUpdateOtpExpiryInMinutes:    emailUpdateOtpExpiryInMinutes,
+		EmailChangeUndoUrl:               emailChangeUndoUrl,
+		EmailChangeUndoExpiryInHours:     emailChangeUndoExpiryInHours,
+		PublicMetadataMaxBytes:           publicMetadataMaxBytes,
+		PublicMetadataSchema:             publicMetadataSchema,
+		PrivateMetadataMaxBytes:          privateMetadataMaxBytes,
+		PrivateMetadataSchema:            privateMetadataSchema,
+		AppMetadataMaxBytes:              appMetadataMaxBytes,
+		AppMetadataSchema:                appMetadataSchema,
+		JwtMetadataClaims:                jwtMetadataClaims,
+		OtpMaxAttempts:                   otpMaxAttempts,
+		UserStatusExpiryInSeconds:        userStatusExpiryInSeconds,
+		AccountDeletionGracePeriodInDays: accountDeletionGracePeriodInDays,
+		AccountDeletionIntervalInMinutes: accountDeletionIntervalInMinutes,
+		AuditEventRetentionInDays:        auditEventRetentionInDays,
+		DataExportUrl:                    dataExportUrl,
+		DataExportExpiryInHours:          dataExportExpiryInHours,
+		DataExportIntervalInSeconds:      dataExportIntervalInSeconds,
+		AdminApiKey:                      adminApiKey,
+		WebhookMaxAttempts:               webhookMaxAttempts,
+		WebhookIntervalInSeconds:         webhookIntervalInSeconds,
+		WebhookTimeoutInSeconds:          webhookTimeoutInSeconds,
+		EmailWorkers:                     emailWorkers,
+		EmailMaxAttempts:                 emailMaxAttempts,
+		EmailIntervalInSeconds:           emailIntervalInSeconds,
+		EmailRetentionInDays:             emailRetentionInDays,
+		EmailEncryptionKey:               emailEncryptionKey,
+		OtpGenerateRateLimit:             otpGenerateRateLimit,
+		OtpGenerateRateLimitWindow:       otpGenerateRateLimitWindow,
+		OtpGenerateRateLimitAlgorithm:    otpGenerateRateLimitAlgorithm,
+		OtpVerifyRateLimit:               otpVerifyRateLimit,
+		OtpVerifyRateLimitWindow:         otpVerifyRateLimitWindow,
+		OtpVerifyRateLimitAlgorithm:      otpVerifyRateLimitAlgorithm,
+		AwsRegion:                        awsRegion,
+		AwsAccessKeyId:                   awsAccessKeyId,
+		AwsSecretAccessKey:               awsSecretAccessKey,
+		AwsSesSender:                     awsSesSender,
+		EmailProvider:                    emailProvider,
+		SmtpHost:                         smtpHost,
+		SmtpPort:                         smtpPort,
+		SmtpUsername:                     smtpUsername,
+		SmtpPassword:                     smtpPassword,
+		SmtpSender:                       smtpSender,
+		SmtpTls:                          smtpTls,
+		SmtpAuth:                         smtpAuth,
+		SmtpPoolSize:                     smtpPoolSize,
+		DevMode:                          devMode,
+		DevMailboxDir:                    devMailboxDir,
+		DevMailboxSize:                   devMailboxSize,
+		EmailTemplateDir:                 emailTemplateDir,
+		BlobProvider:                     blobProvider,
+		BlobDir:                          blobDir,
+		BlobBaseUrl:                      blobBaseUrl,
+		S3Bucket:                         s3Bucket,
+		AvatarMaxBytes:                   avatarMaxBytes,
 	}, nil
 }
 
+func parseList(str string) []string {
+	list := []string{}
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// parsePrefix parses a range in CIDR notation or a single address.
+func parsePrefix(str string) (netip.Prefix, error) {
+	if strings.Contains(str, "/") {
+		prefix, err := netip.ParsePrefix(str)
+
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(str)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
 func parseInt(str string) (int, bool) {
 	i, err := strconv.Atoi(str)
 	if err != nil {
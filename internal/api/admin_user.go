@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/logger"
+	"github.com/nkbhasker/go-auth-starter/internal/metadata"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/repo"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+var errUserPendingDeletion = httperrors.NewHttpError(http.StatusConflict, "user_pending_deletion", "user is pending deletion")
+
+type adminUserHandler struct {
+	app               core.App
+	metadataValidator metadata.Validator
+}
+
+// adminUser exposes the server only fields of a user to admins.
+type adminUser struct {
+	*model.User
+	PrivateMetadata model.Metadata `json:"privateMetadata"`
+}
+
+// updateMetadataRequestBody holds a merge patch for each metadata field,
+// fields left out are unchanged.
+type updateMetadataRequestBody struct {
+	PublicMetadata  json.RawMessage `json:"publicMetadata"`
+	PrivateMetadata json.RawMessage `json:"privateMetadata"`
+	AppMetadata     json.RawMessage `json:"appMetadata"`
+}
+
+// updateStatusRequestBody suspends a user, until suspendedUntil when set,
+// or reactivates them.
+type updateStatusRequestBody struct {
+	Status         enum.UserStatusEnum `json:"status" validate:"required,oneof=ACTIVE SUSPENDED"`
+	Reason         *string             `json:"reason" validate:"omitempty,max=500"`
+	SuspendedUntil *time.Time          `json:"suspendedUntil"`
+}
+
+func NewAdminUserHandler(app core.App, metadataValidator metadata.Validator) *adminUserHandler {
+	return &adminUserHandler{
+		app:               app,
+		metadataValidator: metadataValidator,
+	}
+}
+
+func (h *adminUserHandler) GetHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := func() (*model.User, error) {
+			id, err := uid.FromIdString(chi.URLParam(r, "id"))
+			if err != nil {
+				return nil, invalidParam("id")
+			}
+
+			return h.app.Repo().UserRepo().Get(r.Context(), id)
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", userETag(user))
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+			"user":    &adminUser{User: user, PrivateMetadata: user.PrivateMetadata},
+		})
+	}
+}
+
+// UpdateMetadataHandler merge patches any of the metadata fields of a user.
+func (h *adminUserHandler) UpdateMetadataHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := func() (*model.User, error) {
+			id, err := uid.FromIdString(chi.URLParam(r, "id"))
+			if err != nil {
+				return nil, invalidParam("id")
+			}
+			body := &updateMetadataRequestBody{}
+			err = json.NewDecoder(r.Body).Decode(body)
+			if err != nil {
+				return nil, err
+			}
+			user, err := h.app.Repo().UserRepo().WithPrimary().Get(r.Context(), id)
+			if err != nil {
+				return nil, err
+			}
+			if !ifMatch(r, userETag(user)) {
+				return nil, httperrors.ErrPreconditionFailed
+			}
+			fields := []struct {
+				name   string
+				column string
+				field  enum.MetadataFieldEnum
+				value  model.Metadata
+				patch  json.RawMessage
+			}{
+				{"publicMetadata", "public_metadata", enum.MetadataFieldPublic, user.PublicMetadata, body.PublicMetadata},
+				{"privateMetadata", "private_metadata", enum.MetadataFieldPrivate, user.PrivateMetadata, body.PrivateMetadata},
+				{"appMetadata", "app_metadata", enum.MetadataFieldApp, user.AppMetadata, body.AppMetadata},
+			}
+			errs := fieldErrors{}
+			columns := map[string]interface{}{}
+			for _, f := range fields {
+				if f.patch == nil {
+					continue
+				}
+				value, err := metadata.Merge(f.value, f.patch)
+				if err == nil {
+					err = h.metadataValidator.Validate(f.field, value)
+				}
+				if err != nil {
+					errs[f.name] = err.Error()
+					continue
+				}
+				columns[f.column] = value
+			}
+			if len(errs) != 0 {
+				return nil, errs
+			}
+			if len(columns) == 0 {
+				return user, nil
+			}
+			err = h.app.Repo().InTx(r.Context(), func(txRepo repo.Repo) error {
+				err := txRepo.UserRepo().Patch(r.Context(), user, columns)
+				if err != nil {
+					return err
+				}
+				user, err = txRepo.UserRepo().Get(r.Context(), user.ID)
+				if err != nil {
+					return err
+				}
+
+				return txRepo.WebhookRepo().Enqueue(r.Context(), user.ID, enum.WebhookEventTypeUserUpdated, map[string]interface{}{
+					"user": user,
+				})
+			})
+			if errors.Is(err, repo.ErrUserModified) {
+				return nil, httperrors.ErrPreconditionFailed
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			return user, nil
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", userETag(user))
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+			"user":    &adminUser{User: user, PrivateMetadata: user.PrivateMetadata},
+		})
+	}
+}
+
+// UpdateStatusHandler suspends or reactivates a user. Suspending a user also
+// revokes their access tokens, so that they are signed out right away.
+func (h *adminUserHandler) UpdateStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := func() (*model.User, error) {
+			id, err := uid.FromIdString(chi.URLParam(r, "id"))
+			if err != nil {
+				return nil, invalidParam("id")
+			}
+			body := &updateStatusRequestBody{}
+			err = json.NewDecoder(r.Body).Decode(body)
+			if err != nil {
+				return nil, err
+			}
+			err = h.app.Validate().Struct(body)
+			if err != nil {
+				return nil, err
+			}
+			if body.SuspendedUntil != nil {
+				if body.Status != enum.UserStatusSuspended {
+					return nil, fieldErrors{"suspendedUntil": "is only allowed when suspending"}
+				}
+				if !body.SuspendedUntil.After(time.Now()) {
+					return nil, fieldErrors{"suspendedUntil": "must be in the future"}
+				}
+			}
+			user, err := h.app.Repo().UserRepo().WithPrimary().Get(r.Context(), id)
+			if err != nil {
+				return nil, err
+			}
+			// Deletion is requested by the user and must go through
+			if user.Status == enum.UserStatusPendingDeletion {
+				return nil, errUserPendingDeletion
+			}
+			eventType := enum.AuditEventTypeUserReactivated
+			if body.Status == enum.UserStatusSuspended {
+				eventType = enum.AuditEventTypeUserSuspended
+			}
+			err = h.app.Repo().InTx(r.Context(), func(txRepo repo.Repo) error {
+				err := txRepo.UserStatusRepo().Update(r.Context(), user.ID, body.Status, body.Reason, body.SuspendedUntil)
+				if err != nil {
+					return err
+				}
+				user, err = txRepo.UserRepo().Get(r.Context(), user.ID)
+				if err != nil {
+					return err
+				}
+				err = txRepo.AuditEventRepo().Record(r.Context(), auditEvent(r, model.AuditEvent{
+					UserID:  user.ID,
+					Email:   user.Email,
+					Type:    eventType,
+					Outcome: enum.AuditOutcomeSuccess,
+					Reason:  body.Reason,
+				}))
+				if err != nil {
+					return err
+				}
+
+				return txRepo.WebhookRepo().Enqueue(r.Context(), user.ID, enum.WebhookEventTypeUserUpdated, map[string]interface{}{
+					"user": user,
+				})
+			})
+			if err != nil {
+				return nil, err
+			}
+			// The status may have been cached again before the transaction
+			// committed
+			err = h.app.Repo().UserStatusRepo().Invalidate(r.Context(), user.ID)
+			if err != nil {
+				logger.FromContext(r.Context()).Error("failed to invalidate user status", "error", err)
+			}
+			if body.Status == enum.UserStatusSuspended {
+				err = h.app.Repo().AccessTokenRepo().RevokeAll(r.Context(), user.ID.String())
+				reason := "user suspended"
+				outcome, _ := auditOutcome(err)
+				recordAuditEvent(h.app, r, model.AuditEvent{
+					UserID:  user.ID,
+					Email:   user.Email,
+					Type:    enum.AuditEventTypeTokenRevoked,
+					Outcome: outcome,
+					Reason:  &reason,
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return user, nil
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", userETag(user))
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+			"user":    &adminUser{User: user, PrivateMetadata: user.PrivateMetadata},
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/logger"
+	"github.com/nkbhasker/go-auth-starter/internal/misc"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/repo"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+type auditEventHandler struct {
+	app core.App
+}
+
+func NewAuditEventHandler(app core.App) *auditEventHandler {
+	return &auditEventHandler{app: app}
+}
+
+func (h *auditEventHandler) MeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events, err := func() ([]*model.AuditEvent, error) {
+			identity := core.IdentityFromContext(r.Context())
+			filter, err := parseAuditEventFilter(r, false)
+			if err != nil {
+				return nil, err
+			}
+			filter.UserID = identity.UserID()
+
+			return h.app.Repo().AuditEventRepo().Search(r.Context(), filter)
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+			"events":  events,
+		})
+	}
+}
+
+func (h *auditEventHandler) SearchHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events, err := func() ([]*model.AuditEvent, error) {
+			filter, err := parseAuditEventFilter(r, true)
+			if err != nil {
+				return nil, err
+			}
+
+			return h.app.Repo().AuditEventRepo().Search(r.Context(), filter)
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+			"events":  events,
+		})
+	}
+}
+
+// recordAuditEvent stores an audit event for the request. Failing to record
+// an event is logged and never fails the request itself.
+func recordAuditEvent(app core.App, r *http.Request, event model.AuditEvent) {
+	event = auditEvent(r, event)
+	err := app.Repo().AuditEventRepo().Record(r.Context(), event)
+	if err != nil {
+		logger.FromContext(r.Context()).Error("failed to record audit event", "type", event.Type, "error", err)
+	}
+}
+
+// auditEvent returns event with the client of the request, for events
+// recorded in a transaction.
+func auditEvent(r *http.Request, event model.AuditEvent) model.AuditEvent {
+	ip := misc.GetIP(r)
+	event.IP = &ip
+	if userAgent := r.UserAgent(); userAgent != "" {
+		event.UserAgent = &userAgent
+	}
+
+	return event
+}
+
+// auditOutcome returns the outcome of a request failing with err, the reason
+// being the problem code clients are shown so that internals never leak
+// through the security events of users.
+func auditOutcome(err error) (enum.AuditOutcomeEnum, *string) {
+	if err != nil {
+		reason := httperrors.ErrInternal.Code()
+		var httpErr httperrors.HttpError
+		if errors.As(httpError(err), &httpErr) {
+			reason = httpErr.Code()
+		}
+		return enum.AuditOutcomeFailure, &reason
+	}
+
+	return enum.AuditOutcomeSuccess, nil
+}
+
+func parseAuditEventFilter(r *http.Request, admin bool) (repo.AuditEventFilter, error) {
+	query := r.URL.Query()
+	filter := repo.AuditEventFilter{}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return filter, invalidParam("limit")
+		}
+		filter.Limit = limit
+	}
+	if v := query.Get("before"); v != "" {
+		before, err := uid.FromIdString(v)
+		if err != nil {
+			return filter, invalidParam("before")
+		}
+		filter.Before = before
+	}
+	if v := query.Get("type"); v != "" {
+		eventType := enum.AuditEventTypeEnum(v)
+		filter.Type = &eventType
+	}
+	if v := query.Get("from"); v != "" {
+		from, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, invalidParam("from")
+		}
+		filter.From = &from
+	}
+	if v := query.Get("to"); v != "" {
+		to, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, invalidParam("to")
+		}
+		filter.To = &to
+	}
+	if !admin {
+		return filter, nil
+	}
+	if v := query.Get("userId"); v != "" {
+		userId, err := uid.FromIdString(v)
+		if err != nil {
+			return filter, invalidParam("userId")
+		}
+		filter.UserID = userId
+	}
+	if v := query.Get("email"); v != "" {
+		filter.Email = &v
+	}
+	if v := query.Get("ip"); v != "" {
+		filter.IP = &v
+	}
+
+	return filter, nil
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-
-	"github.com/muhlemmer/httpforwarded"
+	"time"
 
 	"github.com/go-chi/render"
 	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/metadata"
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
 	"github.com/nkbhasker/go-auth-starter/internal/misc"
 	"github.com/nkbhasker/go-auth-starter/internal/model"
 	"github.com/nkbhasker/go-auth-starter/internal/repo"
@@ -23,14 +25,21 @@ const (
 )
 
 type authHandler struct {
-	app                    core.App
-	otpGenerateRateLimiter core.RateLimiter
-	otpVerifyRateLimiter   core.RateLimiter
+	app core.App
+	// Only reset here, the limit applies in front of the sign in route
+	otpVerifyRateLimiter core.RateLimiter
+	otpPolicies          map[OtpScopeEnum]misc.OtpPolicy
+	// Where the link to undo an email change points to, with the token
+	// passed as a query parameter
+	emailChangeUndoUrl       string
+	emailChangeUndoExpiresIn time.Duration
+	metadataClaims           metadata.Projection
 }
 
 type otpRequestBody struct {
 	Email string `json:"email" validate:"required,email"`
-	Scope string `json:"scope" validate:"oneof=SIGN_IN EMAIL_UPDATE"`
+	// Email update otps are requested through /user/me/email/otp
+	Scope string `json:"scope" validate:"oneof=SIGN_IN"`
 }
 
 type signInRequestBody struct {
@@ -38,23 +47,29 @@ type signInRequestBody struct {
 	OTP   string `json:"otp" validate:"required"`
 }
 
-func NewAuthHandler(app core.App, otpGenerateRateLimiter core.RateLimiter, otpVerifyRateLimiter core.RateLimiter) *authHandler {
+func NewAuthHandler(
+	app core.App,
+	otpVerifyRateLimiter core.RateLimiter,
+	otpPolicies map[OtpScopeEnum]misc.OtpPolicy,
+	emailChangeUndoUrl string,
+	emailChangeUndoExpiryInHours int,
+	metadataClaims metadata.Projection,
+) *authHandler {
 	return &authHandler{
-		app:                    app,
-		otpGenerateRateLimiter: otpGenerateRateLimiter,
-		otpVerifyRateLimiter:   otpVerifyRateLimiter,
+		app:                      app,
+		otpVerifyRateLimiter:     otpVerifyRateLimiter,
+		otpPolicies:              otpPolicies,
+		emailChangeUndoUrl:       emailChangeUndoUrl,
+		emailChangeUndoExpiresIn: time.Duration(emailChangeUndoExpiryInHours * int(time.Hour)),
+		metadataClaims:           metadataClaims,
 	}
 }
 
 func (h *authHandler) OtpHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		otpBody := &otpRequestBody{}
 		err := func() error {
-			ok, err := h.otpGenerateRateLimiter.Evaluate(GetIP(r))
-			if !ok || err != nil {
-				return fmt.Errorf("too many otp requests")
-			}
-			otpBody := &otpRequestBody{}
-			err = json.NewDecoder(r.Body).Decode(otpBody)
+			err := json.NewDecoder(r.Body).Decode(otpBody)
 			if err != nil {
 				return err
 			}
@@ -65,28 +80,35 @@ func (h *authHandler) OtpHandler() http.HandlerFunc {
 			if err != nil {
 				return err
 			}
-			otp, err := misc.GenerateOtp()
+			policy := h.otpPolicies[OtpScopeSignIn]
+			otp, err := policy.Generate()
 			if err != nil {
 				return err
 			}
-			err = h.app.Emailer().SendSignInOTP(otpBody.Email, otp)
+			err = h.app.Emailer().SendSignInOTP(r.Context(), emailLocale(r, nil), otpBody.Email, otp)
+			metrics.OtpSent(string(OtpScopeSignIn), metrics.OutcomeOf(err))
 			if err != nil {
 				return err
 			}
-			key := fmt.Sprintf("%s_%s_%s", otpBody.Scope, repo.AuthKeyOTP, otpBody.Email)
-			err = h.app.Repo().AuthRepo().SaveOTP(r.Context(), key, otp)
+			key := fmt.Sprintf("%s_%s_%s", OtpScopeSignIn, repo.AuthKeyOTP, otpBody.Email)
+			err = h.app.Repo().AuthRepo().SaveOTP(r.Context(), key, otp, policy.ExpiresIn)
 			if err != nil {
 				return err
 			}
 			// Reset otp verify rate limit
-			return h.otpVerifyRateLimiter.Reset(otpBody.Email)
+			return h.otpVerifyRateLimiter.Reset(r.Context(), otpBody.Email)
 		}()
-		if err != nil {
-			render.Status(r, http.StatusBadRequest)
-			render.JSON(w, r, map[string]interface{}{
-				"success": false,
-				"error":   err.Error(),
+		if otpBody.Email != "" {
+			outcome, reason := auditOutcome(err)
+			recordAuditEvent(h.app, r, model.AuditEvent{
+				Email:   &otpBody.Email,
+				Type:    enum.AuditEventTypeOtpRequested,
+				Outcome: outcome,
+				Reason:  reason,
 			})
+		}
+		if err != nil {
+			renderError(w, r, err)
 			return
 		}
 		render.JSON(w, r, map[string]interface{}{
@@ -97,8 +119,9 @@ func (h *authHandler) OtpHandler() http.HandlerFunc {
 
 func (h *authHandler) SignInHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		signInBody := &signInRequestBody{}
+		var user *model.User
 		accessToken, err := func() (string, error) {
-			signInBody := &signInRequestBody{}
 			err := json.NewDecoder(r.Body).Decode(signInBody)
 			if err != nil {
 				return "", err
@@ -107,26 +130,47 @@ func (h *authHandler) SignInHandler() http.HandlerFunc {
 			if err != nil {
 				return "", err
 			}
-			ok, err := h.otpVerifyRateLimiter.Evaluate(signInBody.Email)
-			if !ok || err != nil {
-				return "", fmt.Errorf("too many invalid otp attempts")
-			}
 			key := fmt.Sprintf("%s_%s_%s", OtpScopeSignIn, repo.AuthKeyOTP, signInBody.Email)
-			otp, err := h.app.Repo().AuthRepo().GetOTP(r.Context(), key)
+			err = h.app.Repo().AuthRepo().VerifyOTP(r.Context(), key, signInBody.OTP)
+			metrics.OtpVerified(string(OtpScopeSignIn), otpVerifyOutcome(err))
 			if err != nil {
+				outcome, reason := auditOutcome(err)
+				recordAuditEvent(h.app, r, model.AuditEvent{
+					Email:   &signInBody.Email,
+					Type:    enum.AuditEventTypeOtpFailed,
+					Outcome: outcome,
+					Reason:  reason,
+				})
 				return "", err
 			}
-			if ok := misc.ValidateOtp(otp, signInBody.OTP); !ok {
-				return "", fmt.Errorf("invalid otp")
-			}
-			user, err := h.app.Repo().UserRepo().GetByEmail(signInBody.Email)
+			recordAuditEvent(h.app, r, model.AuditEvent{
+				Email:   &signInBody.Email,
+				Type:    enum.AuditEventTypeOtpVerified,
+				Outcome: enum.AuditOutcomeSuccess,
+			})
+			user, err = h.app.Repo().UserRepo().WithPrimary().GetByEmail(r.Context(), signInBody.Email)
 			// Create new user
 			if errors.Is(err, repo.ErrUserNotFound) {
-				user, err = h.app.Repo().UserRepo().New(model.User{Email: &signInBody.Email, IsEmailVerified: true})
+				locale := emailLocale(r, nil)
+				user, err = h.app.Repo().UserRepo().New(model.User{
+					Email:           &signInBody.Email,
+					IsEmailVerified: true,
+					Locale:          &locale,
+				})
 				if err != nil {
 					return "", err
 				}
-				err = h.app.Repo().UserRepo().Create(user)
+				// The user isn't created without its webhook event
+				err = h.app.Repo().InTx(r.Context(), func(txRepo repo.Repo) error {
+					err := txRepo.UserRepo().Create(r.Context(), user)
+					if err != nil {
+						return err
+					}
+
+					return txRepo.WebhookRepo().Enqueue(r.Context(), user.ID, enum.WebhookEventTypeUserCreated, map[string]interface{}{
+						"user": user,
+					})
+				})
 				if err != nil {
 					return "", err
 				}
@@ -134,15 +178,43 @@ func (h *authHandler) SignInHandler() http.HandlerFunc {
 			if err != nil {
 				return "", err
 			}
+			if user.IsSuspended(time.Now()) {
+				return "", repo.ErrUserSuspended
+			}
+			// Signing in during the grace period restores the account
+			if user.Status == enum.UserStatusPendingDeletion {
+				err = h.app.Repo().UserStatusRepo().CancelDeletion(r.Context(), user.ID)
+				if err != nil {
+					return "", err
+				}
+			}
 
-			return h.app.Repo().AccessTokenRepo().Create(user.ID.String(), signInBody.Email)
+			accessToken, err := h.app.Repo().AccessTokenRepo().Create(r.Context(), user.ID.String(), signInBody.Email, h.metadataClaims.Claims(user))
+			if err != nil {
+				return "", err
+			}
+			recordAuditEvent(h.app, r, model.AuditEvent{
+				UserID:  user.ID,
+				Email:   &signInBody.Email,
+				Type:    enum.AuditEventTypeTokenIssued,
+				Outcome: enum.AuditOutcomeSuccess,
+			})
+
+			return accessToken, nil
 		}()
+		if signInBody.Email != "" {
+			event := model.AuditEvent{
+				Email: &signInBody.Email,
+				Type:  enum.AuditEventTypeSignIn,
+			}
+			if user != nil {
+				event.UserID = user.ID
+			}
+			event.Outcome, event.Reason = auditOutcome(err)
+			recordAuditEvent(h.app, r, event)
+		}
 		if err != nil {
-			render.Status(r, http.StatusBadRequest)
-			render.JSON(w, r, map[string]interface{}{
-				"success": false,
-				"error":   err.Error(),
-			})
+			renderError(w, r, err)
 			return
 		}
 
@@ -153,11 +225,15 @@ func (h *authHandler) SignInHandler() http.HandlerFunc {
 	}
 }
 
-func GetIP(r *http.Request) string {
-	fwd, err := httpforwarded.ParseFromRequest(r)
-	if err == nil && len(fwd["X-Forwarded-For"]) != 0 {
-		return fwd["X-Forwarded-For"][0]
+func otpVerifyOutcome(err error) metrics.OutcomeEnum {
+	switch {
+	case errors.Is(err, repo.ErrInvalidOtp):
+		return metrics.OutcomeInvalid
+	case errors.Is(err, repo.ErrOtpExpired):
+		return metrics.OutcomeExpired
+	case errors.Is(err, repo.ErrOtpAttemptsExceeded):
+		return metrics.OutcomeAttemptsExceeded
 	}
 
-	return r.RemoteAddr
+	return metrics.OutcomeOf(err)
 }
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/nkbhasker/go-auth-starter/internal/avatar"
+	"github.com/nkbhasker/go-auth-starter/internal/blob"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/repo"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+// Larger images are rejected before decoding, whatever their file size
+const avatarMaxPixels = 25_000_000
+
+type avatarHandler struct {
+	app       core.App
+	blobStore blob.Store
+	maxBytes  int64
+}
+
+func NewAvatarHandler(app core.App, blobStore blob.Store, maxBytes int) *avatarHandler {
+	return &avatarHandler{
+		app:       app,
+		blobStore: blobStore,
+		maxBytes:  int64(maxBytes),
+	}
+}
+
+// UpdateHandler replaces the avatar of the signed in user. The image is
+// either the request body or the "avatar" part of a multipart form.
+func (h *avatarHandler) UpdateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var urls map[string]string
+		user, err := func() (*model.User, error) {
+			identity := core.IdentityFromContext(r.Context())
+			user, err := h.app.Repo().UserRepo().WithPrimary().Get(r.Context(), identity.UserID())
+			if err != nil {
+				return nil, err
+			}
+			if !ifMatch(r, userETag(user)) {
+				return nil, httperrors.ErrPreconditionFailed
+			}
+			data, err := h.readImage(w, r)
+			if err != nil {
+				return nil, err
+			}
+			variants, err := avatar.Process(data, avatarMaxPixels)
+			if err != nil {
+				return nil, err
+			}
+			// Every upload gets new keys so that caches never serve a stale avatar
+			prefix := avatar.KeyPrefix(user.ID, strconv.FormatInt(time.Now().UnixMicro(), 36))
+			urls = map[string]string{}
+			var avatarUrl string
+			for _, variant := range variants {
+				key := prefix + strconv.Itoa(variant.Size) + "." + variant.Ext
+				err = h.blobStore.Put(r.Context(), key, variant.ContentType, variant.Data)
+				if err != nil {
+					return nil, err
+				}
+				avatarUrl = h.blobStore.URL(key)
+				urls[strconv.Itoa(variant.Size)] = avatarUrl
+			}
+			userId, previous := user.ID, user.Avatar
+			// The largest variant is the avatar, the others share its prefix
+			err = h.app.Repo().InTx(r.Context(), func(txRepo repo.Repo) error {
+				err := txRepo.UserRepo().Patch(r.Context(), user, map[string]interface{}{"avatar": avatarUrl})
+				if err != nil {
+					return err
+				}
+				user, err = txRepo.UserRepo().Get(r.Context(), user.ID)
+				if err != nil {
+					return err
+				}
+
+				return txRepo.WebhookRepo().Enqueue(r.Context(), user.ID, enum.WebhookEventTypeUserUpdated, map[string]interface{}{
+					"user": user,
+				})
+			})
+			if errors.Is(err, repo.ErrUserModified) {
+				h.deleteVariants(r, userId, avatarUrl)
+				return nil, httperrors.ErrPreconditionFailed
+			}
+			if err != nil {
+				h.deleteVariants(r, userId, avatarUrl)
+				return nil, err
+			}
+			if previous != nil {
+				h.deleteVariants(r, userId, *previous)
+			}
+			reason := "avatar updated"
+			recordAuditEvent(h.app, r, model.AuditEvent{
+				UserID:  user.ID,
+				Email:   user.Email,
+				Type:    enum.AuditEventTypeProfileUpdated,
+				Outcome: enum.AuditOutcomeSuccess,
+				Reason:  &reason,
+			})
+
+			return user, nil
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", userETag(user))
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+			"user":    user,
+			"avatars": urls,
+		})
+	}
+}
+
+func (h *avatarHandler) readImage(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := http.MaxBytesReader(w, r.Body, h.maxBytes)
+	contentType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var reader io.Reader = body
+	if contentType == "multipart/form-data" {
+		part, err := findPart(body, params["boundary"], "avatar")
+		if err != nil {
+			return nil, tooLarge(err)
+		}
+		reader = part
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, tooLarge(err)
+	}
+	if len(data) == 0 {
+		return nil, avatar.ErrUnsupportedImage
+	}
+
+	return data, nil
+}
+
+// deleteVariants removes every size of the avatar of userId at url, as long
+// as it is one it uploaded to the store.
+func (h *avatarHandler) deleteVariants(r *http.Request, userId uid.Identifier, url string) {
+	key, ok := strings.CutPrefix(url, h.blobStore.URL(""))
+	if !ok {
+		return
+	}
+	for _, variantKey := range avatar.VariantKeys(userId, key) {
+		// Best effort, an orphaned object is harmless
+		_ = h.blobStore.Delete(r.Context(), variantKey)
+	}
+}
+
+// findPart returns the multipart form part called name.
+func findPart(body io.Reader, boundary string, name string) (*multipart.Part, error) {
+	if boundary == "" {
+		return nil, httperrors.ErrBadRequest
+	}
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, httperrors.ErrValidation.WithFields(map[string]string{name: "is required"})
+		}
+		if err != nil {
+			return nil, httperrors.ErrBadRequest.WithCause(err)
+		}
+		if part.FormName() == name {
+			return part, nil
+		}
+	}
+}
+
+func tooLarge(err error) error {
+	maxBytesError := &http.MaxBytesError{}
+	if errors.As(err, &maxBytesError) {
+		return httperrors.ErrPayloadTooLarge
+	}
+
+	return err
+}
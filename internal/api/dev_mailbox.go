@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
+)
+
+var mailboxTemplate = template.Must(template.New("mailbox").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta http-equiv="refresh" content="5">
+  <title>Dev mailbox</title>
+  <style>
+    body { font-family: Helvetica,Arial,sans-serif; margin: 20px auto; width: 80%; }
+    .message { border: 1px solid #eee; border-radius: 4px; margin-bottom: 20px; padding: 10px 20px; }
+    .meta { color: #aaa; font-size: 0.8em; }
+    pre { white-space: pre-wrap; }
+  </style>
+</head>
+<body>
+  <h1>Dev mailbox</h1>
+  {{range .}}
+  <div class="message">
+    <h3>{{.Subject}}</h3>
+    <p class="meta">#{{.ID}} to {{range $i, $to := .To}}{{if $i}}, {{end}}{{$to}}{{end}} at {{.ReceivedAt.Format "2006-01-02 15:04:05"}}</p>
+    <pre>{{.Text}}</pre>
+  </div>
+  {{else}}
+  <p>No messages yet.</p>
+  {{end}}
+</body>
+</html>
+`))
+
+type devMailboxHandler struct {
+	mailbox comm.Mailbox
+}
+
+func NewDevMailboxHandler(mailbox comm.Mailbox) *devMailboxHandler {
+	return &devMailboxHandler{mailbox: mailbox}
+}
+
+// MailboxHandler lists the messages caught by the dev mailbox, optionally
+// filtered by recipient with ?to=. Browsers get an html inbox, everything
+// else json.
+func (h *devMailboxHandler) MailboxHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		to := r.URL.Query().Get("to")
+		messages := []*comm.MailboxMessage{}
+		for _, msg := range h.mailbox.Messages() {
+			if to == "" || containsFold(msg.To, to) {
+				messages = append(messages, msg)
+			}
+		}
+		// The router forces json as the response content type, so look at the
+		// accept header directly
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			buf := &bytes.Buffer{}
+			err := mailboxTemplate.Execute(buf, messages)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+			render.HTML(w, r, buf.String())
+			return
+		}
+
+		render.JSON(w, r, map[string]interface{}{
+			"success":  true,
+			"messages": messages,
+		})
+	}
+}
+
+func containsFold(list []string, str string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, str) {
+			return true
+		}
+	}
+
+	return false
+}
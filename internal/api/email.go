@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+type emailHandler struct {
+	app core.App
+}
+
+func NewEmailHandler(app core.App) *emailHandler {
+	return &emailHandler{app: app}
+}
+
+func (h *emailHandler) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		messages, err := func() ([]*model.EmailMessage, error) {
+			query := r.URL.Query()
+			var status *enum.EmailStatusEnum
+			if v := query.Get("status"); v != "" {
+				s := enum.EmailStatusEnum(v)
+				status = &s
+			}
+			var before uid.Identifier
+			if v := query.Get("before"); v != "" {
+				id, err := uid.FromIdString(v)
+				if err != nil {
+					return nil, invalidParam("before")
+				}
+				before = id
+			}
+			limit := 0
+			if v := query.Get("limit"); v != "" {
+				l, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, invalidParam("limit")
+				}
+				limit = l
+			}
+
+			return h.app.Repo().EmailOutboxRepo().List(r.Context(), status, before, limit)
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+			"emails":  messages,
+		})
+	}
+}
+
+func (h *emailHandler) ResendHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		message, err := func() (*model.EmailMessage, error) {
+			id, err := uid.FromIdString(chi.URLParam(r, "id"))
+			if err != nil {
+				return nil, invalidParam("id")
+			}
+
+			return h.app.Repo().EmailOutboxRepo().Resend(r.Context(), id)
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+			"email":   message,
+		})
+	}
+}
+
+// emailLocale picks the locale to email the user in, preferring the locale
+// saved on the user over the request's Accept-Language header.
+func emailLocale(r *http.Request, user *model.User) string {
+	preferences := []string{}
+	if user != nil && user.Locale != nil {
+		preferences = append(preferences, *user.Locale)
+	}
+	preferences = append(preferences, r.Header.Get("Accept-Language"))
+
+	return comm.MatchLocale(preferences...)
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
+	"github.com/nkbhasker/go-auth-starter/internal/misc"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/repo"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+var (
+	errEmailInUse     = httperrors.NewHttpError(http.StatusConflict, "email_in_use", "email already in use")
+	errEmailUnchanged = httperrors.NewHttpError(http.StatusBadRequest, "email_unchanged", "email is unchanged")
+)
+
+type emailUpdateOtpRequestBody struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type undoEmailChangeRequestBody struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// EmailUpdateOtpHandler sends an otp to the new address of the signed in
+// user, and a link to undo the change to the current one in case the
+// session was hijacked.
+func (h *authHandler) EmailUpdateOtpHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		otpBody := &emailUpdateOtpRequestBody{}
+		var user *model.User
+		err := func() error {
+			err := json.NewDecoder(r.Body).Decode(otpBody)
+			if err != nil {
+				return err
+			}
+			err = h.app.Validate().Struct(otpBody)
+			if err != nil {
+				return err
+			}
+			identity := core.IdentityFromContext(r.Context())
+			user, err = h.app.Repo().UserRepo().Get(r.Context(), identity.UserID())
+			if err != nil {
+				return err
+			}
+			if user.Email != nil && strings.EqualFold(*user.Email, otpBody.Email) {
+				return errEmailUnchanged
+			}
+			err = checkEmailAvailable(r.Context(), h.app, otpBody.Email)
+			if err != nil {
+				return err
+			}
+			policy := h.otpPolicies[OtpScopeEmailUpdate]
+			otp, err := policy.Generate()
+			if err != nil {
+				return err
+			}
+			err = h.app.Repo().AuthRepo().SaveOTP(r.Context(), emailUpdateOtpKey(user.ID, otpBody.Email), otp, policy.ExpiresIn)
+			if err != nil {
+				return err
+			}
+			locale := emailLocale(r, user)
+			err = h.app.Emailer().SendEmailUpdateOTP(r.Context(), locale, otpBody.Email, otp)
+			metrics.OtpSent(string(OtpScopeEmailUpdate), metrics.OutcomeOf(err))
+			if err != nil {
+				return err
+			}
+			if user.Email == nil {
+				return nil
+			}
+			token, err := h.app.Repo().AuthRepo().SaveEmailChangeUndo(r.Context(), repo.EmailChangeUndo{
+				UserID:   user.ID.String(),
+				OldEmail: *user.Email,
+				NewEmail: otpBody.Email,
+			}, h.emailChangeUndoExpiresIn)
+			if err != nil {
+				return err
+			}
+
+			return h.app.Emailer().SendEmailChangeRequested(r.Context(), locale, *user.Email, otpBody.Email, misc.TokenUrl(h.emailChangeUndoUrl, token))
+		}()
+		if user != nil {
+			outcome, reason := auditOutcome(err)
+			if err == nil {
+				to := "to " + otpBody.Email
+				reason = &to
+			}
+			recordAuditEvent(h.app, r, model.AuditEvent{
+				UserID:  user.ID,
+				Email:   user.Email,
+				Type:    enum.AuditEventTypeEmailChangeRequested,
+				Outcome: outcome,
+				Reason:  reason,
+			})
+		}
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+		})
+	}
+}
+
+// UndoEmailChangeHandler redeems the undo link sent to the previous address.
+// It cancels a pending change or reverts a completed one, and signs the user
+// out everywhere as whoever made the change may hold a session.
+func (h *authHandler) UndoEmailChangeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user *model.User
+		var change *repo.EmailChangeUndo
+		err := func() error {
+			undoBody := &undoEmailChangeRequestBody{}
+			err := json.NewDecoder(r.Body).Decode(undoBody)
+			if err != nil {
+				return err
+			}
+			err = h.app.Validate().Struct(undoBody)
+			if err != nil {
+				return err
+			}
+			change, err = h.app.Repo().AuthRepo().ConsumeEmailChangeUndo(r.Context(), undoBody.Token)
+			if err != nil {
+				return err
+			}
+			userId, err := uid.FromIdString(change.UserID)
+			if err != nil {
+				return err
+			}
+			user, err = h.app.Repo().UserRepo().WithPrimary().Get(r.Context(), userId)
+			if err != nil {
+				return err
+			}
+			// An otp still in flight can't be used to complete the change anymore
+			err = h.app.Repo().AuthRepo().DeleteOTP(r.Context(), emailUpdateOtpKey(user.ID, change.NewEmail))
+			if err != nil {
+				return err
+			}
+			if user.Email != nil && *user.Email == change.NewEmail {
+				user.Email = &change.OldEmail
+				err = h.app.Repo().InTx(r.Context(), func(txRepo repo.Repo) error {
+					err := txRepo.UserRepo().Update(r.Context(), user)
+					if err != nil {
+						return err
+					}
+
+					return txRepo.WebhookRepo().Enqueue(r.Context(), user.ID, enum.WebhookEventTypeEmailChanged, map[string]interface{}{
+						"user":          user,
+						"previousEmail": change.NewEmail,
+					})
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			err = h.app.Repo().AccessTokenRepo().RevokeAll(r.Context(), user.ID.String())
+			if err != nil {
+				return err
+			}
+			reason := "email change undone"
+			recordAuditEvent(h.app, r, model.AuditEvent{
+				UserID:  user.ID,
+				Email:   user.Email,
+				Type:    enum.AuditEventTypeTokenRevoked,
+				Outcome: enum.AuditOutcomeSuccess,
+				Reason:  &reason,
+			})
+
+			return nil
+		}()
+		if user != nil {
+			outcome, reason := auditOutcome(err)
+			if err == nil {
+				from := "from " + change.NewEmail
+				reason = &from
+			}
+			recordAuditEvent(h.app, r, model.AuditEvent{
+				UserID:  user.ID,
+				Email:   user.Email,
+				Type:    enum.AuditEventTypeEmailChangeUndone,
+				Outcome: outcome,
+				Reason:  reason,
+			})
+		}
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+		})
+	}
+}
+
+// checkEmailAvailable returns errEmailInUse when email belongs to a user.
+func checkEmailAvailable(ctx context.Context, app core.App, email string) error {
+	_, err := app.Repo().UserRepo().GetByEmail(ctx, email)
+	if err == nil {
+		return errEmailInUse
+	}
+	if !errors.Is(err, repo.ErrUserNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// Email update otps are bound to the user requesting them, so that only they
+// can complete the change.
+func emailUpdateOtpKey(userId uid.Identifier, email string) string {
+	return fmt.Sprintf("%s_%s_%s_%s", OtpScopeEmailUpdate, repo.AuthKeyOTP, userId, email)
+}
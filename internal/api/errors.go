@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/nkbhasker/go-auth-starter/internal/avatar"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/repo"
+)
+
+var (
+	errInvalidBody      = httperrors.NewHttpError(http.StatusBadRequest, "invalid_body", "request body must be valid json")
+	errUserNotFound     = httperrors.NewHttpError(http.StatusNotFound, "user_not_found", repo.ErrUserNotFound.Error())
+	errInvalidOtp       = httperrors.NewHttpError(http.StatusBadRequest, "invalid_otp", repo.ErrInvalidOtp.Error())
+	errOtpExpired       = httperrors.NewHttpError(http.StatusBadRequest, "otp_expired", repo.ErrOtpExpired.Error())
+	errOtpAttempts      = httperrors.NewHttpError(http.StatusBadRequest, "otp_attempts_exceeded", repo.ErrOtpAttemptsExceeded.Error())
+	errInvalidUndoToken = httperrors.NewHttpError(http.StatusBadRequest, "invalid_undo_token", repo.ErrInvalidUndoToken.Error())
+	errInvalidExport    = httperrors.NewHttpError(http.StatusBadRequest, "invalid_export_token", repo.ErrInvalidExportToken.Error())
+	errExportNotReady   = httperrors.NewHttpError(http.StatusNotFound, "export_not_ready", repo.ErrDataExportNotReady.Error())
+	errUnsupportedImage = httperrors.NewHttpError(http.StatusUnsupportedMediaType, "unsupported_image", avatar.ErrUnsupportedImage.Error())
+	errImageDimensions  = httperrors.NewHttpError(http.StatusUnprocessableEntity, "image_too_large", avatar.ErrImageTooLarge.Error())
+	errEndpointNotFound = httperrors.NewHttpError(http.StatusNotFound, "webhook_endpoint_not_found", repo.ErrWebhookEndpointNotFound.Error())
+	errDeliveryNotFound = httperrors.NewHttpError(http.StatusNotFound, "webhook_delivery_not_found", repo.ErrWebhookDeliveryNotFound.Error())
+	errEmailNotFound    = httperrors.NewHttpError(http.StatusNotFound, "email_not_found", repo.ErrEmailMessageNotFound.Error())
+	errEmailNotDead     = httperrors.NewHttpError(http.StatusConflict, "email_not_dead_lettered", repo.ErrEmailNotDeadLettered.Error())
+)
+
+// domainErrors are the errors of other packages clients may be told about.
+var domainErrors = map[error]httperrors.HttpError{
+	repo.ErrUserNotFound:            errUserNotFound,
+	repo.ErrUserSuspended:           httperrors.ErrUserSuspended,
+	repo.ErrUserModified:            httperrors.ErrPreconditionFailed,
+	repo.ErrInvalidOtp:              errInvalidOtp,
+	repo.ErrOtpExpired:              errOtpExpired,
+	repo.ErrOtpAttemptsExceeded:     errOtpAttempts,
+	repo.ErrInvalidUndoToken:        errInvalidUndoToken,
+	repo.ErrInvalidExportToken:      errInvalidExport,
+	repo.ErrDataExportNotReady:      errExportNotReady,
+	repo.ErrWebhookEndpointNotFound: errEndpointNotFound,
+	repo.ErrWebhookDeliveryNotFound: errDeliveryNotFound,
+	repo.ErrEmailMessageNotFound:    errEmailNotFound,
+	repo.ErrEmailNotDeadLettered:    errEmailNotDead,
+	avatar.ErrUnsupportedImage:      errUnsupportedImage,
+	avatar.ErrImageTooLarge:         errImageDimensions,
+}
+
+// renderError renders err as a problem, see httperrors.Render.
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	httperrors.Render(w, r, httpError(err))
+}
+
+// httpError converts the errors of handlers to an HttpError, leaving
+// unknown errors as they are to be rendered as internal errors.
+func httpError(err error) error {
+	var httpErr httperrors.HttpError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	for domainErr, httpErr := range domainErrors {
+		if errors.Is(err, domainErr) {
+			return httpErr
+		}
+	}
+	var fieldErrs fieldErrors
+	var validationErrs validator.ValidationErrors
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var conflictErr *repo.ConflictError
+	switch {
+	case errors.As(err, &fieldErrs):
+		return httperrors.ErrValidation.WithFields(fieldErrs)
+	case errors.As(err, &validationErrs):
+		fields := map[string]string{}
+		for _, e := range validationErrs {
+			fields[e.Field()] = validationMessage(e)
+		}
+
+		return httperrors.ErrValidation.WithFields(fields)
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errInvalidBody
+	case errors.As(err, &conflictErr) && conflictErr.Field != "":
+		return httperrors.ErrConflict.WithFields(map[string]string{conflictErr.Field: "is already in use"})
+	case errors.Is(err, repo.ErrConflict):
+		// Other constraints are details of the schema
+		return httperrors.ErrConflict.WithCause(err)
+	case errors.Is(err, repo.ErrUnavailable):
+		return httperrors.ErrUnavailable.WithCause(err)
+	}
+
+	return err
+}
+
+// invalidParam reports the query or path parameter name as invalid.
+func invalidParam(name string) error {
+	return httperrors.ErrValidation.WithFields(map[string]string{name: "is invalid"})
+}
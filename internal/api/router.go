@@ -2,42 +2,126 @@ package api
 
 import (
 	"net/http"
+	"net/netip"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
+	"github.com/nkbhasker/go-auth-starter/internal/blob"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
 	"github.com/nkbhasker/go-auth-starter/internal/core"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/metadata"
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
 	"github.com/nkbhasker/go-auth-starter/internal/middleware"
 	"github.com/nkbhasker/go-auth-starter/internal/misc"
 )
 
 type RouterOptions struct {
-	App                    core.App
-	JwtHelper              misc.JwtHelper
-	OtpGenerateRateLimiter core.RateLimiter
-	OtpVerifyRateLimiter   core.RateLimiter
+	App                              core.App
+	JwtHelper                        misc.JwtHelper
+	OtpGenerateRateLimiter           core.RateLimiter
+	OtpVerifyRateLimiter             core.RateLimiter
+	SignInOtpPolicy                  misc.OtpPolicy
+	EmailUpdateOtpPolicy             misc.OtpPolicy
+	EmailChangeUndoUrl               string
+	EmailChangeUndoExpiryInHours     int
+	MetadataValidator                metadata.Validator
+	MetadataClaims                   metadata.Projection
+	AccountDeletionGracePeriodInDays int
+	AdminApiKey                      string
+	DevMode                          bool
+	DevMailbox                       comm.Mailbox
+	BlobStore                        blob.Store
+	AvatarMaxBytes                   int
+	TrustedProxies                   []netip.Prefix
+	MetricsPort                      string
 }
 
 func SetupRouter(options RouterOptions) http.Handler {
 	healthHandler := NewHealthHandler(options.App)
-	authHandler := NewAuthHandler(options.App, options.OtpGenerateRateLimiter, options.OtpVerifyRateLimiter)
-	userHandler := NewUserHandler(options.App)
+	authHandler := NewAuthHandler(options.App, options.OtpVerifyRateLimiter, map[OtpScopeEnum]misc.OtpPolicy{
+		OtpScopeSignIn:      options.SignInOtpPolicy,
+		OtpScopeEmailUpdate: options.EmailUpdateOtpPolicy,
+	}, options.EmailChangeUndoUrl, options.EmailChangeUndoExpiryInHours, options.MetadataClaims)
+	auditEventHandler := NewAuditEventHandler(options.App)
+	webhookHandler := NewWebhookHandler(options.App)
+	emailHandler := NewEmailHandler(options.App)
+	userHandler := NewUserHandler(options.App, options.AccountDeletionGracePeriodInDays, options.MetadataValidator)
+	avatarHandler := NewAvatarHandler(options.App, options.BlobStore, options.AvatarMaxBytes)
+	adminUserHandler := NewAdminUserHandler(options.App, options.MetadataValidator)
+	// Rate limit policies, applied per route
+	otpGenerateLimit := middleware.NewRateLimitInterceptor(options.OtpGenerateRateLimiter, middleware.RateLimitByIP)
+	otpVerifyLimit := middleware.NewRateLimitInterceptor(options.OtpVerifyRateLimiter, middleware.RateLimitByBodyField("email"))
 	router := chi.NewRouter()
+	router.Use(middleware.NewClientIPInterceptor(options.TrustedProxies).HandlerFunc)
+	router.Use(middleware.NewRequestIDInterceptor(options.App.Logger()).HandlerFunc)
+	router.Use(middleware.NewAccessLogInterceptor().HandlerFunc)
+	router.Use(middleware.NewMetricsInterceptor().HandlerFunc)
 	router.Use(render.SetContentType(render.ContentTypeJSON))
+	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		renderError(w, r, httperrors.ErrNotFound)
+	})
+	router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		renderError(w, r, httperrors.NewHttpError(http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed"))
+	})
 
 	router.Group(func(r chi.Router) {
 		r.Get("/live", healthHandler.LiveHandler())
 		r.Get("/ready", healthHandler.ReadyHandler())
-		r.Post("/auth/otp", authHandler.OtpHandler())
-		r.Post("/auth/signin", authHandler.SignInHandler())
+		r.With(otpGenerateLimit.HandlerFunc).Post("/auth/otp", authHandler.OtpHandler())
+		r.With(otpVerifyLimit.HandlerFunc).Post("/auth/signin", authHandler.SignInHandler())
+		r.Post("/user/email/undo", authHandler.UndoEmailChangeHandler())
+		r.Post("/user/export/download", userHandler.DownloadExportHandler())
 	})
 
 	router.Group(func(r chi.Router) {
-		authInterceptor := middleware.NewAuthInterceptor(options.JwtHelper)
+		authInterceptor := middleware.NewAuthInterceptor(options.JwtHelper, options.App.Repo().UserStatusRepo())
 		r.Use(authInterceptor.HandlerFunc)
 		r.Get("/user/me", userHandler.MeHandler())
 		r.Patch("/user/me", userHandler.UpdateUserHandler())
+		r.With(otpGenerateLimit.HandlerFunc).Post("/user/me/email/otp", authHandler.EmailUpdateOtpHandler())
 		r.Put("/user/me/email", userHandler.UpdateEmailHandler())
+		r.Put("/user/me/avatar", avatarHandler.UpdateHandler())
+		r.Delete("/user/me", userHandler.DeleteMeHandler())
+		r.Get("/user/me/export", userHandler.GetExportMeHandler())
+		r.Post("/user/me/export", userHandler.ExportMeHandler())
+		r.Get("/user/me/security-events", auditEventHandler.MeHandler())
+	})
+
+	router.Group(func(r chi.Router) {
+		adminInterceptor := middleware.NewAdminInterceptor(options.AdminApiKey)
+		r.Use(adminInterceptor.HandlerFunc)
+		r.Get("/admin/security-events", auditEventHandler.SearchHandler())
+		r.Get("/admin/users/{id}", adminUserHandler.GetHandler())
+		r.Patch("/admin/users/{id}/metadata", adminUserHandler.UpdateMetadataHandler())
+		r.Patch("/admin/users/{id}/status", adminUserHandler.UpdateStatusHandler())
+		r.Post("/admin/webhooks", webhookHandler.CreateHandler())
+		r.Get("/admin/webhooks", webhookHandler.ListHandler())
+		r.Delete("/admin/webhooks/{id}", webhookHandler.DeleteHandler())
+		r.Get("/admin/webhooks/{id}/deliveries", webhookHandler.DeliveriesHandler())
+		r.Post("/admin/webhook-deliveries/{id}/replay", webhookHandler.ReplayHandler())
+		r.Get("/admin/emails", emailHandler.ListHandler())
+		r.Post("/admin/emails/{id}/resend", emailHandler.ResendHandler())
+		// Metrics are served on their own port when it is set
+		if options.MetricsPort == "" {
+			r.Method(http.MethodGet, "/metrics", metrics.Handler())
+		}
 	})
 
+	// The mailbox shows otps, only dev mode serves it without the admin key
+	if options.DevMailbox != nil {
+		devMailboxHandler := NewDevMailboxHandler(options.DevMailbox)
+		if options.DevMode {
+			router.Get("/_dev/mailbox", devMailboxHandler.MailboxHandler())
+		} else {
+			adminInterceptor := middleware.NewAdminInterceptor(options.AdminApiKey)
+			router.With(adminInterceptor.HandlerFunc).Get("/_dev/mailbox", devMailboxHandler.MailboxHandler())
+		}
+	}
+	// A local blob store serves the objects itself
+	if localBlobs, ok := options.BlobStore.(blob.LocalStore); ok {
+		router.Handle("/blobs/*", http.StripPrefix("/blobs", localBlobs))
+	}
+
 	return router
 }
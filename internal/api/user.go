@@ -2,48 +2,54 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/render"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
 	"github.com/nkbhasker/go-auth-starter/internal/core"
-	"github.com/nkbhasker/go-auth-starter/internal/misc"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/metadata"
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
 	"github.com/nkbhasker/go-auth-starter/internal/model"
 	"github.com/nkbhasker/go-auth-starter/internal/repo"
 )
 
 type userHandler struct {
-	app core.App
+	app                 core.App
+	deletionGracePeriod time.Duration
+	metadataValidator   metadata.Validator
 }
 
-type updateUserRequestBody struct {
-	FirstName string `json:"firstName" validate:"required"`
-	LastName  string `json:"lastName"`
-	Gender    string `json:"gender" validate:"oneof=MALE FEMALE"`
+type updateEmailRequestBody struct {
+	Email string `json:"email" validate:"required,email"`
+	OTP   string `json:"otp" validate:"required"`
 }
 
-type updateEmailRequestBody struct {
-	Email string `json:"email"`
-	OTP   string `json:"otp"`
+type downloadExportRequestBody struct {
+	Token string `json:"token" validate:"required"`
 }
 
-func NewUserHandler(app core.App) *userHandler {
-	return &userHandler{app: app}
+func NewUserHandler(app core.App, deletionGracePeriodInDays int, metadataValidator metadata.Validator) *userHandler {
+	return &userHandler{
+		app:                 app,
+		deletionGracePeriod: time.Duration(deletionGracePeriodInDays * int(24*time.Hour)),
+		metadataValidator:   metadataValidator,
+	}
 }
 
 func (h *userHandler) MeHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		identity := core.IdentityFromContext(r.Context())
-		user, err := h.app.Repo().UserRepo().Get(identity.UserID())
+		user, err := h.app.Repo().UserRepo().Get(r.Context(), identity.UserID())
 		if err != nil {
-			render.Status(r, http.StatusBadRequest)
-			render.JSON(w, r, map[string]interface{}{
-				"success": false,
-				"error":   err.Error(),
-			})
+			renderError(w, r, err)
 			return
 		}
 
+		w.Header().Set("ETag", userETag(user))
 		render.JSON(w, r, map[string]interface{}{
 			"success": true,
 			"user":    user,
@@ -55,31 +61,53 @@ func (h *userHandler) UpdateUserHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := func() (*model.User, error) {
 			identity := core.IdentityFromContext(r.Context())
-			updateUserBody := &updateUserRequestBody{}
-			err := json.NewDecoder(r.Body).Decode(updateUserBody)
+			user, err := h.app.Repo().UserRepo().WithPrimary().Get(r.Context(), identity.UserID())
 			if err != nil {
 				return nil, err
 			}
-			user, err := h.app.Repo().UserRepo().Get(identity.UserID())
+			if !ifMatch(r, userETag(user)) {
+				return nil, httperrors.ErrPreconditionFailed
+			}
+			columns, err := h.parseUserPatch(r, user)
 			if err != nil {
 				return nil, err
 			}
-			err = h.app.Repo().UserRepo().Update(user)
+			// The user isn't updated without its webhook event
+			err = h.app.Repo().InTx(r.Context(), func(txRepo repo.Repo) error {
+				err := txRepo.UserRepo().Patch(r.Context(), user, columns)
+				if err != nil {
+					return err
+				}
+				user, err = txRepo.UserRepo().Get(r.Context(), user.ID)
+				if err != nil {
+					return err
+				}
+
+				return txRepo.WebhookRepo().Enqueue(r.Context(), user.ID, enum.WebhookEventTypeUserUpdated, map[string]interface{}{
+					"user": user,
+				})
+			})
+			if errors.Is(err, repo.ErrUserModified) {
+				return nil, httperrors.ErrPreconditionFailed
+			}
 			if err != nil {
 				return nil, err
 			}
+			recordAuditEvent(h.app, r, model.AuditEvent{
+				UserID:  user.ID,
+				Email:   user.Email,
+				Type:    enum.AuditEventTypeProfileUpdated,
+				Outcome: enum.AuditOutcomeSuccess,
+			})
 
 			return user, nil
 		}()
 		if err != nil {
-			render.Status(r, http.StatusUnauthorized)
-			render.JSON(w, r, map[string]interface{}{
-				"success": false,
-				"error":   err.Error(),
-			})
+			renderError(w, r, err)
 			return
 		}
 
+		w.Header().Set("ETag", userETag(user))
 		render.JSON(w, r, map[string]interface{}{
 			"success": true,
 			"user":    user,
@@ -96,37 +124,173 @@ func (h *userHandler) UpdateEmailHandler() http.HandlerFunc {
 			if err != nil {
 				return err
 			}
-			key := fmt.Sprintf("%s_%s_%s", OtpScopeEmailUpdate, repo.AuthKeyOTP, updateEmailBody.Email)
-			otp, err := h.app.Repo().AuthRepo().GetOTP(r.Context(), key)
+			err = h.app.Validate().Struct(updateEmailBody)
 			if err != nil {
 				return err
 			}
-			if ok := misc.ValidateOtp(otp, updateEmailBody.OTP); !ok {
-				return fmt.Errorf("invalid otp")
+			user, err := h.app.Repo().UserRepo().WithPrimary().Get(r.Context(), identity.UserID())
+			if err != nil {
+				return err
 			}
-			user, err := h.app.Repo().UserRepo().Get(identity.UserID())
+			// The address may have been taken since the otp was sent
+			err = checkEmailAvailable(r.Context(), h.app, updateEmailBody.Email)
 			if err != nil {
-				return nil
+				return err
+			}
+			key := emailUpdateOtpKey(user.ID, updateEmailBody.Email)
+			err = h.app.Repo().AuthRepo().VerifyOTP(r.Context(), key, updateEmailBody.OTP)
+			metrics.OtpVerified(string(OtpScopeEmailUpdate), otpVerifyOutcome(err))
+			if err != nil {
+				return err
 			}
+			oldEmail := user.Email
 			user.Email = &updateEmailBody.Email
-			err = h.app.Repo().UserRepo().Update(user)
+			err = h.app.Repo().InTx(r.Context(), func(txRepo repo.Repo) error {
+				err := txRepo.UserRepo().Update(r.Context(), user)
+				if err != nil {
+					return err
+				}
+
+				return txRepo.WebhookRepo().Enqueue(r.Context(), user.ID, enum.WebhookEventTypeEmailChanged, map[string]interface{}{
+					"user":          user,
+					"previousEmail": oldEmail,
+				})
+			})
 			if err != nil {
-				return nil
+				return err
 			}
+			reason := "changed from " + stringValue(oldEmail)
+			recordAuditEvent(h.app, r, model.AuditEvent{
+				UserID:  user.ID,
+				Email:   user.Email,
+				Type:    enum.AuditEventTypeEmailChanged,
+				Outcome: enum.AuditOutcomeSuccess,
+				Reason:  &reason,
+			})
 
 			return nil
 		}()
 
 		if err != nil {
-			render.Status(r, http.StatusBadRequest)
-			render.JSON(w, r, map[string]interface{}{
-				"success": false,
-				"error":   err.Error(),
+			renderError(w, r, err)
+			return
+		}
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+		})
+	}
+}
+
+func (h *userHandler) DeleteMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deleteAfter, err := func() (time.Time, error) {
+			identity := core.IdentityFromContext(r.Context())
+			user, err := h.app.Repo().UserRepo().Get(r.Context(), identity.UserID())
+			if err != nil {
+				return time.Time{}, err
+			}
+			deleteAfter := time.Now().Add(h.deletionGracePeriod)
+			err = h.app.Repo().InTx(r.Context(), func(txRepo repo.Repo) error {
+				err := txRepo.UserStatusRepo().ScheduleDeletion(r.Context(), user.ID, deleteAfter)
+				if err != nil {
+					return err
+				}
+				if user.Email == nil {
+					return nil
+				}
+				emailer := h.app.Emailer().WithClient(comm.NewOutbox(txRepo.EmailOutboxRepo()))
+
+				return emailer.SendAccountDeletionScheduled(r.Context(), emailLocale(r, user), *user.Email, deleteAfter)
 			})
+			if err != nil {
+				return time.Time{}, err
+			}
+
+			return deleteAfter, nil
+		}()
+		if err != nil {
+			renderError(w, r, err)
 			return
 		}
+
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, map[string]interface{}{
+			"success":     true,
+			"deleteAfter": deleteAfter,
+		})
+	}
+}
+
+// ExportMeHandler queues an export of everything stored about the user. It
+// is built in the background and the user is emailed a link to download it.
+func (h *userHandler) ExportMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := core.IdentityFromContext(r.Context())
+		export, err := h.app.Repo().DataExportRepo().Request(r.Context(), identity.UserID())
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusAccepted)
 		render.JSON(w, r, map[string]interface{}{
 			"success": true,
+			"export":  export,
 		})
 	}
 }
+
+// DownloadExportHandler returns the archive of the export the emailed token
+// belongs to.
+func (h *userHandler) DownloadExportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		export, err := func() (*model.DataExport, error) {
+			downloadBody := &downloadExportRequestBody{}
+			err := json.NewDecoder(r.Body).Decode(downloadBody)
+			if err != nil {
+				return nil, err
+			}
+			err = h.app.Validate().Struct(downloadBody)
+			if err != nil {
+				return nil, err
+			}
+
+			return h.app.Repo().DataExportRepo().GetByToken(r.Context(), downloadBody.Token)
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		writeExport(w, export)
+	}
+}
+
+// GetExportMeHandler returns the archive of the latest export of the user
+// that is ready, requested beforehand with ExportMeHandler.
+func (h *userHandler) GetExportMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := core.IdentityFromContext(r.Context())
+		export, err := h.app.Repo().DataExportRepo().GetLatestReady(r.Context(), identity.UserID())
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		writeExport(w, export)
+	}
+}
+
+func writeExport(w http.ResponseWriter, export *model.DataExport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="user-export.json"`)
+	_, _ = w.Write([]byte(stringValue(export.Archive)))
+}
+
+func stringValue(str *string) string {
+	if str == nil {
+		return ""
+	}
+
+	return *str
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/metadata"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+)
+
+const contentTypeMergePatch = "application/merge-patch+json"
+
+var errInvalidPatch = httperrors.NewHttpError(http.StatusBadRequest, "invalid_patch", "patch must be a json object")
+
+// userPatchColumns maps the fields a client may patch to their column. The
+// avatar is only set by uploading one, see avatarHandler.
+var userPatchColumns = map[string]string{
+	"firstName": "first_name",
+	"lastName":  "last_name",
+	"gender":    "gender",
+	"locale":    "locale",
+	// Merged into the current value rather than replacing it
+	"publicMetadata": "public_metadata",
+}
+
+// userPatch holds the patched values for validation, a nil field is either
+// absent from the patch or cleared by it.
+type userPatch struct {
+	FirstName *string `json:"firstName" validate:"omitempty,min=1,max=100"`
+	LastName  *string `json:"lastName" validate:"omitempty,max=100"`
+	Gender    *string `json:"gender" validate:"omitempty,oneof=MALE FEMALE"`
+	Locale    *string `json:"locale" validate:"omitempty,locale"`
+}
+
+// fieldErrors reports invalid fields of a request by their json name.
+type fieldErrors map[string]string
+
+func (e fieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	return "invalid fields: " + strings.Join(fields, ", ")
+}
+
+// parseUserPatch reads an RFC 7396 merge patch of the user and returns the
+// columns to update. Members set to null clear the field.
+func (h *userHandler) parseUserPatch(r *http.Request, user *model.User) (map[string]interface{}, error) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != contentTypeMergePatch && contentType != "application/json" {
+		return nil, httperrors.ErrUnsupportedMediaType.WithFields(map[string]string{"Content-Type": "must be " + contentTypeMergePatch})
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	members := map[string]json.RawMessage{}
+	err = json.Unmarshal(body, &members)
+	if err != nil {
+		return nil, errInvalidPatch
+	}
+
+	errs := fieldErrors{}
+	patch := &userPatch{}
+	columns := map[string]interface{}{}
+	for name, raw := range members {
+		column, ok := userPatchColumns[name]
+		if !ok {
+			errs[name] = "is not allowed"
+			continue
+		}
+		if name == "publicMetadata" {
+			value, err := h.mergeMetadata(enum.MetadataFieldPublic, user.PublicMetadata, raw)
+			if err != nil {
+				errs[name] = err.Error()
+				continue
+			}
+			columns[column] = value
+			continue
+		}
+		if string(raw) == "null" {
+			if name == "firstName" {
+				errs[name] = "is required"
+				continue
+			}
+			columns[column] = nil
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			errs[name] = "must be a string"
+			continue
+		}
+		switch name {
+		case "firstName":
+			patch.FirstName = &value
+		case "lastName":
+			patch.LastName = &value
+		case "gender":
+			patch.Gender = &value
+			columns[column] = enum.GenderEnum(value)
+			continue
+		case "locale":
+			patch.Locale = &value
+		}
+		columns[column] = value
+	}
+	err = h.app.Validate().Struct(patch)
+	validationErrors := validator.ValidationErrors{}
+	if errors.As(err, &validationErrors) {
+		for _, fe := range validationErrors {
+			errs[fe.Field()] = validationMessage(fe)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+
+	return columns, nil
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return "must be at least " + fe.Param() + " characters long"
+	case "max":
+		return "must be at most " + fe.Param() + " characters long"
+	case "oneof":
+		return "must be one of " + fe.Param()
+	case "url":
+		return "must be a url"
+	case "locale":
+		return "must be one of " + strings.Join(comm.Locales, " ")
+	default:
+		return "is invalid"
+	}
+}
+
+// mergeMetadata merge patches the current value of a metadata field and
+// validates the result.
+func (h *userHandler) mergeMetadata(
+	field enum.MetadataFieldEnum,
+	current model.Metadata,
+	patch json.RawMessage,
+) (model.Metadata, error) {
+	value, err := metadata.Merge(current, patch)
+	if err != nil {
+		return nil, err
+	}
+	err = h.metadataValidator.Validate(field, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// userETag is the entity tag of the user's current version.
+func userETag(user *model.User) string {
+	return `"` + strconv.FormatInt(user.UpdatedAt.UnixMicro(), 36) + `"`
+}
+
+// ifMatch reports whether the If-Match header of the request, if any,
+// matches etag.
+func ifMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
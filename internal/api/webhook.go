@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+	"github.com/nkbhasker/go-auth-starter/internal/webhook"
+)
+
+type webhookHandler struct {
+	app core.App
+}
+
+type createWebhookRequestBody struct {
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events" validate:"required,min=1,dive,oneof=user.created user.updated email.changed user.deleted"`
+}
+
+func NewWebhookHandler(app core.App) *webhookHandler {
+	return &webhookHandler{app: app}
+}
+
+func (h *webhookHandler) CreateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoint, err := func() (*model.WebhookEndpoint, error) {
+			createWebhookBody := &createWebhookRequestBody{}
+			err := json.NewDecoder(r.Body).Decode(createWebhookBody)
+			if err != nil {
+				return nil, err
+			}
+			err = h.app.Validate().Struct(createWebhookBody)
+			if err != nil {
+				return nil, err
+			}
+			secret, err := webhook.NewSecret()
+			if err != nil {
+				return nil, err
+			}
+			events := make(model.EventTypeList, len(createWebhookBody.Events))
+			for i, e := range createWebhookBody.Events {
+				events[i] = enum.WebhookEventTypeEnum(e)
+			}
+			endpoint, err := h.app.Repo().WebhookRepo().NewEndpoint(model.WebhookEndpoint{
+				URL:      createWebhookBody.URL,
+				Secret:   secret,
+				Events:   events,
+				IsActive: true,
+			})
+			if err != nil {
+				return nil, err
+			}
+			err = h.app.Repo().WebhookRepo().CreateEndpoint(r.Context(), endpoint)
+			if err != nil {
+				return nil, err
+			}
+
+			return endpoint, nil
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		// The secret is only ever returned when the endpoint is created
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, map[string]interface{}{
+			"success":  true,
+			"endpoint": endpoint,
+			"secret":   endpoint.Secret,
+		})
+	}
+}
+
+func (h *webhookHandler) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoints, err := h.app.Repo().WebhookRepo().ListEndpoints(r.Context())
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, map[string]interface{}{
+			"success":   true,
+			"endpoints": endpoints,
+		})
+	}
+}
+
+func (h *webhookHandler) DeleteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := func() error {
+			id, err := uid.FromIdString(chi.URLParam(r, "id"))
+			if err != nil {
+				return invalidParam("id")
+			}
+
+			return h.app.Repo().WebhookRepo().DeleteEndpoint(r.Context(), id)
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, map[string]interface{}{
+			"success": true,
+		})
+	}
+}
+
+func (h *webhookHandler) DeliveriesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deliveries, err := func() ([]*model.WebhookDelivery, error) {
+			id, err := uid.FromIdString(chi.URLParam(r, "id"))
+			if err != nil {
+				return nil, invalidParam("id")
+			}
+			var before uid.Identifier
+			if v := r.URL.Query().Get("before"); v != "" {
+				before, err = uid.FromIdString(v)
+				if err != nil {
+					return nil, invalidParam("before")
+				}
+			}
+			limit := 0
+			if v := r.URL.Query().Get("limit"); v != "" {
+				limit, err = strconv.Atoi(v)
+				if err != nil {
+					return nil, invalidParam("limit")
+				}
+			}
+
+			return h.app.Repo().WebhookRepo().ListDeliveries(r.Context(), id, before, limit)
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		render.JSON(w, r, map[string]interface{}{
+			"success":    true,
+			"deliveries": deliveries,
+		})
+	}
+}
+
+func (h *webhookHandler) ReplayHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		delivery, err := func() (*model.WebhookDelivery, error) {
+			id, err := uid.FromIdString(chi.URLParam(r, "id"))
+			if err != nil {
+				return nil, invalidParam("id")
+			}
+
+			return h.app.Repo().WebhookRepo().Replay(r.Context(), id)
+		}()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		render.Status(r, http.StatusAccepted)
+		render.JSON(w, r, map[string]interface{}{
+			"success":  true,
+			"delivery": delivery,
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package avatar
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const jpegQuality = 85
+
+// Sizes are the widths, and heights, in pixels avatars are resized to.
+var Sizes = []int{64, 128, 256, 512}
+
+var (
+	ErrUnsupportedImage = fmt.Errorf("avatar must be a jpeg, png or webp image")
+	ErrImageTooLarge    = fmt.Errorf("avatar dimensions are too large")
+)
+
+// Variant is an avatar resized to Size.
+type Variant struct {
+	Size        int
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+// Process checks that data really is a supported image of at most maxPixels
+// and returns it cropped to a square and resized to every size in Sizes.
+// The variants are encoded from the decoded pixels, dropping any metadata
+// like EXIF. Jpegs stay jpegs, everything else becomes a png to keep
+// transparency.
+func Process(data []byte, maxPixels int) ([]Variant, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return nil, ErrUnsupportedImage
+	}
+	// Check the dimensions before decoding to not allocate for huge images
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	square := centerSquare(img.Bounds())
+
+	variants := make([]Variant, 0, len(Sizes))
+	for _, size := range Sizes {
+		dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, square, draw.Src, nil)
+		buf := &bytes.Buffer{}
+		variant := Variant{Size: size}
+		if contentType == "image/jpeg" {
+			variant.ContentType, variant.Ext = "image/jpeg", "jpg"
+			err = jpeg.Encode(buf, dst, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			variant.ContentType, variant.Ext = "image/png", "png"
+			err = png.Encode(buf, dst)
+		}
+		if err != nil {
+			return nil, err
+		}
+		variant.Data = buf.Bytes()
+		variants = append(variants, variant)
+	}
+
+	return variants, nil
+}
+
+func centerSquare(b image.Rectangle) image.Rectangle {
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+
+	return image.Rect(x, y, x+side, y+side)
+}
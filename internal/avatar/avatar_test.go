@@ -0,0 +1,138 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+// testImage returns a w x h image, red on its left half and blue on its
+// right one.
+func testImage(w int, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 0xFF, A: 0xFF}
+			if x >= w/2 {
+				c = color.NRGBA{B: 0xFF, A: 0xFF}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+func testPng(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	gifBuf := &bytes.Buffer{}
+	err := gif.Encode(gifBuf, testImage(4, 4), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pngData := testPng(t, testImage(40, 20))
+	tests := []struct {
+		name            string
+		data            []byte
+		maxPixels       int
+		wantErr         error
+		wantContentType string
+	}{
+		{"png", pngData, 1000, nil, "image/png"},
+		{"jpeg", testJpeg(t, testImage(40, 20)), 1000, nil, "image/jpeg"},
+		{"at the pixel limit", pngData, 800, nil, "image/png"},
+		{"over the pixel limit", pngData, 799, ErrImageTooLarge, ""},
+		{"text", []byte("definitely not an image"), 1000, ErrUnsupportedImage, ""},
+		{"html", []byte("<html><body><img src=x></body></html>"), 1000, ErrUnsupportedImage, ""},
+		{"gif", gifBuf.Bytes(), 1000, ErrUnsupportedImage, ""},
+		{"empty", nil, 1000, ErrUnsupportedImage, ""},
+		{"truncated png", pngData[:len(pngData)/2], 1000, ErrUnsupportedImage, ""},
+		{"png header only", pngData[:16], 1000, ErrUnsupportedImage, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variants, err := Process(tt.data, tt.maxPixels)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Process() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if len(variants) != len(Sizes) {
+				t.Fatalf("Process() = %d variants, want %d", len(variants), len(Sizes))
+			}
+			for i, variant := range variants {
+				if variant.Size != Sizes[i] || variant.ContentType != tt.wantContentType {
+					t.Errorf("variant %d = %d %s, want %d %s", i, variant.Size, variant.ContentType, Sizes[i], tt.wantContentType)
+				}
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(variant.Data))
+				if err != nil {
+					t.Fatalf("variant %d doesn't decode: %v", i, err)
+				}
+				if cfg.Width != variant.Size || cfg.Height != variant.Size {
+					t.Errorf("variant %d is %dx%d, want a %d square", i, cfg.Width, cfg.Height, variant.Size)
+				}
+			}
+		})
+	}
+}
+
+// TestProcessOrientation checks that jpegs are turned upright and that the
+// orientation doesn't survive in the variants, which would turn them again.
+func TestProcessOrientation(t *testing.T) {
+	tests := []struct {
+		orientation int
+		// Where the red left half of the image ends up once upright
+		wantRed string
+	}{
+		{1, "left"},
+		{2, "right"},
+		{3, "right"},
+		{4, "left"},
+		{5, "top"},
+		{6, "top"},
+		{7, "bottom"},
+		{8, "bottom"},
+	}
+	for _, tt := range tests {
+		data := testJpeg(t, testImage(40, 20), testExif(binary.LittleEndian, tt.orientation))
+		variants, err := Process(data, 1000)
+		if err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+		variant := variants[0]
+		if got := jpegOrientation(variant.Data); got != 1 {
+			t.Errorf("orientation %d: variant orientation = %d, want none", tt.orientation, got)
+		}
+		img, _, err := image.Decode(bytes.NewReader(variant.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := variant.Size
+		points := map[string]image.Point{
+			"left":   {size / 4, size / 2},
+			"right":  {size * 3 / 4, size / 2},
+			"top":    {size / 2, size / 4},
+			"bottom": {size / 2, size * 3 / 4},
+		}
+		r, _, b, _ := img.At(points[tt.wantRed].X, points[tt.wantRed].Y).RGBA()
+		if r <= b {
+			t.Errorf("orientation %d: %s of the variant isn't red", tt.orientation, tt.wantRed)
+		}
+	}
+}
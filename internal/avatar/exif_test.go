@@ -0,0 +1,165 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+// testTiff returns a tiff header and first IFD holding only orientation.
+func testTiff(order binary.ByteOrder, orientation int) []byte {
+	tiff := make([]byte, 26)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	// A single SHORT
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], uint16(orientation))
+
+	return tiff
+}
+
+// testSegment returns a jpeg segment of marker.
+func testSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+func testExif(order binary.ByteOrder, orientation int) []byte {
+	return testSegment(0xE1, append([]byte("Exif\x00\x00"), testTiff(order, orientation)...))
+}
+
+// testJpeg returns a jpeg of img with segments inserted after its start of
+// image marker.
+func testJpeg(t *testing.T, img image.Image, segments ...[]byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	out := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+
+	return append(out, data[2:]...)
+}
+
+func TestJpegOrientation(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	app0 := testSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"empty", nil, 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"no exif", testJpeg(t, img), 1},
+		{"little endian", testJpeg(t, img, testExif(binary.LittleEndian, 6)), 6},
+		{"big endian", testJpeg(t, img, testExif(binary.BigEndian, 8)), 8},
+		{"after another segment", testJpeg(t, img, app0, testExif(binary.LittleEndian, 3)), 3},
+		{"out of range", testJpeg(t, img, testExif(binary.LittleEndian, 9)), 1},
+		{"not exif", testJpeg(t, img, testSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"))), 1},
+		{"truncated segment", append([]byte{0xFF, 0xD8}, testExif(binary.LittleEndian, 6)[:10]...), 1},
+		{"garbage between segments", append([]byte{0xFF, 0xD8, 0x00}, testExif(binary.LittleEndian, 6)...), 1},
+		// Metadata after the start of scan is image data
+		{"after start of scan", append(append([]byte{0xFF, 0xD8}, testSegment(0xDA, []byte{0})...), testExif(binary.LittleEndian, 6)...), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTiffOrientation(t *testing.T) {
+	withoutOrientation := testTiff(binary.LittleEndian, 6)
+	binary.LittleEndian.PutUint16(withoutOrientation[10:], 0x010F)
+	badOffset := testTiff(binary.BigEndian, 6)
+	binary.BigEndian.PutUint32(badOffset[4:], 1000)
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"little endian", testTiff(binary.LittleEndian, 5), 5},
+		{"big endian", testTiff(binary.BigEndian, 7), 7},
+		{"zero", testTiff(binary.LittleEndian, 0), 1},
+		{"too short", testTiff(binary.LittleEndian, 6)[:7], 1},
+		{"unknown byte order", append([]byte("XX"), testTiff(binary.LittleEndian, 6)[2:]...), 1},
+		{"offset out of range", badOffset, 1},
+		{"truncated entry", testTiff(binary.LittleEndian, 6)[:20], 1},
+		{"no orientation", withoutOrientation, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tiffOrientation(tt.tiff); got != tt.want {
+				t.Errorf("tiffOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// abc
+	// def
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for i, c := range "abcdef" {
+		src.SetNRGBA(i%3, i/3, color.NRGBA{R: uint8(c), A: 0xFF})
+	}
+	tests := []struct {
+		orientation int
+		want        []string
+	}{
+		{0, []string{"abc", "def"}},
+		{1, []string{"abc", "def"}},
+		// Mirrored horizontally
+		{2, []string{"cba", "fed"}},
+		{3, []string{"fed", "cba"}},
+		// Mirrored vertically
+		{4, []string{"def", "abc"}},
+		// Transposed
+		{5, []string{"ad", "be", "cf"}},
+		// Rotated 90° clockwise
+		{6, []string{"da", "eb", "fc"}},
+		// Transversed
+		{7, []string{"fc", "eb", "da"}},
+		// Rotated 90° counterclockwise
+		{8, []string{"cf", "be", "ad"}},
+		{9, []string{"abc", "def"}},
+	}
+	for _, tt := range tests {
+		img := orient(src, tt.orientation)
+		b := img.Bounds()
+		rows := []string{}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := strings.Builder{}
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				row.WriteByte(byte(r >> 8))
+			}
+			rows = append(rows, row.String())
+		}
+		if strings.Join(rows, "/") != strings.Join(tt.want, "/") {
+			t.Errorf("orient(%d) = %v, want %v", tt.orientation, rows, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package avatar
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+// KeyPrefix returns the prefix of the keys of the avatar of userId uploaded
+// as version, the keys of its variants being the prefix followed by the size
+// and extension, e.g. avatars/usr_1/abc/64.png.
+func KeyPrefix(userId uid.Identifier, version string) string {
+	return "avatars/" + userId.String() + "/" + version + "/"
+}
+
+// VariantKeys returns the keys of every size of the avatar of userId stored
+// at key, none when key isn't the key of one of its avatars, so that a user
+// can never have the objects of another one deleted.
+func VariantKeys(userId uid.Identifier, key string) []string {
+	rest, ok := strings.CutPrefix(key, "avatars/"+userId.String()+"/")
+	if !ok {
+		return nil
+	}
+	version, file, ok := strings.Cut(rest, "/")
+	if !ok || version == "" || version == "." || version == ".." || strings.Contains(file, "/") {
+		return nil
+	}
+	dot := strings.LastIndex(file, ".")
+	if dot == -1 {
+		return nil
+	}
+	prefix := KeyPrefix(userId, version)
+	ext := file[dot+1:]
+	keys := make([]string, len(Sizes))
+	for i, size := range Sizes {
+		keys[i] = prefix + strconv.Itoa(size) + "." + ext
+	}
+
+	return keys
+}
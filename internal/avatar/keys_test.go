@@ -0,0 +1,49 @@
+package avatar
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+func TestVariantKeys(t *testing.T) {
+	userId := uid.FromUid(uid.KindEnum("user"), 1)
+	tests := []struct {
+		name string
+		key  string
+		want []string
+	}{
+		{"png", "avatars/user_1/abc/256.png", []string{
+			"avatars/user_1/abc/64.png",
+			"avatars/user_1/abc/128.png",
+			"avatars/user_1/abc/256.png",
+			"avatars/user_1/abc/512.png",
+		}},
+		{"jpeg", "avatars/user_1/abc/64.jpg", []string{
+			"avatars/user_1/abc/64.jpg",
+			"avatars/user_1/abc/128.jpg",
+			"avatars/user_1/abc/256.jpg",
+			"avatars/user_1/abc/512.jpg",
+		}},
+		{"another user", "avatars/user_2/abc/64.png", nil},
+		{"user prefix", "avatars/user_10/abc/64.png", nil},
+		{"not an avatar", "exports/user_1/abc/64.png", nil},
+		{"no version", "avatars/user_1//64.png", nil},
+		{"no file", "avatars/user_1/abc", nil},
+		{"no extension", "avatars/user_1/abc/64", nil},
+		{"nested file", "avatars/user_1/abc/def/64.png", nil},
+		// Keys must not reach the objects of another user
+		{"current version", "avatars/user_1/./64.png", nil},
+		{"parent version", "avatars/user_1/../64.png", nil},
+		{"parent traversal", "avatars/user_1/../user_2/64.png", nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VariantKeys(userId, tt.key); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VariantKeys(%q) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package blob
+
+import "context"
+
+// Store keeps binary objects under slash separated keys and serves them
+// from public urls.
+type Store interface {
+	Put(ctx context.Context, key string, contentType string, data []byte) error
+	Delete(ctx context.Context, key string) error
+	// URL returns the public url of key, URL("") being the common prefix of
+	// every url of the store.
+	URL(key string) string
+}
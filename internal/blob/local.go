@@ -0,0 +1,89 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore is a Store on the local filesystem, mostly meant for
+// development. It serves the stored objects itself.
+type LocalStore interface {
+	Store
+	http.Handler
+}
+
+type localStore struct {
+	dir     string
+	baseUrl string
+	files   http.Handler
+}
+
+// NewLocal returns a store writing to dir whose objects are served under
+// baseUrl, e.g. http://localhost:8080/blobs.
+func NewLocal(dir string, baseUrl string) (LocalStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localStore{
+		dir:     dir,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		files:   http.FileServer(http.Dir(dir)),
+	}, nil
+}
+
+func (s *localStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(name, data, 0o644)
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *localStore) URL(key string) string {
+	return s.baseUrl + "/" + key
+}
+
+// ServeHTTP serves the stored objects, it expects the request path to be
+// relative to the store, i.e. mounted with http.StripPrefix.
+func (s *localStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// No directory listings
+	if strings.HasSuffix(r.URL.Path, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	s.files.ServeHTTP(w, r)
+}
+
+func (s *localStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", errors.New("invalid blob key")
+	}
+
+	return filepath.Join(s.dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,41 @@
+package blob
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	dir := t.TempDir()
+	store := &localStore{dir: dir}
+	tests := []struct {
+		name    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"key", "avatars/user_1/abc/64.png", filepath.Join(dir, "avatars", "user_1", "abc", "64.png"), false},
+		{"file", "64.png", filepath.Join(dir, "64.png"), false},
+		{"empty", "", "", true},
+		{"current dir", ".", "", true},
+		{"parent", "..", "", true},
+		{"parent traversal", "../etc/passwd", "", true},
+		{"nested traversal", "avatars/../../etc/passwd", "", true},
+		{"traversal inside the store", "avatars/../64.png", "", true},
+		{"absolute", "/etc/passwd", "", true},
+		{"current dir segment", "avatars/./64.png", "", true},
+		{"empty segment", "avatars//64.png", "", true},
+		{"trailing slash", "avatars/", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.path(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("path(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("path(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
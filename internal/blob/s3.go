@@ -0,0 +1,56 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+type s3Store struct {
+	svc     *s3.S3
+	bucket  string
+	baseUrl string
+}
+
+// NewS3 returns a store writing to bucket. Objects are served from baseUrl,
+// e.g. a CDN in front of the bucket, or from the bucket itself when empty.
+func NewS3(session *session.Session, bucket string, baseUrl string) Store {
+	if baseUrl == "" {
+		baseUrl = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, aws.StringValue(session.Config.Region))
+	}
+
+	return &s3Store{
+		svc:     s3.New(session),
+		bucket:  bucket,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+	}
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	_, err := s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+
+	return err
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+
+	return err
+}
+
+func (s *s3Store) URL(key string) string {
+	return s.baseUrl + "/" + key
+}
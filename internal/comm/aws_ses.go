@@ -1,6 +1,8 @@
 package comm
 
 import (
+	"context"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ses"
@@ -20,9 +22,11 @@ func NewSES(session *session.Session, sender string) EmailClient {
 }
 
 func (s *awsSES) Send(
+	ctx context.Context,
 	recipients []string,
 	subject string,
-	body string,
+	html string,
+	text string,
 ) error {
 	toAddressess := make([]*string, len(recipients))
 	for i, r := range recipients {
@@ -35,7 +39,10 @@ func (s *awsSES) Send(
 		Message: &ses.Message{
 			Body: &ses.Body{
 				Html: &ses.Content{
-					Data: aws.String(body),
+					Data: aws.String(html),
+				},
+				Text: &ses.Content{
+					Data: aws.String(text),
 				},
 			},
 			Subject: &ses.Content{
@@ -45,7 +52,7 @@ func (s *awsSES) Send(
 		Source: aws.String(s.sender),
 	}
 
-	_, err := s.svc.SendEmail(input)
+	_, err := s.svc.SendEmailWithContext(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,109 @@
+package comm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Mailbox is an EmailClient for local development that never delivers
+// anything. Messages are written to disk as .eml files, only their
+// recipients and subject are logged, and the most recent ones are kept in
+// memory to be read back.
+type Mailbox interface {
+	EmailClient
+	Messages() []*MailboxMessage
+}
+
+type MailboxMessage struct {
+	ID         int       `json:"id"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Subject    string    `json:"subject"`
+	Html       string    `json:"html"`
+	Text       string    `json:"text"`
+	ReceivedAt time.Time `json:"receivedAt"`
+}
+
+type devMailbox struct {
+	mu       sync.Mutex
+	dir      string
+	sender   string
+	size     int
+	nextId   int
+	messages []*MailboxMessage
+}
+
+func NewDevMailbox(dir string, sender string, size int) (Mailbox, error) {
+	if dir != "" {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	return &devMailbox{
+		dir:    dir,
+		sender: sender,
+		size:   size,
+		nextId: 1,
+	}, nil
+}
+
+func (m *devMailbox) Send(
+	ctx context.Context,
+	recipients []string,
+	subject string,
+	html string,
+	text string,
+) error {
+	msg := NewMessage(m.sender, recipients, subject, html, text)
+	m.mu.Lock()
+	mailboxMessage := &MailboxMessage{
+		ID:         m.nextId,
+		From:       msg.From,
+		To:         msg.To,
+		Subject:    msg.Subject,
+		Html:       msg.Html,
+		Text:       msg.Text,
+		ReceivedAt: msg.Date,
+	}
+	m.nextId++
+	m.messages = append(m.messages, mailboxMessage)
+	if len(m.messages) > m.size {
+		m.messages = m.messages[len(m.messages)-m.size:]
+	}
+	m.mu.Unlock()
+
+	// The message itself may hold an otp, it is read from the mailbox instead
+	slog.Info("dev mailbox received a message", "id", mailboxMessage.ID, "to", recipients, "subject", subject)
+	if m.dir == "" {
+		return nil
+	}
+	eml, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%06d.eml", msg.Date.UTC().Format("20060102T150405"), mailboxMessage.ID)
+
+	return os.WriteFile(filepath.Join(m.dir, name), eml, 0o644)
+}
+
+// Messages returns the retained messages, most recent first.
+func (m *devMailbox) Messages() []*MailboxMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	messages := make([]*MailboxMessage, len(m.messages))
+	for i, msg := range m.messages {
+		messages[len(m.messages)-1-i] = msg
+	}
+
+	return messages
+}
@@ -2,82 +2,213 @@ package comm
 
 import (
 	"bytes"
-	"text/template"
+	"context"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/templates"
 )
 
-const signInOtpSubject = "One time password to verify your email."
-const emailUpdateOtpSubject = "One time password to update your email."
+const layoutTemplate = "layout.html"
 
 const (
-	signInOtpTemplate      EmailTemplateEnum = "signInOtpTemplate"
-	emailUpdateOtpTemplate EmailTemplateEnum = "emailUpdateOtpTemplate"
+	signInOtpTemplate                EmailTemplateEnum = "sign_in_otp"
+	emailUpdateOtpTemplate           EmailTemplateEnum = "email_update_otp"
+	accountDeletionScheduledTemplate EmailTemplateEnum = "account_deletion_scheduled"
+	accountDeletedTemplate           EmailTemplateEnum = "account_deleted"
+	dataExportTemplate               EmailTemplateEnum = "data_export"
+	emailChangeRequestedTemplate     EmailTemplateEnum = "email_change_requested"
 )
 
-var templates = map[EmailTemplateEnum]string{
-	signInOtpTemplate:      "internal/templates/sign_in_otp_template.html",
-	emailUpdateOtpTemplate: "internal/templates/email_update_otp_template.html",
+var emailTemplates = []EmailTemplateEnum{
+	signInOtpTemplate,
+	emailUpdateOtpTemplate,
+	accountDeletionScheduledTemplate,
+	accountDeletedTemplate,
+	dataExportTemplate,
+	emailChangeRequestedTemplate,
 }
 
 type EmailTemplateEnum string
 
 type Emailer interface {
-	SendSignInOTP(email, otp string) error
-	SendEmailUpdateOTP(email, otp string) error
+	SendSignInOTP(ctx context.Context, locale, email, otp string) error
+	SendEmailUpdateOTP(ctx context.Context, locale, email, otp string) error
+	SendAccountDeletionScheduled(ctx context.Context, locale, email string, deleteAfter time.Time) error
+	SendAccountDeleted(ctx context.Context, locale, email string) error
+	SendDataExport(ctx context.Context, locale, email string, exportedAt time.Time, downloadUrl string, expiresAt time.Time) error
+	SendEmailChangeRequested(ctx context.Context, locale, email, newEmail, undoUrl string) error
+	WithClient(client EmailClient) Emailer
 }
 
 type EmailClient interface {
-	Send(recipients []string, subject string, body string) error
+	Send(ctx context.Context, recipients []string, subject string, html string, text string) error
 }
 
 type emailer struct {
-	client    EmailClient
-	templates map[EmailTemplateEnum]*template.Template
+	client EmailClient
+	// Parsed templates by locale and then by template name
+	templates map[string]map[EmailTemplateEnum]*template.Template
 }
 
 type SendOTP struct {
 	OTP string
 }
 
-func NewEmailer(client EmailClient) (Emailer, error) {
+type SendAccountDeletionScheduled struct {
+	DeleteAfter time.Time
+}
+
+type SendDataExport struct {
+	ExportedAt  time.Time
+	DownloadUrl string
+	ExpiresAt   time.Time
+}
+
+type SendEmailChangeRequested struct {
+	NewEmail string
+	UndoUrl  string
+}
+
+// NewEmailer parses the email templates of every supported locale. A locale
+// template defines the "subject" and "content" blocks rendered inside the
+// shared layout, and optionally a "text" block used as the plain text
+// alternative instead of the one derived from the content.
+//
+// Templates are embedded in the binary, any file found under templateDir
+// with the same relative path, e.g. es/sign_in_otp.html, takes precedence.
+func NewEmailer(client EmailClient, templateDir string) (Emailer, error) {
 	emailer := &emailer{
 		client:    client,
-		templates: map[EmailTemplateEnum]*template.Template{},
+		templates: map[string]map[EmailTemplateEnum]*template.Template{},
 	}
-	for k, v := range templates {
-		tmpl, err := template.ParseFiles(v)
-		if err != nil {
-			return nil, err
+	fsys := newOverlayFS(templateDir, templates.FS)
+	for _, locale := range Locales {
+		emailer.templates[locale] = map[EmailTemplateEnum]*template.Template{}
+		for _, name := range emailTemplates {
+			tmpl, err := parseTemplate(fsys, locale, name)
+			if err != nil {
+				return nil, err
+			}
+			emailer.templates[locale][name] = tmpl
 		}
-		emailer.templates[k] = tmpl
 	}
 
 	return emailer, nil
 }
 
-func (e *emailer) SendSignInOTP(email, otp string) error {
-	html := bytes.Buffer{}
-	err := e.templates[signInOtpTemplate].Execute(&html, &SendOTP{OTP: otp})
+// WithClient returns an Emailer sharing the parsed templates that sends
+// through client, e.g. an outbox bound to the current transaction.
+func (e *emailer) WithClient(client EmailClient) Emailer {
+	return &emailer{
+		client:    client,
+		templates: e.templates,
+	}
+}
+
+func (e *emailer) SendSignInOTP(ctx context.Context, locale, email, otp string) error {
+	return e.send(ctx, locale, email, signInOtpTemplate, &SendOTP{OTP: otp})
+}
+
+func (e *emailer) SendEmailUpdateOTP(ctx context.Context, locale, email, otp string) error {
+	return e.send(ctx, locale, email, emailUpdateOtpTemplate, &SendOTP{OTP: otp})
+}
+
+func (e *emailer) SendAccountDeletionScheduled(ctx context.Context, locale, email string, deleteAfter time.Time) error {
+	return e.send(ctx, locale, email, accountDeletionScheduledTemplate, &SendAccountDeletionScheduled{
+		DeleteAfter: deleteAfter,
+	})
+}
+
+func (e *emailer) SendAccountDeleted(ctx context.Context, locale, email string) error {
+	return e.send(ctx, locale, email, accountDeletedTemplate, nil)
+}
+
+func (e *emailer) SendDataExport(ctx context.Context, locale, email string, exportedAt time.Time, downloadUrl string, expiresAt time.Time) error {
+	return e.send(ctx, locale, email, dataExportTemplate, &SendDataExport{
+		ExportedAt:  exportedAt,
+		DownloadUrl: downloadUrl,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+func (e *emailer) SendEmailChangeRequested(ctx context.Context, locale, email, newEmail, undoUrl string) error {
+	return e.send(ctx, locale, email, emailChangeRequestedTemplate, &SendEmailChangeRequested{
+		NewEmail: newEmail,
+		UndoUrl:  undoUrl,
+	})
+}
+
+func (e *emailer) send(ctx context.Context, locale, email string, name EmailTemplateEnum, data interface{}) error {
+	localeTemplates, ok := e.templates[locale]
+	if !ok {
+		localeTemplates = e.templates[DefaultLocale]
+	}
+	tmpl := localeTemplates[name]
+	subject := bytes.Buffer{}
+	err := tmpl.ExecuteTemplate(&subject, "subject", data)
 	if err != nil {
-		return nil
+		return err
 	}
-	err = e.client.Send([]string{email}, signInOtpSubject, html.String())
+	body := bytes.Buffer{}
+	err = tmpl.ExecuteTemplate(&body, "layout", data)
 	if err != nil {
 		return err
 	}
+	text := bytes.Buffer{}
+	if tmpl.Lookup("text") != nil {
+		err = tmpl.ExecuteTemplate(&text, "text", data)
+		if err != nil {
+			return err
+		}
+		// Like the subject it is rendered with html escaping
+		unescaped := html.UnescapeString(text.String())
+		text.Reset()
+		text.WriteString(unescaped)
+	} else {
+		content := bytes.Buffer{}
+		err = tmpl.ExecuteTemplate(&content, "content", data)
+		if err != nil {
+			return err
+		}
+		text.WriteString(HtmlToText(content.String()))
+	}
 
-	return nil
+	// The subject is rendered with html escaping, undo it for the header
+	return e.client.Send(
+		ctx,
+		[]string{email},
+		strings.TrimSpace(html.UnescapeString(subject.String())),
+		body.String(),
+		strings.TrimSpace(text.String()),
+	)
 }
 
-func (e *emailer) SendEmailUpdateOTP(email, otp string) error {
-	html := bytes.Buffer{}
-	err := e.templates[emailUpdateOtpTemplate].Execute(&html, &SendOTP{OTP: otp})
-	if err != nil {
-		return nil
+// parseTemplate parses the layout together with the locale's version of the
+// named template, falling back to the default locale when it's missing.
+func parseTemplate(fsys fs.FS, locale string, name EmailTemplateEnum) (*template.Template, error) {
+	file := path.Join(locale, string(name)+".html")
+	if _, err := fs.Stat(fsys, file); err != nil {
+		file = path.Join(DefaultLocale, string(name)+".html")
 	}
-	err = e.client.Send([]string{email}, emailUpdateOtpSubject, html.String())
+	tmpl, err := template.New(layoutTemplate).
+		Funcs(template.FuncMap{
+			"locale": func() string { return locale },
+		}).
+		ParseFS(fsys, layoutTemplate, file)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, block := range []string{"subject", "content"} {
+		if tmpl.Lookup(block) == nil {
+			return nil, fmt.Errorf("template %s does not define %q", file, block)
+		}
 	}
 
-	return nil
+	return tmpl, nil
 }
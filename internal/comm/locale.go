@@ -0,0 +1,46 @@
+package comm
+
+import (
+	"golang.org/x/text/language"
+)
+
+const DefaultLocale = "en"
+
+// Locales are the locales emails can be sent in, the first one being the
+// default.
+var Locales = []string{DefaultLocale, "hi", "es"}
+
+var localeMatcher = func() language.Matcher {
+	tags := make([]language.Tag, len(Locales))
+	for i, l := range Locales {
+		tags[i] = language.Make(l)
+	}
+
+	return language.NewMatcher(tags)
+}()
+
+// MatchLocale returns the supported locale closest to the given preferences,
+// in order of priority. Each preference is either a single language tag or
+// an Accept-Language header value.
+func MatchLocale(preferences ...string) string {
+	tags := []language.Tag{}
+	for _, p := range preferences {
+		if p == "" {
+			continue
+		}
+		t, _, err := language.ParseAcceptLanguage(p)
+		if err != nil {
+			continue
+		}
+		tags = append(tags, t...)
+	}
+	if len(tags) == 0 {
+		return DefaultLocale
+	}
+	_, index, confidence := localeMatcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+
+	return Locales[index]
+}
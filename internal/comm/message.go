@@ -0,0 +1,131 @@
+package comm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	blockTagRegexp = regexp.MustCompile(`(?i)<\s*(br|/p|/div|/h[1-6]|/li|/tr|hr)[^>]*>`)
+	tagRegexp      = regexp.MustCompile(`<[^>]*>`)
+	blankRegexp    = regexp.MustCompile(`[ \t]+`)
+	newlineRegexp  = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// Message is a multipart/alternative email with an html body and its plain
+// text rendition.
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Html    string
+	Text    string
+	Date    time.Time
+}
+
+// NewMessage builds a message, deriving the plain text part from the html one
+// when text is empty.
+func NewMessage(from string, to []string, subject string, html string, text string) *Message {
+	if text == "" {
+		text = HtmlToText(html)
+	}
+
+	return &Message{
+		From:    from,
+		To:      to,
+		Subject: subject,
+		Html:    html,
+		Text:    text,
+		Date:    time.Now(),
+	}
+}
+
+// Bytes renders the message in RFC 5322 format, ready to be handed to an MTA.
+func (m *Message) Bytes() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	messageId, err := newMessageId(m.From)
+	if err != nil {
+		return nil, err
+	}
+	headers := []string{
+		"From: " + m.From,
+		"To: " + strings.Join(m.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", m.Subject),
+		"Date: " + m.Date.Format(time.RFC1123Z),
+		"Message-ID: " + messageId,
+		"MIME-Version: 1.0",
+		fmt.Sprintf(`Content-Type: multipart/alternative; boundary="%s"`, mw.Boundary()),
+	}
+	header := strings.Join(headers, "\r\n") + "\r\n\r\n"
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{contentType: "text/plain; charset=utf-8", body: m.Text},
+		{contentType: "text/html; charset=utf-8", body: m.Html},
+	}
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(w)
+		_, err = qw.Write([]byte(p.body))
+		if err != nil {
+			return nil, err
+		}
+		err = qw.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(header), buf.Bytes()...), nil
+}
+
+// HtmlToText is a best effort conversion of an html email body to plain text
+// for clients that don't render html.
+func HtmlToText(body string) string {
+	text := blockTagRegexp.ReplaceAllString(body, "\n")
+	text = tagRegexp.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(blankRegexp.ReplaceAllString(line, " "))
+	}
+	text = strings.Join(lines, "\n")
+	text = newlineRegexp.ReplaceAllString(text, "\n\n")
+
+	return strings.TrimSpace(text)
+}
+
+func newMessageId(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i != -1 {
+		domain = strings.Trim(from[i+1:], "> ")
+	}
+
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain), nil
+}
@@ -0,0 +1,29 @@
+package comm
+
+import "context"
+
+type EmailQueue interface {
+	Enqueue(ctx context.Context, recipients []string, subject string, html string, text string) error
+}
+
+type outbox struct {
+	queue EmailQueue
+}
+
+// NewOutbox returns an EmailClient that queues messages instead of sending
+// them, leaving delivery and retries to the email delivery job.
+func NewOutbox(queue EmailQueue) EmailClient {
+	return &outbox{
+		queue: queue,
+	}
+}
+
+func (o *outbox) Send(
+	ctx context.Context,
+	recipients []string,
+	subject string,
+	html string,
+	text string,
+) error {
+	return o.queue.Enqueue(ctx, recipients, subject, html, text)
+}
@@ -0,0 +1,68 @@
+package comm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const previewRecipient = "jane@example.com"
+
+// TemplateNames lists the email templates that can be previewed.
+func TemplateNames() []string {
+	names := make([]string, len(emailTemplates))
+	for i, name := range emailTemplates {
+		names[i] = string(name)
+	}
+
+	return names
+}
+
+type previewClient struct {
+	message *Message
+}
+
+func (c *previewClient) Send(
+	ctx context.Context,
+	recipients []string,
+	subject string,
+	html string,
+	text string,
+) error {
+	c.message = NewMessage("", recipients, subject, html, text)
+
+	return nil
+}
+
+// Preview renders the named template in locale with sample data, using the
+// same templates and overrides as NewEmailer, without sending anything.
+func Preview(templateDir string, locale string, name string) (*Message, error) {
+	client := &previewClient{}
+	emailer, err := NewEmailer(client, templateDir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch EmailTemplateEnum(name) {
+	case signInOtpTemplate:
+		err = emailer.SendSignInOTP(context.Background(), locale, previewRecipient, "123456")
+	case emailUpdateOtpTemplate:
+		err = emailer.SendEmailUpdateOTP(context.Background(), locale, previewRecipient, "123456")
+	case accountDeletionScheduledTemplate:
+		err = emailer.SendAccountDeletionScheduled(context.Background(), locale, previewRecipient, now.Add(30*24*time.Hour))
+	case accountDeletedTemplate:
+		err = emailer.SendAccountDeleted(context.Background(), locale, previewRecipient)
+	case dataExportTemplate:
+		err = emailer.SendDataExport(context.Background(), locale, previewRecipient, now, "http://localhost:8080/user/export/download?token=sample", now.Add(72*time.Hour))
+	case emailChangeRequestedTemplate:
+		err = emailer.SendEmailChangeRequested(context.Background(), locale, previewRecipient, "john@example.com", "http://localhost:8080/user/email/undo?token=sample")
+	default:
+		return nil, fmt.Errorf("unknown email template %q, expected one of %s", name, strings.Join(TemplateNames(), ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return client.message, nil
+}
@@ -0,0 +1,265 @@
+package comm
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+type SmtpTlsEnum string
+type SmtpAuthEnum string
+
+const (
+	SmtpTlsNone     SmtpTlsEnum = "none"
+	SmtpTlsStartTls SmtpTlsEnum = "starttls"
+	SmtpTlsImplicit SmtpTlsEnum = "tls"
+)
+
+const (
+	SmtpAuthPlain SmtpAuthEnum = "plain"
+	SmtpAuthLogin SmtpAuthEnum = "login"
+)
+
+const (
+	smtpDialTimeout = 10 * time.Second
+	// Bounds a whole message, from resetting a pooled connection to the end
+	// of DATA, unless the context expires earlier
+	smtpSendTimeout = 30 * time.Second
+)
+
+type SmtpOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+	Tls      SmtpTlsEnum
+	Auth     SmtpAuthEnum
+	PoolSize int
+}
+
+type smtpClient struct {
+	options   SmtpOptions
+	tlsConfig *tls.Config
+	// Idle authenticated connections ready to be reused
+	pool chan *smtpConn
+}
+
+// smtpConn keeps the connection of a client, which net/smtp doesn't expose,
+// to set its deadlines.
+type smtpConn struct {
+	*smtp.Client
+	conn net.Conn
+}
+
+func NewSMTP(options SmtpOptions) (EmailClient, error) {
+	switch options.Tls {
+	case SmtpTlsNone, SmtpTlsStartTls, SmtpTlsImplicit:
+	default:
+		return nil, fmt.Errorf("invalid smtp tls mode %q", options.Tls)
+	}
+	switch options.Auth {
+	case SmtpAuthPlain, SmtpAuthLogin:
+	default:
+		return nil, fmt.Errorf("invalid smtp auth mechanism %q", options.Auth)
+	}
+	if options.PoolSize < 1 {
+		options.PoolSize = 1
+	}
+
+	return &smtpClient{
+		options:   options,
+		tlsConfig: &tls.Config{ServerName: options.Host},
+		pool:      make(chan *smtpConn, options.PoolSize),
+	}, nil
+}
+
+func (s *smtpClient) Send(
+	ctx context.Context,
+	recipients []string,
+	subject string,
+	html string,
+	text string,
+) error {
+	msg, err := NewMessage(s.options.Sender, recipients, subject, html, text).Bytes()
+	if err != nil {
+		return err
+	}
+	client, err := s.conn(ctx)
+	if err != nil {
+		return err
+	}
+	// Cancelling the context interrupts whatever command is in flight
+	stop := context.AfterFunc(ctx, func() {
+		client.conn.SetDeadline(time.Now())
+	})
+	err = s.send(client, recipients, msg)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		// The connection state is unknown after a failure, never reuse it
+		client.Close()
+		return err
+	}
+	s.release(client)
+
+	return nil
+}
+
+func (s *smtpClient) send(client *smtpConn, recipients []string, msg []byte) error {
+	err := client.Mail(s.options.Sender)
+	if err != nil {
+		return err
+	}
+	for _, r := range recipients {
+		err = client.Rcpt(r)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// conn returns an idle pooled connection that is still alive or dials a new
+// one, its deadline set for sending one message.
+func (s *smtpClient) conn(ctx context.Context) (*smtpConn, error) {
+	for {
+		select {
+		case client := <-s.pool:
+			setSmtpDeadline(ctx, client.conn)
+			if client.Reset() == nil {
+				return client, nil
+			}
+			client.Close()
+		default:
+			return s.dial(ctx)
+		}
+	}
+}
+
+func (s *smtpClient) release(client *smtpConn) {
+	select {
+	case s.pool <- client:
+	default:
+		client.Quit()
+	}
+}
+
+func (s *smtpClient) dial(ctx context.Context) (*smtpConn, error) {
+	addr := net.JoinHostPort(s.options.Host, strconv.Itoa(s.options.Port))
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	var conn net.Conn
+	var err error
+	if s.options.Tls == SmtpTlsImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Covers the greeting, STARTTLS and AUTH as well as the first message
+	setSmtpDeadline(ctx, conn)
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+	client, err := smtp.NewClient(conn, s.options.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	err = func() error {
+		if s.options.Tls == SmtpTlsStartTls {
+			if ok, _ := client.Extension("STARTTLS"); !ok {
+				return errors.New("smtp server does not support STARTTLS")
+			}
+			err := client.StartTLS(s.tlsConfig)
+			if err != nil {
+				return err
+			}
+		}
+		if s.options.Username == "" {
+			return nil
+		}
+		var auth smtp.Auth
+		switch s.options.Auth {
+		case SmtpAuthLogin:
+			auth = &loginAuth{host: s.options.Host, username: s.options.Username, password: s.options.Password}
+		default:
+			auth = smtp.PlainAuth("", s.options.Username, s.options.Password, s.options.Host)
+		}
+
+		return client.Auth(auth)
+	}()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &smtpConn{Client: client, conn: conn}, nil
+}
+
+// setSmtpDeadline bounds the commands sent over conn by smtpSendTimeout or
+// the deadline of ctx, whichever comes first. The deadline of a tls
+// connection is the one of the connection underneath.
+func setSmtpDeadline(ctx context.Context, conn net.Conn) {
+	deadline := time.Now().Add(smtpSendTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+}
+
+// loginAuth implements the non standard but widely used AUTH LOGIN
+// mechanism, which net/smtp doesn't provide.
+type loginAuth struct {
+	host     string
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Same rule as smtp.PlainAuth, never send credentials in the clear
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:", "User Name\x00":
+		return []byte(a.username), nil
+	case "Password:", "Password\x00":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,503 @@
+package comm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	standInUsername = "mailer"
+	standInPassword = "secret"
+)
+
+type standInMessage struct {
+	from   string
+	to     []string
+	data   []byte
+	tls    bool
+	authed bool
+}
+
+// smtpStandIn is an in-process SMTP server speaking just enough of the
+// protocol for the client: EHLO, STARTTLS, AUTH PLAIN and LOGIN, MAIL,
+// RCPT, DATA, RSET and QUIT.
+type smtpStandIn struct {
+	listener    net.Listener
+	tlsConfig   *tls.Config
+	implicitTls bool
+	noStartTls  bool
+	// Never greets clients, to test deadlines
+	stall bool
+	// Closes the connection once a message is accepted
+	closeAfterMessage bool
+
+	mu       sync.Mutex
+	conns    int
+	resets   int
+	messages []standInMessage
+}
+
+func newSmtpStandIn(t *testing.T, configure func(*smtpStandIn)) (*smtpStandIn, *x509.CertPool) {
+	t.Helper()
+	cert, pool := standInCertificate(t)
+	s := &smtpStandIn{
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+	if configure != nil {
+		configure(s)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.listener = listener
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+
+	return s, pool
+}
+
+func (s *smtpStandIn) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	isTls := false
+	if s.implicitTls {
+		conn = tls.Server(conn, s.tlsConfig)
+		isTls = true
+	}
+	if s.stall {
+		_, _ = io.Copy(io.Discard, conn)
+		return
+	}
+	tp := textproto.NewConn(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			_ = tp.PrintfLine("%s", line)
+		}
+	}
+	reply("220 stand-in ESMTP")
+	authed := false
+	message := standInMessage{}
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"250-stand-in"}
+			if !isTls && !s.noStartTls {
+				lines = append(lines, "250-STARTTLS")
+			}
+			reply(append(lines, "250-AUTH PLAIN LOGIN", "250 8BITMIME")...)
+		case "STARTTLS":
+			reply("220 ready to start tls")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			isTls = true
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			username, password := "", ""
+			switch strings.ToUpper(mechanism) {
+			case "PLAIN":
+				decoded, _ := base64.StdEncoding.DecodeString(initial)
+				parts := strings.Split(string(decoded), "\x00")
+				if len(parts) == 3 {
+					username, password = parts[1], parts[2]
+				}
+			case "LOGIN":
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				username = s.readBase64(tp)
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				password = s.readBase64(tp)
+			}
+			if username != standInUsername || password != standInPassword {
+				reply("535 authentication failed")
+				continue
+			}
+			authed = true
+			reply("235 authenticated")
+		case "MAIL":
+			message = standInMessage{from: addressOf(arg), tls: isTls, authed: authed}
+			reply("250 ok")
+		case "RCPT":
+			message.to = append(message.to, addressOf(arg))
+			reply("250 ok")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.data = data
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			reply("250 queued")
+			if s.closeAfterMessage {
+				return
+			}
+		case "RSET":
+			s.mu.Lock()
+			s.resets++
+			s.mu.Unlock()
+			message = standInMessage{}
+			reply("250 ok")
+		case "NOOP":
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+func (s *smtpStandIn) readBase64(tp *textproto.Conn) string {
+	line, err := tp.ReadLine()
+	if err != nil {
+		return ""
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(line)
+
+	return string(decoded)
+}
+
+func (s *smtpStandIn) received() []standInMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]standInMessage{}, s.messages...)
+}
+
+func (s *smtpStandIn) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conns, s.resets
+}
+
+// addressOf extracts the address of a "FROM:<address>" argument.
+func addressOf(arg string) string {
+	start := strings.Index(arg, "<")
+	end := strings.LastIndex(arg, ">")
+	if start == -1 || end < start {
+		return arg
+	}
+
+	return arg[start+1 : end]
+}
+
+// standInCertificate returns a self signed certificate for 127.0.0.1 and
+// the pool trusting it.
+func standInCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "stand-in"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func newStandInClient(t *testing.T, s *smtpStandIn, pool *x509.CertPool, options SmtpOptions) *smtpClient {
+	t.Helper()
+	options.Host = "127.0.0.1"
+	options.Port = s.port()
+	options.Sender = "auth@example.com"
+	if options.Auth == "" {
+		options.Auth = SmtpAuthPlain
+	}
+	client, err := NewSMTP(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smtpClient := client.(*smtpClient)
+	smtpClient.tlsConfig = &tls.Config{ServerName: options.Host, RootCAs: pool}
+
+	return smtpClient
+}
+
+func TestSmtpSend(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*smtpStandIn)
+		options   SmtpOptions
+		wantTls   bool
+		wantAuth  bool
+	}{
+		{
+			name:     "starttls with plain auth",
+			options:  SmtpOptions{Tls: SmtpTlsStartTls, Auth: SmtpAuthPlain, Username: standInUsername, Password: standInPassword},
+			wantTls:  true,
+			wantAuth: true,
+		},
+		{
+			name:      "implicit tls with login auth",
+			configure: func(s *smtpStandIn) { s.implicitTls = true },
+			options:   SmtpOptions{Tls: SmtpTlsImplicit, Auth: SmtpAuthLogin, Username: standInUsername, Password: standInPassword},
+			wantTls:   true,
+			wantAuth:  true,
+		},
+		{
+			name:    "no tls without auth",
+			options: SmtpOptions{Tls: SmtpTlsNone},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, pool := newSmtpStandIn(t, tt.configure)
+			client := newStandInClient(t, s, pool, tt.options)
+			err := client.Send(context.Background(), []string{"john@example.com"}, "Hello", "<p>Hi <b>John</b></p>", "Hi John")
+			if err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			messages := s.received()
+			if len(messages) != 1 {
+				t.Fatalf("received %d messages, want 1", len(messages))
+			}
+			message := messages[0]
+			if message.tls != tt.wantTls {
+				t.Errorf("tls = %v, want %v", message.tls, tt.wantTls)
+			}
+			if message.authed != tt.wantAuth {
+				t.Errorf("authed = %v, want %v", message.authed, tt.wantAuth)
+			}
+			if message.from != "auth@example.com" {
+				t.Errorf("from = %q, want auth@example.com", message.from)
+			}
+			if len(message.to) != 1 || message.to[0] != "john@example.com" {
+				t.Errorf("to = %v, want [john@example.com]", message.to)
+			}
+		})
+	}
+}
+
+func TestSmtpSendMultipart(t *testing.T) {
+	s, pool := newSmtpStandIn(t, nil)
+	client := newStandInClient(t, s, pool, SmtpOptions{Tls: SmtpTlsStartTls})
+	html := "<p>Your code is <b>123456</b>, it expires in 5 minutes.</p>"
+	text := "Your code is 123456, it expires in 5 minutes."
+	err := client.Send(context.Background(), []string{"john@example.com"}, "Votre code ✓", html, text)
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	messages := s.received()
+	if len(messages) != 1 {
+		t.Fatalf("received %d messages, want 1", len(messages))
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(messages[0].data)))
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Votre code ✓" {
+		t.Errorf("subject = %q, %v, want %q", subject, err, "Votre code ✓")
+	}
+	if msg.Header.Get("Message-ID") == "" {
+		t.Error("Message-ID header is missing")
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type = %q, %v, want multipart/alternative", mediaType, err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	want := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", text},
+		{"text/html", html},
+	}
+	for _, w := range want {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("NextPart() error = %v", err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if contentType != w.contentType {
+			t.Errorf("part content type = %q, want %q", contentType, w.contentType)
+		}
+		// The reader decodes quoted-printable parts
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("reading part: %v", err)
+		}
+		if string(body) != w.body {
+			t.Errorf("%s part = %q, want %q", w.contentType, body, w.body)
+		}
+	}
+	if _, err := reader.NextPart(); !errors.Is(err, io.EOF) {
+		t.Errorf("NextPart() error = %v, want io.EOF after two parts", err)
+	}
+}
+
+func TestSmtpSendErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*smtpStandIn)
+		options   SmtpOptions
+	}{
+		{
+			name:    "wrong password",
+			options: SmtpOptions{Tls: SmtpTlsStartTls, Username: standInUsername, Password: "wrong"},
+		},
+		{
+			name:      "starttls unsupported",
+			configure: func(s *smtpStandIn) { s.noStartTls = true },
+			options:   SmtpOptions{Tls: SmtpTlsStartTls},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, pool := newSmtpStandIn(t, tt.configure)
+			client := newStandInClient(t, s, pool, tt.options)
+			err := client.Send(context.Background(), []string{"john@example.com"}, "Hello", "<p>Hi</p>", "")
+			if err == nil {
+				t.Fatal("Send() error = nil, want an error")
+			}
+			if len(s.received()) != 0 {
+				t.Error("a message was received")
+			}
+		})
+	}
+}
+
+func TestSmtpPoolReuse(t *testing.T) {
+	s, pool := newSmtpStandIn(t, nil)
+	client := newStandInClient(t, s, pool, SmtpOptions{Tls: SmtpTlsStartTls, Username: standInUsername, Password: standInPassword})
+	for i := 0; i < 3; i++ {
+		err := client.Send(context.Background(), []string{"john@example.com"}, "Hello", "<p>Hi</p>", "")
+		if err != nil {
+			t.Fatalf("Send() %d error = %v", i, err)
+		}
+	}
+	conns, resets := s.counts()
+	if conns != 1 {
+		t.Errorf("connections = %d, want 1", conns)
+	}
+	// Pooled connections are reset before being reused
+	if resets != 2 {
+		t.Errorf("resets = %d, want 2", resets)
+	}
+	if got := len(s.received()); got != 3 {
+		t.Errorf("received %d messages, want 3", got)
+	}
+}
+
+func TestSmtpPoolReplacesClosedConnection(t *testing.T) {
+	s, pool := newSmtpStandIn(t, func(s *smtpStandIn) { s.closeAfterMessage = true })
+	client := newStandInClient(t, s, pool, SmtpOptions{Tls: SmtpTlsNone})
+	for i := 0; i < 2; i++ {
+		err := client.Send(context.Background(), []string{"john@example.com"}, "Hello", "<p>Hi</p>", "")
+		if err != nil {
+			t.Fatalf("Send() %d error = %v", i, err)
+		}
+	}
+	if conns, _ := s.counts(); conns != 2 {
+		t.Errorf("connections = %d, want 2", conns)
+	}
+}
+
+func TestSmtpDeadline(t *testing.T) {
+	s, pool := newSmtpStandIn(t, func(s *smtpStandIn) { s.stall = true })
+	client := newStandInClient(t, s, pool, SmtpOptions{Tls: SmtpTlsNone})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.Send(ctx, []string{"john@example.com"}, "Hello", "<p>Hi</p>", "")
+	if err == nil {
+		t.Fatal("Send() error = nil, want a timeout")
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Send() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send() took %v, want it bounded by the context", elapsed)
+	}
+}
+
+func TestSmtpCancel(t *testing.T) {
+	s, pool := newSmtpStandIn(t, func(s *smtpStandIn) { s.stall = true })
+	client := newStandInClient(t, s, pool, SmtpOptions{Tls: SmtpTlsNone})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Send(ctx, []string{"john@example.com"}, "Hello", "<p>Hi</p>", "")
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Send() error = nil, want an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send() wasn't interrupted by the cancellation")
+	}
+}
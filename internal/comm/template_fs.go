@@ -0,0 +1,37 @@
+package comm
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// overlayFS serves files from dir when present there and from base
+// otherwise, so that individual templates can be overridden at runtime.
+type overlayFS struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+func newOverlayFS(dir string, base fs.FS) fs.FS {
+	if dir == "" {
+		return base
+	}
+
+	return &overlayFS{
+		dir:  os.DirFS(dir),
+		base: base,
+	}
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return o.base.Open(name)
+}
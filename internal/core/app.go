@@ -1,6 +1,7 @@
 package core
 
 import (
+	"log/slog"
 	"runtime"
 	"time"
 
@@ -21,6 +22,9 @@ type App interface {
 	IdGenerator() uid.IdGenerator
 	Emailer() comm.Emailer
 	Validate() *validator.Validate
+	// Logger is the base logger, requests log with the one carrying their id
+	// in their context, see logger.FromContext
+	Logger() *slog.Logger
 }
 
 type app struct {
@@ -32,6 +36,7 @@ type app struct {
 	idGenerator uid.IdGenerator
 	emailer     comm.Emailer
 	validate    *validator.Validate
+	logger      *slog.Logger
 }
 
 type AppOption struct {
@@ -42,6 +47,7 @@ type AppOption struct {
 	IdGenerator uid.IdGenerator
 	Emailer     comm.Emailer
 	Validate    *validator.Validate
+	Logger      *slog.Logger
 }
 
 func NewApp(options AppOption) App {
@@ -54,6 +60,7 @@ func NewApp(options AppOption) App {
 		idGenerator: options.IdGenerator,
 		emailer:     options.Emailer,
 		validate:    options.Validate,
+		logger:      options.Logger,
 	}
 }
 
@@ -77,6 +84,10 @@ func (a *app) IdGenerator() uid.IdGenerator {
 	return a.idGenerator
 }
 
+func (a *app) Logger() *slog.Logger {
+	return a.logger
+}
+
 func (a *app) Validate() *validator.Validate {
 	return a.validate
 }
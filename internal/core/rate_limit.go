@@ -3,10 +3,10 @@ package core
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
 	"github.com/nkbhasker/go-auth-starter/internal/storage"
 	"github.com/redis/go-redis/v9"
 )
@@ -18,59 +18,120 @@ const (
 	RateLimiterKindOtpGenerate RateLimiterKindEnum = "OTP_GENERATE"
 )
 
+type RateLimitAlgorithmEnum string
+
+const (
+	RateLimitAlgorithmSlidingLog  RateLimitAlgorithmEnum = "sliding_log"
+	RateLimitAlgorithmFixedWindow RateLimitAlgorithmEnum = "fixed_window"
+	RateLimitAlgorithmTokenBucket RateLimitAlgorithmEnum = "token_bucket"
+	RateLimitAlgorithmGCRA        RateLimitAlgorithmEnum = "gcra"
+)
+
+var rateLimitScripts = map[RateLimitAlgorithmEnum]*redis.Script{
+	RateLimitAlgorithmSlidingLog:  slidingLogScript,
+	RateLimitAlgorithmFixedWindow: fixedWindowScript,
+	RateLimitAlgorithmTokenBucket: tokenBucketScript,
+	RateLimitAlgorithmGCRA:        gcraScript,
+}
+
+// RateLimitResult is the outcome of evaluating a request against a limit.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Time until the limit is fully available again
+	Reset time.Duration
+	// Time until a rejected request may be retried, zero when allowed
+	RetryAfter time.Duration
+}
+
 type RateLimiter interface {
-	Evaluate(identifier string) (bool, error)
-	Reset(identifier string) error
+	Evaluate(ctx context.Context, identifier string) (RateLimitResult, error)
+	Reset(ctx context.Context, identifier string) error
+}
+
+type RateLimiterOptions struct {
+	Kind      RateLimiterKindEnum
+	Algorithm RateLimitAlgorithmEnum
+	// Requests allowed per window, also the burst size of token bucket and gcra
+	Limit               int
+	TimeWindowInSeconds int
 }
 
 type rateLimiter struct {
-	cacheStore storage.CacheStore
+	cacheStore storage.RedisCacheStore
 	kind       RateLimiterKindEnum
+	script     *redis.Script
 	limit      int
 	window     time.Duration
 }
 
-func NewRateLimiter(
-	cacheStore storage.CacheStore,
-	kind RateLimiterKindEnum,
-	limit int,
-	timeWindowInSeconds int,
-) RateLimiter {
-	return &rateLimiter{
-		cacheStore: cacheStore,
-		kind:       kind,
-		limit:      limit,
-		window:     time.Duration(timeWindowInSeconds * int(time.Second)),
+// NewRateLimiter returns a rate limiter evaluating requests with the given
+// algorithm, sliding log when empty. With redis each evaluation is a single
+// atomic script, limits are otherwise kept in process.
+func NewRateLimiter(cacheStore storage.CacheStore, options RateLimiterOptions) (RateLimiter, error) {
+	algorithm := options.Algorithm
+	if algorithm == "" {
+		algorithm = RateLimitAlgorithmSlidingLog
+	}
+	script, ok := rateLimitScripts[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+	if options.Limit <= 0 || options.TimeWindowInSeconds <= 0 {
+		return nil, fmt.Errorf("%s rate limit and window must be positive", options.Kind)
 	}
+	window := time.Duration(options.TimeWindowInSeconds * int(time.Second))
+	redisStore, ok := cacheStore.(storage.RedisCacheStore)
+	if !ok {
+		return newMemoryRateLimiter(options.Kind, algorithm, options.Limit, window), nil
+	}
+
+	return &rateLimiter{
+		cacheStore: redisStore,
+		kind:       options.Kind,
+		script:     script,
+		limit:      options.Limit,
+		window:     window,
+	}, nil
 }
 
-func (r *rateLimiter) Evaluate(identifier string) (bool, error) {
-	ctx := context.Background()
-	timestamp := time.Now()
-	key := strings.ToLower(fmt.Sprintf(`%s_%s`, r.kind, identifier))
-	pipe := r.cacheStore.DB().TxPipeline()
-	pipe.ZRemRangeByScore(ctx, key, "0.0", strconv.FormatFloat(float64(timestamp.Add(-r.window).UnixMilli()), 'f', -1, 64))
-	pipe.ZAdd(ctx, key, redis.Z{Score: float64(timestamp.UnixMilli()), Member: float64(timestamp.UnixMilli())})
-	results := pipe.ZRange(ctx, key, 0, -1)
-	pipe.Expire(ctx, key, r.window)
-	_, err := pipe.Exec(ctx)
+// Evaluate runs the script of the algorithm with EVALSHA, loading it first
+// when redis doesn't have it cached.
+func (r *rateLimiter) Evaluate(ctx context.Context, identifier string) (RateLimitResult, error) {
+	values, err := r.script.Run(ctx, r.cacheStore.DB(), []string{r.key(identifier)}, r.window.Milliseconds(), r.limit).Int64Slice()
 	if err != nil {
-		return false, err
+		return RateLimitResult{}, err
+	}
+	if len(values) != 4 {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit script result %v", values)
 	}
-	if len(results.Val()) > r.limit {
-		return false, nil
+
+	allowed := values[0] == 1
+	if !allowed {
+		metrics.RateLimitRejected(string(r.kind))
 	}
 
-	return true, nil
+	return RateLimitResult{
+		Allowed:    allowed,
+		Limit:      r.limit,
+		Remaining:  int(values[1]),
+		Reset:      time.Duration(values[2]) * time.Millisecond,
+		RetryAfter: time.Duration(values[3]) * time.Millisecond,
+	}, nil
 }
 
-func (r *rateLimiter) Reset(identifier string) error {
-	ctx := context.Background()
-	key := strings.ToLower(fmt.Sprintf(`%s_%s`, r.kind, identifier))
-	err := r.cacheStore.DB().Del(ctx, key).Err()
+func (r *rateLimiter) Reset(ctx context.Context, identifier string) error {
+	err := r.cacheStore.Del(ctx, r.key(identifier))
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// The identifier is the hash tag of the key, any other key a script needs
+// for it must be tagged the same to stay in its slot with redis cluster.
+func (r *rateLimiter) key(identifier string) string {
+	return strings.ToLower(fmt.Sprintf(`%s_{%s}`, r.kind, identifier))
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
+)
+
+// memoryRateLimit is the state of one identifier, which fields are used
+// depends on the algorithm.
+type memoryRateLimit struct {
+	// Allowed requests of the sliding log
+	log []time.Time
+	// Allowed requests of the fixed window
+	count int
+	// Tokens left in the bucket
+	tokens float64
+	// Start of the fixed window, last refill of the bucket or theoretical
+	// arrival time of gcra
+	at        time.Time
+	expiresAt time.Time
+}
+
+// memoryRateLimiter applies the same algorithms as the redis scripts to
+// state held in process, for single node deployments. Evaluations hold the
+// lock of the limiter, making them atomic like the scripts.
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	kind      RateLimiterKindEnum
+	algorithm RateLimitAlgorithmEnum
+	limit     int
+	window    time.Duration
+	limits    map[string]*memoryRateLimit
+	sweptAt   time.Time
+	now       func() time.Time
+}
+
+func newMemoryRateLimiter(kind RateLimiterKindEnum, algorithm RateLimitAlgorithmEnum, limit int, window time.Duration) *memoryRateLimiter {
+	return &memoryRateLimiter{
+		kind:      kind,
+		algorithm: algorithm,
+		limit:     limit,
+		window:    window,
+		limits:    map[string]*memoryRateLimit{},
+		sweptAt:   time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (r *memoryRateLimiter) Evaluate(ctx context.Context, identifier string) (RateLimitResult, error) {
+	now := r.now()
+	key := strings.ToLower(identifier)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep(now)
+	state, ok := r.limits[key]
+	if !ok || !now.Before(state.expiresAt) {
+		state = &memoryRateLimit{}
+		r.limits[key] = state
+	}
+	var result RateLimitResult
+	switch r.algorithm {
+	case RateLimitAlgorithmFixedWindow:
+		result = r.fixedWindow(state, now)
+	case RateLimitAlgorithmTokenBucket:
+		result = r.tokenBucket(state, now)
+	case RateLimitAlgorithmGCRA:
+		result = r.gcra(state, now)
+	default:
+		result = r.slidingLog(state, now)
+	}
+	result.Limit = r.limit
+	if !result.Allowed {
+		metrics.RateLimitRejected(string(r.kind))
+	}
+
+	return result, nil
+}
+
+func (r *memoryRateLimiter) Reset(ctx context.Context, identifier string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.limits, strings.ToLower(identifier))
+
+	return nil
+}
+
+func (r *memoryRateLimiter) slidingLog(state *memoryRateLimit, now time.Time) RateLimitResult {
+	live := 0
+	for live < len(state.log) && !state.log[live].After(now.Add(-r.window)) {
+		live++
+	}
+	state.log = state.log[live:]
+	result := RateLimitResult{}
+	if len(state.log) < r.limit {
+		state.log = append(state.log, now)
+		state.expiresAt = now.Add(r.window)
+		result.Allowed = true
+	}
+	result.Remaining = r.limit - len(state.log)
+	result.Reset = state.log[0].Add(r.window).Sub(now)
+	if !result.Allowed {
+		result.RetryAfter = result.Reset
+	}
+
+	return result
+}
+
+func (r *memoryRateLimiter) fixedWindow(state *memoryRateLimit, now time.Time) RateLimitResult {
+	result := RateLimitResult{}
+	if state.count < r.limit {
+		if state.count == 0 {
+			state.at = now
+			state.expiresAt = now.Add(r.window)
+		}
+		state.count++
+		result.Allowed = true
+	}
+	result.Remaining = r.limit - state.count
+	result.Reset = state.expiresAt.Sub(now)
+	if !result.Allowed {
+		result.RetryAfter = result.Reset
+	}
+
+	return result
+}
+
+func (r *memoryRateLimiter) tokenBucket(state *memoryRateLimit, now time.Time) RateLimitResult {
+	// Time to refill a token
+	interval := float64(r.window) / float64(r.limit)
+	if state.at.IsZero() {
+		state.tokens = float64(r.limit)
+		state.at = now
+	}
+	tokens := math.Min(float64(r.limit), state.tokens+float64(now.Sub(state.at))/interval)
+	if tokens < 1 {
+		// The bucket is left as is, refilling from the same time gives the
+		// same tokens later
+		return RateLimitResult{
+			Reset:      time.Duration(math.Ceil((float64(r.limit) - tokens) * interval)),
+			RetryAfter: time.Duration(math.Ceil((1 - tokens) * interval)),
+		}
+	}
+	state.tokens = tokens - 1
+	state.at = now
+	reset := time.Duration(math.Ceil((float64(r.limit) - state.tokens) * interval))
+	state.expiresAt = now.Add(reset)
+
+	return RateLimitResult{
+		Allowed:   true,
+		Remaining: int(state.tokens),
+		Reset:     reset,
+	}
+}
+
+func (r *memoryRateLimiter) gcra(state *memoryRateLimit, now time.Time) RateLimitResult {
+	interval := r.window / time.Duration(r.limit)
+	tat := state.at
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(interval)
+	allowAt := next.Add(-r.window)
+	if now.Before(allowAt) {
+		return RateLimitResult{
+			Reset:      tat.Sub(now),
+			RetryAfter: allowAt.Sub(now),
+		}
+	}
+	state.at = next
+	state.expiresAt = next
+
+	return RateLimitResult{
+		Allowed:   true,
+		Remaining: int((r.window - next.Sub(now)) / interval),
+		Reset:     next.Sub(now),
+	}
+}
+
+// sweep drops the state of identifiers that reached their expiry, at most
+// once per window. The limiter must be locked.
+func (r *memoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(r.sweptAt) < r.window {
+		return
+	}
+	r.sweptAt = now
+	for key, state := range r.limits {
+		if !now.Before(state.expiresAt) {
+			delete(r.limits, key)
+		}
+	}
+}
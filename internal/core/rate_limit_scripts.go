@@ -0,0 +1,105 @@
+package core
+
+import "github.com/redis/go-redis/v9"
+
+// The scripts take the window in milliseconds and the limit as arguments and
+// return {allowed, remaining, reset, retry after}, durations in milliseconds.
+// They read the clock of redis so that every server agrees on it.
+
+// slidingLogScript keeps the timestamp of every allowed request in the window.
+// Rejected requests aren't logged, so they don't push back the window.
+var slidingLogScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+local allowed = 0
+if count < limit then
+	-- The count makes members of the same millisecond unique
+	redis.call("ZADD", KEYS[1], now, now .. "-" .. count)
+	redis.call("PEXPIRE", KEYS[1], window)
+	count = count + 1
+	allowed = 1
+end
+local reset = 0
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+local retry = 0
+if allowed == 0 then
+	retry = reset
+end
+return {allowed, limit - count, reset, retry}
+`)
+
+// fixedWindowScript counts the allowed requests of the current window, which
+// starts with the first request.
+var fixedWindowScript = redis.NewScript(`
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+local allowed = 0
+if count < limit then
+	count = redis.call("INCR", KEYS[1])
+	if count == 1 then
+		redis.call("PEXPIRE", KEYS[1], window)
+	end
+	allowed = 1
+end
+local reset = redis.call("PTTL", KEYS[1])
+if reset < 0 then
+	reset = 0
+end
+local retry = 0
+if allowed == 0 then
+	retry = reset
+end
+return {allowed, limit - count, reset, retry}
+`)
+
+// tokenBucketScript holds up to limit tokens and the time they were last
+// refilled at, a token being added every window / limit. A bucket expires
+// once full again, as a missing one is full.
+var tokenBucketScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local interval = window / limit
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "refilled_at")
+local tokens = tonumber(bucket[1]) or limit
+local refilledAt = tonumber(bucket[2]) or now
+tokens = math.min(limit, tokens + math.max(0, now - refilledAt) / interval)
+if tokens < 1 then
+	-- The bucket is left as is, refilling from the same time gives the same
+	-- tokens later
+	return {0, 0, math.ceil((limit - tokens) * interval), math.ceil((1 - tokens) * interval)}
+end
+tokens = tokens - 1
+local reset = math.ceil((limit - tokens) * interval)
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "refilled_at", now)
+redis.call("PEXPIRE", KEYS[1], reset)
+return {1, math.floor(tokens), reset, 0}
+`)
+
+// gcraScript implements the generic cell rate algorithm, storing only the
+// theoretical arrival time of the next request. Requests are spaced by
+// window / limit with bursts of up to limit requests.
+var gcraScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local interval = window / limit
+local tat = math.max(tonumber(redis.call("GET", KEYS[1]) or "0"), now)
+local next = tat + interval
+local allowAt = next - window
+if now < allowAt then
+	return {0, 0, math.ceil(tat - now), math.ceil(allowAt - now)}
+end
+redis.call("SET", KEYS[1], tostring(next), "PX", math.max(1, math.ceil(next - now)))
+return {1, math.floor((window - (next - now)) / interval), math.ceil(next - now), 0}
+`)
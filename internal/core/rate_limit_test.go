@@ -0,0 +1,393 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nkbhasker/go-auth-starter/internal/storage"
+)
+
+var testBackends = []string{"memory", "redis"}
+
+var testAlgorithms = []RateLimitAlgorithmEnum{
+	RateLimitAlgorithmSlidingLog,
+	RateLimitAlgorithmFixedWindow,
+	RateLimitAlgorithmTokenBucket,
+	RateLimitAlgorithmGCRA,
+}
+
+// testClock is the clock of the memory limiters, or of the redis server
+// whose time and key expiries it moves along.
+type testClock struct {
+	now    time.Time
+	server *miniredis.Miniredis
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	if c.server != nil {
+		c.server.SetTime(c.now)
+		c.server.FastForward(d)
+	}
+}
+
+// newTestRateLimiter returns a limiter of backend reading the time from the
+// returned clock, which starts on a whole minute.
+func newTestRateLimiter(tb testing.TB, backend string, algorithm RateLimitAlgorithmEnum, limit int, windowInSeconds int) (RateLimiter, *testClock) {
+	tb.Helper()
+	clock := &testClock{now: time.Unix(1_800_000_000, 0)}
+	var cacheStore storage.CacheStore
+	if backend == "redis" {
+		clock.server = miniredis.RunT(tb)
+		clock.server.SetTime(clock.now)
+		redisStore, err := storage.InitCacheStore(storage.RedisOptions{Url: "redis://" + clock.server.Addr()})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		cacheStore = redisStore
+	} else {
+		cacheStore = storage.NewMemoryCacheStore()
+	}
+	tb.Cleanup(func() {
+		cacheStore.CloseDB()
+	})
+	limiter, err := NewRateLimiter(cacheStore, RateLimiterOptions{
+		Kind:                RateLimiterKindEnum("TEST"),
+		Algorithm:           algorithm,
+		Limit:               limit,
+		TimeWindowInSeconds: windowInSeconds,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if memoryLimiter, ok := limiter.(*memoryRateLimiter); ok {
+		memoryLimiter.now = clock.Now
+	}
+
+	return limiter, clock
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm RateLimitAlgorithmEnum
+		limit     int
+		window    int
+		wantErr   bool
+	}{
+		{"default algorithm", "", 5, 60, false},
+		{"gcra", RateLimitAlgorithmGCRA, 5, 60, false},
+		{"unknown algorithm", RateLimitAlgorithmEnum("leaky_bucket"), 5, 60, true},
+		{"no limit", RateLimitAlgorithmSlidingLog, 0, 60, true},
+		{"no window", RateLimitAlgorithmSlidingLog, 5, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRateLimiter(storage.NewMemoryCacheStore(), RateLimiterOptions{
+				Kind:                RateLimiterKindEnum("TEST"),
+				Algorithm:           tt.algorithm,
+				Limit:               tt.limit,
+				TimeWindowInSeconds: tt.window,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewRateLimiter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateLimiterEvaluate(t *testing.T) {
+	// 5 requests per 10 seconds, one every 2 seconds at a steady rate
+	tests := []struct {
+		algorithm      RateLimitAlgorithmEnum
+		wantRetryAfter time.Duration
+		wantReset      time.Duration
+	}{
+		// The oldest request leaves the log a window after it was made
+		{RateLimitAlgorithmSlidingLog, 10 * time.Second, 10 * time.Second},
+		{RateLimitAlgorithmFixedWindow, 10 * time.Second, 10 * time.Second},
+		// A token is back every 2 seconds
+		{RateLimitAlgorithmTokenBucket, 2 * time.Second, 10 * time.Second},
+		{RateLimitAlgorithmGCRA, 2 * time.Second, 10 * time.Second},
+	}
+	for _, backend := range testBackends {
+		for _, tt := range tests {
+			t.Run(backend+"/"+string(tt.algorithm), func(t *testing.T) {
+				ctx := context.Background()
+				limiter, clock := newTestRateLimiter(t, backend, tt.algorithm, 5, 10)
+				for i := 0; i < 5; i++ {
+					result, err := limiter.Evaluate(ctx, "jane@example.com")
+					if err != nil {
+						t.Fatalf("Evaluate() error = %v", err)
+					}
+					if !result.Allowed || result.Limit != 5 || result.Remaining != 4-i || result.RetryAfter != 0 {
+						t.Fatalf("request %d = %+v, want allowed with %d remaining", i, result, 4-i)
+					}
+				}
+				result, err := limiter.Evaluate(ctx, "jane@example.com")
+				if err != nil {
+					t.Fatalf("Evaluate() error = %v", err)
+				}
+				if result.Allowed || result.Remaining != 0 {
+					t.Fatalf("request over the limit = %+v, want rejected", result)
+				}
+				if result.RetryAfter != tt.wantRetryAfter {
+					t.Errorf("RetryAfter = %v, want %v", result.RetryAfter, tt.wantRetryAfter)
+				}
+				if result.Reset != tt.wantReset {
+					t.Errorf("Reset = %v, want %v", result.Reset, tt.wantReset)
+				}
+
+				clock.Advance(result.RetryAfter - time.Millisecond)
+				result, err = limiter.Evaluate(ctx, "jane@example.com")
+				if err != nil {
+					t.Fatalf("Evaluate() error = %v", err)
+				}
+				if result.Allowed {
+					t.Fatalf("request before retry after = %+v, want rejected", result)
+				}
+				clock.Advance(time.Millisecond)
+				result, err = limiter.Evaluate(ctx, "jane@example.com")
+				if err != nil {
+					t.Fatalf("Evaluate() error = %v", err)
+				}
+				if !result.Allowed {
+					t.Fatalf("request at retry after = %+v, want allowed", result)
+				}
+			})
+		}
+	}
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			// A token every 2 seconds, up to 5
+			limiter, clock := newTestRateLimiter(t, backend, RateLimitAlgorithmTokenBucket, 5, 10)
+			evaluate := func() RateLimitResult {
+				t.Helper()
+				result, err := limiter.Evaluate(ctx, "jane@example.com")
+				if err != nil {
+					t.Fatalf("Evaluate() error = %v", err)
+				}
+				return result
+			}
+			for i := 0; i < 5; i++ {
+				evaluate()
+			}
+			// 2.5 tokens are back
+			clock.Advance(5 * time.Second)
+			if clock.server != nil {
+				bucket := clock.server.HGet("test_{jane@example.com}", "tokens")
+				if bucket != "0" {
+					t.Errorf("stored tokens = %q, want 0", bucket)
+				}
+			}
+			tests := []struct {
+				wantAllowed    bool
+				wantRemaining  int
+				wantRetryAfter time.Duration
+				wantReset      time.Duration
+			}{
+				{true, 1, 0, 7 * time.Second},
+				{true, 0, 0, 9 * time.Second},
+				// Half a token is left, the other half comes in a second
+				{false, 0, time.Second, 9 * time.Second},
+			}
+			for i, tt := range tests {
+				result := evaluate()
+				if result.Allowed != tt.wantAllowed || result.Remaining != tt.wantRemaining ||
+					result.RetryAfter != tt.wantRetryAfter || result.Reset != tt.wantReset {
+					t.Errorf("request %d = %+v, want allowed %v with %d remaining, retry after %v and reset %v",
+						i, result, tt.wantAllowed, tt.wantRemaining, tt.wantRetryAfter, tt.wantReset)
+				}
+			}
+			if clock.server != nil {
+				refilledAt := clock.server.HGet("test_{jane@example.com}", "refilled_at")
+				if want := fmt.Sprint(clock.now.UnixMilli()); refilledAt != want {
+					t.Errorf("stored refill time = %q, want %q", refilledAt, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterSteadyRate(t *testing.T) {
+	for _, backend := range testBackends {
+		for _, algorithm := range testAlgorithms {
+			t.Run(backend+"/"+string(algorithm), func(t *testing.T) {
+				limiter, clock := newTestRateLimiter(t, backend, algorithm, 5, 10)
+				for i := 0; i < 50; i++ {
+					result, err := limiter.Evaluate(context.Background(), "jane@example.com")
+					if err != nil {
+						t.Fatalf("Evaluate() error = %v", err)
+					}
+					if !result.Allowed {
+						t.Fatalf("request %d = %+v, want allowed at the limit rate", i, result)
+					}
+					clock.Advance(2 * time.Second)
+				}
+			})
+		}
+	}
+}
+
+func TestRateLimiterReset(t *testing.T) {
+	for _, backend := range testBackends {
+		for _, algorithm := range testAlgorithms {
+			t.Run(backend+"/"+string(algorithm), func(t *testing.T) {
+				ctx := context.Background()
+				limiter, _ := newTestRateLimiter(t, backend, algorithm, 2, 60)
+				for i := 0; i < 3; i++ {
+					_, err := limiter.Evaluate(ctx, "jane@example.com")
+					if err != nil {
+						t.Fatalf("Evaluate() error = %v", err)
+					}
+				}
+				err := limiter.Reset(ctx, "Jane@Example.com")
+				if err != nil {
+					t.Fatalf("Reset() error = %v", err)
+				}
+				result, err := limiter.Evaluate(ctx, "jane@example.com")
+				if err != nil {
+					t.Fatalf("Evaluate() error = %v", err)
+				}
+				if !result.Allowed || result.Remaining != 1 {
+					t.Errorf("request after reset = %+v, want allowed with 1 remaining", result)
+				}
+			})
+		}
+	}
+}
+
+func TestRateLimiterIdentifiers(t *testing.T) {
+	for _, backend := range testBackends {
+		for _, algorithm := range testAlgorithms {
+			t.Run(backend+"/"+string(algorithm), func(t *testing.T) {
+				ctx := context.Background()
+				limiter, _ := newTestRateLimiter(t, backend, algorithm, 1, 60)
+				tests := []struct {
+					identifier  string
+					wantAllowed bool
+				}{
+					{"jane@example.com", true},
+					{"john@example.com", true},
+					// Identifiers are case insensitive
+					{"Jane@Example.com", false},
+					{"john@example.com", false},
+				}
+				for _, tt := range tests {
+					result, err := limiter.Evaluate(ctx, tt.identifier)
+					if err != nil {
+						t.Fatalf("Evaluate() error = %v", err)
+					}
+					if result.Allowed != tt.wantAllowed {
+						t.Errorf("Evaluate(%q) allowed = %v, want %v", tt.identifier, result.Allowed, tt.wantAllowed)
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestRateLimiterConcurrent checks that evaluations are atomic, exactly limit
+// of concurrent requests being allowed.
+func TestRateLimiterConcurrent(t *testing.T) {
+	for _, backend := range testBackends {
+		for _, algorithm := range testAlgorithms {
+			t.Run(backend+"/"+string(algorithm), func(t *testing.T) {
+				limiter, _ := newTestRateLimiter(t, backend, algorithm, 10, 60)
+				var allowed atomic.Int32
+				wg := sync.WaitGroup{}
+				for i := 0; i < 50; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						result, err := limiter.Evaluate(context.Background(), "jane@example.com")
+						if err != nil {
+							t.Errorf("Evaluate() error = %v", err)
+							return
+						}
+						if result.Allowed {
+							allowed.Add(1)
+						}
+					}()
+				}
+				wg.Wait()
+				if n := allowed.Load(); n != 10 {
+					t.Errorf("allowed %d concurrent requests, want 10", n)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkRateLimiterEvaluate measures the latency of evaluating requests
+// spread over many identifiers, half of them over the limit.
+func BenchmarkRateLimiterEvaluate(b *testing.B) {
+	identifiers := make([]string, 1024)
+	for i := range identifiers {
+		identifiers[i] = fmt.Sprintf("user%d@example.com", i)
+	}
+	for _, algorithm := range testAlgorithms {
+		b.Run(string(algorithm), func(b *testing.B) {
+			ctx := context.Background()
+			limiter, clock := newTestRateLimiter(b, "memory", algorithm, 10, 60)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Every identifier gets 20 requests per window
+				if i%len(identifiers) == 0 {
+					clock.Advance(3 * time.Second)
+				}
+				_, err := limiter.Evaluate(ctx, identifiers[i%len(identifiers)])
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRateLimiterMemory measures the memory kept for an identifier that
+// reached its limit.
+func BenchmarkRateLimiterMemory(b *testing.B) {
+	for _, algorithm := range testAlgorithms {
+		b.Run(string(algorithm), func(b *testing.B) {
+			ctx := context.Background()
+			limiter, _ := newTestRateLimiter(b, "memory", algorithm, 10, 60)
+			identifiers := make([]string, b.N)
+			for i := range identifiers {
+				identifiers[i] = fmt.Sprintf("user%d@example.com", i)
+			}
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			b.ResetTimer()
+			for _, identifier := range identifiers {
+				for j := 0; j < 10; j++ {
+					_, err := limiter.Evaluate(ctx, identifier)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.StopTimer()
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/float64(b.N), "B/identifier")
+			runtime.KeepAlive(limiter)
+		})
+	}
+}
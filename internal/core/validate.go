@@ -1,7 +1,33 @@
 package core
 
-import "github.com/go-playground/validator/v10"
+import (
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
+)
 
 func NewValidate() *validator.Validate {
-	return validator.New(validator.WithRequiredStructEnabled())
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	// Report fields by the name clients send them with
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+
+		return name
+	})
+	validate.RegisterValidation("locale", func(fl validator.FieldLevel) bool {
+		for _, l := range comm.Locales {
+			if fl.Field().String() == l {
+				return true
+			}
+		}
+
+		return false
+	})
+
+	return validate
 }
@@ -0,0 +1,55 @@
+package enum
+
+import "fmt"
+
+type AuditEventTypeEnum string
+
+const (
+	AuditEventTypeOtpRequested         AuditEventTypeEnum = "OTP_REQUESTED"
+	AuditEventTypeOtpVerified          AuditEventTypeEnum = "OTP_VERIFIED"
+	AuditEventTypeOtpFailed            AuditEventTypeEnum = "OTP_FAILED"
+	AuditEventTypeSignIn               AuditEventTypeEnum = "SIGN_IN"
+	AuditEventTypeTokenIssued          AuditEventTypeEnum = "TOKEN_ISSUED"
+	AuditEventTypeTokenRevoked         AuditEventTypeEnum = "TOKEN_REVOKED"
+	AuditEventTypeEmailChanged         AuditEventTypeEnum = "EMAIL_CHANGED"
+	AuditEventTypeEmailChangeRequested AuditEventTypeEnum = "EMAIL_CHANGE_REQUESTED"
+	AuditEventTypeEmailChangeUndone    AuditEventTypeEnum = "EMAIL_CHANGE_UNDONE"
+	AuditEventTypeProfileUpdated       AuditEventTypeEnum = "PROFILE_UPDATED"
+	AuditEventTypeUserSuspended        AuditEventTypeEnum = "USER_SUSPENDED"
+	AuditEventTypeUserReactivated      AuditEventTypeEnum = "USER_REACTIVATED"
+)
+
+func (e *AuditEventTypeEnum) Scan(value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid str")
+	}
+	*e = AuditEventTypeEnum(str)
+
+	return nil
+}
+
+func (e AuditEventTypeEnum) Value() (interface{}, error) {
+	return string(e), nil
+}
+
+type AuditOutcomeEnum string
+
+const (
+	AuditOutcomeSuccess AuditOutcomeEnum = "SUCCESS"
+	AuditOutcomeFailure AuditOutcomeEnum = "FAILURE"
+)
+
+func (e *AuditOutcomeEnum) Scan(value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid str")
+	}
+	*e = AuditOutcomeEnum(str)
+
+	return nil
+}
+
+func (e AuditOutcomeEnum) Value() (interface{}, error) {
+	return string(e), nil
+}
@@ -0,0 +1,25 @@
+package enum
+
+import "fmt"
+
+type DataExportStatusEnum string
+
+const (
+	DataExportStatusPending DataExportStatusEnum = "PENDING"
+	DataExportStatusReady   DataExportStatusEnum = "READY"
+	DataExportStatusFailed  DataExportStatusEnum = "FAILED"
+)
+
+func (e *DataExportStatusEnum) Scan(value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid str")
+	}
+	*e = DataExportStatusEnum(str)
+
+	return nil
+}
+
+func (e DataExportStatusEnum) Value() (interface{}, error) {
+	return string(e), nil
+}
@@ -0,0 +1,25 @@
+package enum
+
+import "fmt"
+
+type EmailStatusEnum string
+
+const (
+	EmailStatusPending EmailStatusEnum = "PENDING"
+	EmailStatusSent    EmailStatusEnum = "SENT"
+	EmailStatusDead    EmailStatusEnum = "DEAD"
+)
+
+func (e *EmailStatusEnum) Scan(value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid str")
+	}
+	*e = EmailStatusEnum(str)
+
+	return nil
+}
+
+func (e EmailStatusEnum) Value() (interface{}, error) {
+	return string(e), nil
+}
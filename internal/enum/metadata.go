@@ -0,0 +1,12 @@
+package enum
+
+type MetadataFieldEnum string
+
+const (
+	// Editable by the user
+	MetadataFieldPublic MetadataFieldEnum = "public"
+	// Only visible to and editable by the server
+	MetadataFieldPrivate MetadataFieldEnum = "private"
+	// Visible to the user but only editable by the server
+	MetadataFieldApp MetadataFieldEnum = "app"
+)
@@ -0,0 +1,25 @@
+package enum
+
+import "fmt"
+
+type UserStatusEnum string
+
+const (
+	UserStatusActive          UserStatusEnum = "ACTIVE"
+	UserStatusSuspended       UserStatusEnum = "SUSPENDED"
+	UserStatusPendingDeletion UserStatusEnum = "PENDING_DELETION"
+)
+
+func (e *UserStatusEnum) Scan(value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid str")
+	}
+	*e = UserStatusEnum(str)
+
+	return nil
+}
+
+func (e UserStatusEnum) Value() (interface{}, error) {
+	return string(e), nil
+}
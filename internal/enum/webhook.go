@@ -0,0 +1,48 @@
+package enum
+
+import "fmt"
+
+type WebhookEventTypeEnum string
+
+const (
+	WebhookEventTypeUserCreated  WebhookEventTypeEnum = "user.created"
+	WebhookEventTypeUserUpdated  WebhookEventTypeEnum = "user.updated"
+	WebhookEventTypeEmailChanged WebhookEventTypeEnum = "email.changed"
+	WebhookEventTypeUserDeleted  WebhookEventTypeEnum = "user.deleted"
+)
+
+func (e *WebhookEventTypeEnum) Scan(value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid str")
+	}
+	*e = WebhookEventTypeEnum(str)
+
+	return nil
+}
+
+func (e WebhookEventTypeEnum) Value() (interface{}, error) {
+	return string(e), nil
+}
+
+type WebhookDeliveryStatusEnum string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatusEnum = "PENDING"
+	WebhookDeliveryStatusSucceeded WebhookDeliveryStatusEnum = "SUCCEEDED"
+	WebhookDeliveryStatusFailed    WebhookDeliveryStatusEnum = "FAILED"
+)
+
+func (e *WebhookDeliveryStatusEnum) Scan(value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid str")
+	}
+	*e = WebhookDeliveryStatusEnum(str)
+
+	return nil
+}
+
+func (e WebhookDeliveryStatusEnum) Value() (interface{}, error) {
+	return string(e), nil
+}
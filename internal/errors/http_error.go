@@ -1,23 +1,137 @@
 package errors
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
 
+	"github.com/nkbhasker/go-auth-starter/internal/logger"
+)
+
+const contentTypeProblem = "application/problem+json"
+
+// HttpError is an error safe to show to clients. Its code is stable for
+// clients to match on, unlike its message.
 type HttpError interface {
 	error
+	Status() int
+	Code() string
+	// Fields maps the invalid fields of a request to what is wrong with them
+	Fields() map[string]string
+	WithFields(fields map[string]string) HttpError
+	// WithCause returns the error with the error behind it, which is logged
+	// but never shown to clients
+	WithCause(err error) HttpError
+	Unwrap() error
 }
 
+var (
+	ErrBadRequest           = NewHttpError(http.StatusBadRequest, "bad_request", "request is malformed")
+	ErrValidation           = NewHttpError(http.StatusUnprocessableEntity, "validation_failed", "request has invalid fields")
+	ErrUnauthorized         = NewHttpError(http.StatusUnauthorized, "unauthorized", "missing or invalid credentials")
+	ErrForbidden            = NewHttpError(http.StatusForbidden, "forbidden", "not allowed")
+	ErrUserSuspended        = NewHttpError(http.StatusForbidden, "user_suspended", "user suspended")
+	ErrNotFound             = NewHttpError(http.StatusNotFound, "not_found", "resource not found")
+	ErrConflict             = NewHttpError(http.StatusConflict, "conflict", "resource already exists")
+	ErrPreconditionFailed   = NewHttpError(http.StatusPreconditionFailed, "precondition_failed", "resource was modified, fetch it again and retry")
+	ErrPayloadTooLarge      = NewHttpError(http.StatusRequestEntityTooLarge, "payload_too_large", "request body is too large")
+	ErrUnsupportedMediaType = NewHttpError(http.StatusUnsupportedMediaType, "unsupported_media_type", "content type is not supported")
+	ErrRateLimited          = NewHttpError(http.StatusTooManyRequests, "rate_limited", "too many requests, retry later")
+	ErrInternal             = NewHttpError(http.StatusInternalServerError, "internal_error", "something went wrong")
+	ErrUnavailable          = NewHttpError(http.StatusServiceUnavailable, "unavailable", "service is unavailable, retry later")
+)
+
 type httpError struct {
+	status  int
 	code    string
 	message string
+	fields  map[string]string
+	cause   error
 }
 
-func NewHttpError(code, message string) HttpError {
+func NewHttpError(status int, code string, message string) HttpError {
 	return &httpError{
+		status:  status,
 		code:    code,
 		message: message,
 	}
 }
 
 func (e httpError) Error() string {
-	return fmt.Sprintf("request failed with status %s and error %s", e.code, e.message)
+	return e.message
+}
+
+func (e httpError) Status() int {
+	return e.status
+}
+
+func (e httpError) Code() string {
+	return e.code
+}
+
+func (e httpError) Fields() map[string]string {
+	return e.fields
+}
+
+func (e httpError) WithFields(fields map[string]string) HttpError {
+	e.fields = fields
+	return &e
+}
+
+func (e httpError) WithCause(err error) HttpError {
+	e.cause = err
+	return &e
+}
+
+func (e httpError) Unwrap() error {
+	return e.cause
+}
+
+// problem is an RFC 7807 problem details object, extended with the error
+// code and invalid fields.
+type problem struct {
+	Type     string            `json:"type"`
+	Title    string            `json:"title"`
+	Status   int               `json:"status"`
+	Detail   string            `json:"detail"`
+	Instance string            `json:"instance"`
+	Code     string            `json:"code"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// Render writes err as application/problem+json. Errors other than an
+// HttpError are internal, they are logged and rendered as ErrInternal so
+// that their message never reaches clients.
+func Render(w http.ResponseWriter, r *http.Request, err error) {
+	var httpErr HttpError
+	if !errors.As(err, &httpErr) {
+		httpErr = ErrInternal.WithCause(err)
+	}
+	if httpErr.Status() >= http.StatusInternalServerError {
+		logger.FromContext(r.Context()).Error("request failed", "code", httpErr.Code(), "error", causeOf(httpErr))
+	}
+	body, err := json.Marshal(&problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(httpErr.Status()),
+		Status:   httpErr.Status(),
+		Detail:   httpErr.Error(),
+		Instance: r.URL.Path,
+		Code:     httpErr.Code(),
+		Fields:   httpErr.Fields(),
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeProblem)
+	w.WriteHeader(httpErr.Status())
+	w.Write(body)
+}
+
+func causeOf(err HttpError) error {
+	if cause := err.Unwrap(); cause != nil {
+		return cause
+	}
+
+	return err
 }
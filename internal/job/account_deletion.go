@@ -0,0 +1,123 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/avatar"
+	"github.com/nkbhasker/go-auth-starter/internal/blob"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/repo"
+	"github.com/nkbhasker/go-auth-starter/internal/worker"
+)
+
+const accountDeletionBatchSize = 100
+
+// AccountDeletion permanently deletes users whose deletion grace period has
+// ended along with the data kept about them, revokes their access tokens and
+// confirms the deletion by email.
+func AccountDeletion(app core.App, blobStore blob.Store) worker.Task {
+	return func(ctx context.Context) error {
+		users, err := app.Repo().UserRepo().ListDueForDeletion(ctx, time.Now(), accountDeletionBatchSize)
+		if err != nil {
+			return err
+		}
+		errs := []error{}
+		for _, user := range users {
+			err := app.Repo().AccessTokenRepo().RevokeAll(ctx, user.ID.String())
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			reason := "account deleted"
+			err = app.Repo().AuditEventRepo().Record(ctx, model.AuditEvent{
+				UserID:  user.ID,
+				Type:    enum.AuditEventTypeTokenRevoked,
+				Outcome: enum.AuditOutcomeSuccess,
+				Reason:  &reason,
+			})
+			if err != nil {
+				errs = append(errs, err)
+			}
+			err = app.Repo().InTx(ctx, func(txRepo repo.Repo) error {
+				err := txRepo.UserRepo().Delete(ctx, user)
+				if err != nil {
+					return err
+				}
+				// Security events are kept for the retention period, without
+				// anything identifying the user
+				err = txRepo.AuditEventRepo().Anonymize(ctx, user.ID, user.Email)
+				if err != nil {
+					return err
+				}
+				err = txRepo.WebhookRepo().DeleteDeliveriesByUser(ctx, user.ID)
+				if err != nil {
+					return err
+				}
+				if user.Email != nil {
+					err = txRepo.EmailOutboxRepo().DeleteByRecipient(ctx, *user.Email)
+					if err != nil {
+						return err
+					}
+				}
+				err = txRepo.DataExportRepo().DeleteByUser(ctx, user.ID)
+				if err != nil {
+					return err
+				}
+				err = txRepo.WebhookRepo().Enqueue(ctx, user.ID, enum.WebhookEventTypeUserDeleted, map[string]interface{}{
+					"userId": user.ID,
+				})
+				if err != nil {
+					return err
+				}
+
+				// Last, so that the transaction only commits once the avatar
+				// is gone. Deleting a missing object succeeds, a retry is safe.
+				return deleteAvatar(ctx, blobStore, user)
+			})
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			err = app.Repo().UserStatusRepo().Invalidate(ctx, user.ID)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if user.Email != nil {
+				locale := comm.DefaultLocale
+				if user.Locale != nil {
+					locale = comm.MatchLocale(*user.Locale)
+				}
+				err = app.Emailer().SendAccountDeleted(ctx, locale, *user.Email)
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
+
+func deleteAvatar(ctx context.Context, blobStore blob.Store, user *model.User) error {
+	if user.Avatar == nil {
+		return nil
+	}
+	key, ok := strings.CutPrefix(*user.Avatar, blobStore.URL(""))
+	if !ok {
+		return nil
+	}
+	for _, variantKey := range avatar.VariantKeys(user.ID, key) {
+		err := blobStore.Delete(ctx, variantKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/blob"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/repo"
+	"github.com/nkbhasker/go-auth-starter/internal/storage"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+// newTestApp returns an app on the database of TEST_POSTGRES_URL, whose
+// public schema is recreated from the migrations. The test is skipped when
+// it isn't set.
+func newTestApp(t *testing.T) core.App {
+	t.Helper()
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+	dbStore, err := storage.InitDBStore(storage.DBOptions{Url: url, LogLevel: "silent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		dbStore.CloseDB()
+	})
+	migrate(t, dbStore)
+	idGenerator := uid.NewIdGenerator()
+	cacheStore := storage.NewMemoryCacheStore()
+	repos := repo.NewRepo(repo.RepoOptions{
+		DBStore:                   dbStore,
+		CacheStore:                cacheStore,
+		IdGenerator:               idGenerator,
+		OtpMaxAttempts:            5,
+		UserStatusExpiryInSeconds: 30,
+	})
+	emailer, err := comm.NewEmailer(comm.NewOutbox(repos.EmailOutboxRepo()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return core.NewApp(core.AppOption{
+		DBStore:     dbStore,
+		CacheStore:  cacheStore,
+		Repos:       repos,
+		IdGenerator: idGenerator,
+		Emailer:     emailer,
+		Validate:    core.NewValidate(),
+	})
+}
+
+// migrate recreates the public schema and applies the migrations in order,
+// as atlas does.
+func migrate(t *testing.T, dbStore storage.DBStore) {
+	t.Helper()
+	db := dbStore.Pools()["primary"]
+	_, err := db.Exec(`DROP SCHEMA IF EXISTS "public" CASCADE; CREATE SCHEMA "public"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(string(migration))
+		if err != nil {
+			t.Fatalf("migration %s error = %v", filepath.Base(file), err)
+		}
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApp(t)
+	blobStore, err := blob.NewLocal(t.TempDir(), "http://localhost/blob/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := "jane@example.com"
+	deleteAfter := time.Now().Add(-time.Minute)
+	user, err := app.Repo().UserRepo().New(model.User{
+		FirstName:   "Jane",
+		Email:       &email,
+		Status:      enum.UserStatusPendingDeletion,
+		DeleteAfter: &deleteAfter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.Repo().UserRepo().Create(ctx, user)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	ip := "203.0.113.7"
+	userAgent := "curl/8.0"
+	events := []model.AuditEvent{
+		{UserID: user.ID, Email: &email, Type: enum.AuditEventTypeSignIn, Outcome: enum.AuditOutcomeSuccess, IP: &ip, UserAgent: &userAgent},
+		// Failed attempts before sign in only carry the email
+		{Email: &email, Type: enum.AuditEventTypeOtpFailed, Outcome: enum.AuditOutcomeFailure, IP: &ip, UserAgent: &userAgent},
+	}
+	for _, event := range events {
+		err = app.Repo().AuditEventRepo().Record(ctx, event)
+		if err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	err = AccountDeletion(app, blobStore)(ctx)
+	if err != nil {
+		t.Fatalf("AccountDeletion() error = %v", err)
+	}
+
+	_, err = app.Repo().UserRepo().Get(ctx, user.ID)
+	if !errors.Is(err, repo.ErrUserNotFound) {
+		t.Errorf("Get() of the deleted user error = %v, want %v", err, repo.ErrUserNotFound)
+	}
+	kept, err := app.Repo().AuditEventRepo().Search(ctx, repo.AuditEventFilter{})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	// The events recorded above and the revocation of the user's tokens
+	if len(kept) != len(events)+1 {
+		t.Fatalf("Search() = %d events, want %d", len(kept), len(events)+1)
+	}
+	for _, event := range kept {
+		if event.Email != nil || event.IP != nil || event.UserAgent != nil {
+			t.Errorf("event %s = %+v, want anonymized", event.Type, event)
+		}
+	}
+}
+
+func TestAuditEventsAppendOnly(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApp(t)
+	email := "jane@example.com"
+	ip := "203.0.113.7"
+	err := app.Repo().AuditEventRepo().Record(ctx, model.AuditEvent{
+		Email:   &email,
+		Type:    enum.AuditEventTypeOtpRequested,
+		Outcome: enum.AuditOutcomeSuccess,
+		IP:      &ip,
+	})
+	if err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		update  string
+		wantErr bool
+	}{
+		{"reason", `UPDATE "audit_events" SET "reason" = 'edited'`, true},
+		{"email", `UPDATE "audit_events" SET "email" = 'john@example.com'`, true},
+		{"partial anonymization", `UPDATE "audit_events" SET "email" = NULL`, true},
+		{"anonymization with another change", `UPDATE "audit_events" SET "email" = NULL, "ip" = NULL, "outcome" = 'FAILURE'`, true},
+		{"anonymization", `UPDATE "audit_events" SET "email" = NULL, "ip" = NULL, "user_agent" = NULL`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := app.DBStore().DB().WithContext(ctx).Exec(tt.update).Error
+			if (err != nil) != tt.wantErr {
+				t.Errorf("update error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/worker"
+)
+
+// AuditEventRetention deletes audit events older than the retention period.
+func AuditEventRetention(app core.App, retentionInDays int) worker.Task {
+	retention := time.Duration(retentionInDays * int(24*time.Hour))
+	return func(ctx context.Context) error {
+		_, err := app.Repo().AuditEventRepo().DeleteBefore(ctx, time.Now().Add(-retention))
+
+		return err
+	}
+}
@@ -0,0 +1,17 @@
+package job
+
+import (
+	"math"
+	"time"
+)
+
+// backoff doubles the delay after every failed attempt, starting at base and
+// capped at max.
+func backoff(base, max time.Duration, attempts int) time.Duration {
+	delay := time.Duration(float64(base) * math.Pow(2, float64(attempts-1)))
+	if delay <= 0 || delay > max {
+		return max
+	}
+
+	return delay
+}
@@ -0,0 +1,205 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/avatar"
+	"github.com/nkbhasker/go-auth-starter/internal/blob"
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/misc"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/repo"
+	"github.com/nkbhasker/go-auth-starter/internal/worker"
+)
+
+const (
+	dataExportBatchSize   = 10
+	dataExportLease       = 10 * time.Minute
+	dataExportBaseBackoff = 30 * time.Second
+	dataExportMaxBackoff  = 30 * time.Minute
+	dataExportMaxAttempts = 5
+)
+
+type userExport struct {
+	ExportedAt        time.Time                `json:"exportedAt"`
+	User              exportedUser             `json:"user"`
+	Sessions          []string                 `json:"sessions"`
+	SecurityEvents    []*model.AuditEvent      `json:"securityEvents"`
+	Emails            []exportedEmail          `json:"emails"`
+	WebhookDeliveries []*model.WebhookDelivery `json:"webhookDeliveries"`
+	Avatars           []exportedAvatar         `json:"avatars"`
+}
+
+// exportedUser adds the fields kept from the user in api responses.
+type exportedUser struct {
+	*model.User
+	StatusReason    *string        `json:"statusReason"`
+	PrivateMetadata model.Metadata `json:"privateMetadata"`
+}
+
+// exportedEmail adds the content, when still kept, of an email.
+type exportedEmail struct {
+	*model.EmailMessage
+	Body string `json:"body,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+type exportedAvatar struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+}
+
+// DataExport builds the requested exports of everything stored about a
+// user, keeps each for expiresIn and emails the user a link to download it
+// from downloadUrl. Expired exports are deleted along the way.
+func DataExport(app core.App, blobStore blob.Store, downloadUrl string, expiresIn time.Duration) worker.Task {
+	return func(ctx context.Context) error {
+		exportRepo := app.Repo().DataExportRepo()
+		_, err := exportRepo.DeleteExpired(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		exports, err := exportRepo.Claim(ctx, dataExportBatchSize, dataExportLease)
+		if err != nil {
+			return err
+		}
+		errs := []error{}
+		for _, export := range exports {
+			err := buildDataExport(ctx, app, blobStore, export, downloadUrl, expiresIn)
+			if err == nil {
+				continue
+			}
+			errs = append(errs, err)
+			now := time.Now()
+			lastError := err.Error()
+			export.Attempts++
+			export.LastError = &lastError
+			if export.Attempts >= dataExportMaxAttempts {
+				// Failed exports are cleaned up like ready ones
+				expiresAt := now.Add(expiresIn)
+				export.Status = enum.DataExportStatusFailed
+				export.ExpiresAt = &expiresAt
+			} else {
+				export.NextAttemptAt = now.Add(backoff(dataExportBaseBackoff, dataExportMaxBackoff, export.Attempts))
+			}
+			err = exportRepo.Update(ctx, export)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
+
+func buildDataExport(
+	ctx context.Context,
+	app core.App,
+	blobStore blob.Store,
+	export *model.DataExport,
+	downloadUrl string,
+	expiresIn time.Duration,
+) error {
+	user, err := app.Repo().UserRepo().WithPrimary().Get(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+	archive, err := collectUserData(ctx, app, blobStore, user)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(expiresIn)
+
+	// The export is only ready once its email is queued, so that a failure
+	// to queue it is retried with the export. A copy is completed, as the
+	// export is saved for a retry when the transaction rolls back.
+	completed := *export
+
+	return app.Repo().InTx(ctx, func(txRepo repo.Repo) error {
+		token, err := txRepo.DataExportRepo().Complete(ctx, &completed, data, expiresAt)
+		if err != nil {
+			return err
+		}
+		if user.Email == nil {
+			return nil
+		}
+		locale := comm.DefaultLocale
+		if user.Locale != nil {
+			locale = comm.MatchLocale(*user.Locale)
+		}
+		emailer := app.Emailer().WithClient(comm.NewOutbox(txRepo.EmailOutboxRepo()))
+
+		return emailer.SendDataExport(ctx, locale, *user.Email, archive.ExportedAt, misc.TokenUrl(downloadUrl, token), expiresAt)
+	})
+}
+
+func collectUserData(ctx context.Context, app core.App, blobStore blob.Store, user *model.User) (*userExport, error) {
+	sessions, err := app.Repo().AccessTokenRepo().List(ctx, user.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	securityEvents := []*model.AuditEvent{}
+	filter := repo.AuditEventFilter{UserID: user.ID}
+	for {
+		events, err := app.Repo().AuditEventRepo().Search(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			break
+		}
+		securityEvents = append(securityEvents, events...)
+		filter.Before = events[len(events)-1].ID
+	}
+	emails := []exportedEmail{}
+	if user.Email != nil {
+		messages, err := app.Repo().EmailOutboxRepo().ListByRecipient(ctx, *user.Email)
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			email := exportedEmail{EmailMessage: message}
+			if !message.IsSealed {
+				email.Body = message.Body
+				email.Text = message.Text
+			}
+			emails = append(emails, email)
+		}
+	}
+	deliveries, err := app.Repo().WebhookRepo().ListDeliveriesByUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	avatars := []exportedAvatar{}
+	if user.Avatar != nil {
+		if key, ok := strings.CutPrefix(*user.Avatar, blobStore.URL("")); ok {
+			for _, variantKey := range avatar.VariantKeys(user.ID, key) {
+				avatars = append(avatars, exportedAvatar{Key: variantKey, URL: blobStore.URL(variantKey)})
+			}
+		}
+	}
+
+	return &userExport{
+		ExportedAt: time.Now(),
+		User: exportedUser{
+			User:            user,
+			StatusReason:    user.StatusReason,
+			PrivateMetadata: user.PrivateMetadata,
+		},
+		Sessions:          sessions,
+		SecurityEvents:    securityEvents,
+		Emails:            emails,
+		WebhookDeliveries: deliveries,
+		Avatars:           avatars,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/comm"
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
+	"github.com/nkbhasker/go-auth-starter/internal/misc"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/worker"
+)
+
+const (
+	emailDeliveryBatchSize = 100
+	emailDeliveryLease     = 5 * time.Minute
+	emailBaseBackoff       = 10 * time.Second
+	emailMaxBackoff        = 30 * time.Minute
+)
+
+// EmailDelivery sends queued emails from the outbox through client using
+// concurrency workers. Failed messages are retried with exponential backoff
+// and dead lettered once maxAttempts is reached, their content sealed with
+// sealer until they are resent.
+func EmailDelivery(app core.App, client comm.EmailClient, sealer misc.Sealer, concurrency int, maxAttempts int) worker.Task {
+	return func(ctx context.Context) error {
+		outboxRepo := app.Repo().EmailOutboxRepo()
+		messages, err := outboxRepo.Claim(ctx, emailDeliveryBatchSize, emailDeliveryLease)
+		if err != nil {
+			return err
+		}
+		ch := make(chan *model.EmailMessage)
+		errch := make(chan error, len(messages))
+		wg := sync.WaitGroup{}
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for message := range ch {
+					errch <- deliverEmail(ctx, app, client, sealer, message, maxAttempts)
+				}
+			}()
+		}
+		for _, message := range messages {
+			ch <- message
+		}
+		close(ch)
+		wg.Wait()
+		close(errch)
+
+		errs := []error{}
+		for err := range errch {
+			errs = append(errs, err)
+		}
+
+		return errors.Join(errs...)
+	}
+}
+
+func deliverEmail(
+	ctx context.Context,
+	app core.App,
+	client comm.EmailClient,
+	sealer misc.Sealer,
+	message *model.EmailMessage,
+	maxAttempts int,
+) error {
+	now := time.Now()
+	// A resent dead letter is still sealed
+	if message.IsSealed {
+		err := openEmail(sealer, message)
+		if err != nil {
+			lastError := err.Error()
+			message.Status = enum.EmailStatusDead
+			message.LastError = &lastError
+			return app.Repo().EmailOutboxRepo().Update(ctx, message)
+		}
+	}
+	message.Attempts++
+	err := client.Send(ctx, message.Recipients, message.Subject, message.Body, message.Text)
+	switch {
+	case err == nil:
+		message.Status = enum.EmailStatusSent
+		message.SentAt = &now
+		message.LastError = nil
+		// Emails carry otps, there is no reason to keep them once sent
+		message.Body = ""
+		message.Text = ""
+	case message.Attempts >= maxAttempts:
+		metrics.EmailSendFailed()
+		lastError := err.Error()
+		message.Status = enum.EmailStatusDead
+		message.LastError = &lastError
+		// Dead letters may wait a long time to be resent, never in the clear
+		err = sealEmail(sealer, message)
+		if err != nil {
+			return err
+		}
+	default:
+		metrics.EmailSendFailed()
+		lastError := err.Error()
+		message.LastError = &lastError
+		message.NextAttemptAt = now.Add(backoff(emailBaseBackoff, emailMaxBackoff, message.Attempts))
+	}
+
+	return app.Repo().EmailOutboxRepo().Update(ctx, message)
+}
+
+func sealEmail(sealer misc.Sealer, message *model.EmailMessage) error {
+	body, err := sealer.Seal(message.Body)
+	if err != nil {
+		return err
+	}
+	text, err := sealer.Seal(message.Text)
+	if err != nil {
+		return err
+	}
+	message.Body = body
+	message.Text = text
+	message.IsSealed = true
+
+	return nil
+}
+
+func openEmail(sealer misc.Sealer, message *model.EmailMessage) error {
+	body, err := sealer.Open(message.Body)
+	if err != nil {
+		return err
+	}
+	text, err := sealer.Open(message.Text)
+	if err != nil {
+		return err
+	}
+	message.Body = body
+	message.Text = text
+	message.IsSealed = false
+
+	return nil
+}
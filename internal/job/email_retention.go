@@ -0,0 +1,20 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/worker"
+)
+
+// EmailRetention deletes the emails sent before the retention period, along
+// with the personal data in their recipients and body.
+func EmailRetention(app core.App, retentionInDays int) worker.Task {
+	retention := time.Duration(retentionInDays * int(24*time.Hour))
+	return func(ctx context.Context) error {
+		_, err := app.Repo().EmailOutboxRepo().DeleteSentBefore(ctx, time.Now().Add(-retention))
+
+		return err
+	}
+}
@@ -0,0 +1,82 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/webhook"
+	"github.com/nkbhasker/go-auth-starter/internal/worker"
+)
+
+const (
+	webhookDeliveryBatchSize = 50
+	webhookDeliveryLease     = 5 * time.Minute
+	webhookBaseBackoff       = 30 * time.Second
+	webhookMaxBackoff        = 6 * time.Hour
+)
+
+// WebhookDelivery sends due webhook deliveries from the outbox. Failed
+// deliveries are retried with exponential backoff and marked failed once
+// maxAttempts is reached.
+func WebhookDelivery(app core.App, sender webhook.Sender, maxAttempts int) worker.Task {
+	return func(ctx context.Context) error {
+		webhookRepo := app.Repo().WebhookRepo()
+		deliveries, err := webhookRepo.ClaimDeliveries(ctx, webhookDeliveryBatchSize, webhookDeliveryLease)
+		if err != nil {
+			return err
+		}
+		endpoints := map[int64]*model.WebhookEndpoint{}
+		all, err := webhookRepo.ListEndpoints(ctx)
+		if err != nil {
+			return err
+		}
+		for _, endpoint := range all {
+			endpoints[endpoint.ID.Uid()] = endpoint
+		}
+		errs := []error{}
+		for _, delivery := range deliveries {
+			now := time.Now()
+			delivery.Attempts++
+			endpoint, ok := endpoints[delivery.EndpointID.Uid()]
+			if !ok || !endpoint.IsActive {
+				err = errors.New("webhook endpoint removed or inactive")
+			} else {
+				var status int
+				status, err = sender.Send(ctx, webhook.Request{
+					URL:       endpoint.URL,
+					Secret:    endpoint.Secret,
+					EventID:   delivery.EventID,
+					EventType: string(delivery.EventType),
+					Payload:   []byte(delivery.Payload),
+				})
+				if status != 0 {
+					delivery.ResponseStatus = &status
+				}
+			}
+			switch {
+			case err == nil:
+				delivery.Status = enum.WebhookDeliveryStatusSucceeded
+				delivery.LastError = nil
+				delivery.DeliveredAt = &now
+			case !ok || delivery.Attempts >= maxAttempts:
+				lastError := err.Error()
+				delivery.Status = enum.WebhookDeliveryStatusFailed
+				delivery.LastError = &lastError
+			default:
+				lastError := err.Error()
+				delivery.LastError = &lastError
+				delivery.NextAttemptAt = now.Add(backoff(webhookBaseBackoff, webhookMaxBackoff, delivery.Attempts))
+			}
+			err = webhookRepo.UpdateDelivery(ctx, delivery)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+}
@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attributes whose value is never logged, matched without
+// case, underscores or dashes.
+var sensitiveKeys = map[string]bool{
+	"otp":           true,
+	"token":         true,
+	"accesstoken":   true,
+	"refreshtoken":  true,
+	"authorization": true,
+	"password":      true,
+	"secret":        true,
+	"apikey":        true,
+	"cookie":        true,
+}
+
+var (
+	emailPattern = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+	jwtPattern   = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+type contextKey struct{}
+
+type Options struct {
+	// debug, info, warn or error, info when empty
+	Level string
+}
+
+// New returns a logger writing json lines to w. Sensitive attributes are
+// redacted, and emails and tokens masked wherever they appear in messages
+// and string values.
+func New(w io.Writer, options Options) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if options.Level != "" {
+		err := level.UnmarshalText([]byte(options.Level))
+		if err != nil {
+			return nil, fmt.Errorf("unknown log level %q", options.Level)
+		}
+	}
+
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})), nil
+}
+
+// NewContext returns ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of ctx, the default logger when it carries
+// none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	key := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(attr.Key))
+	if sensitiveKeys[key] {
+		return slog.String(attr.Key, redacted)
+	}
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(value.String()))
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, Redact(v.String()))
+		case []string:
+			masked := make([]string, len(v))
+			for i, s := range v {
+				masked[i] = Redact(s)
+			}
+			return slog.Any(attr.Key, masked)
+		}
+	}
+
+	return attr
+}
+
+// Redact masks the emails and tokens in s, keeping the first character and
+// domain of emails, e.g. j***@example.com.
+func Redact(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redacted)
+
+	return emailPattern.ReplaceAllString(s, "$1***@$2")
+}
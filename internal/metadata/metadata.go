@@ -0,0 +1,133 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Options constrain the values of a metadata field.
+type Options struct {
+	MaxBytes int
+	// Path or url of a JSON Schema the value must satisfy, optional
+	SchemaPath string
+}
+
+type Validator interface {
+	Validate(field enum.MetadataFieldEnum, value model.Metadata) error
+}
+
+type validator struct {
+	maxBytes map[enum.MetadataFieldEnum]int
+	schemas  map[enum.MetadataFieldEnum]*jsonschema.Schema
+}
+
+func NewValidator(options map[enum.MetadataFieldEnum]Options) (Validator, error) {
+	v := &validator{
+		maxBytes: map[enum.MetadataFieldEnum]int{},
+		schemas:  map[enum.MetadataFieldEnum]*jsonschema.Schema{},
+	}
+	for field, o := range options {
+		v.maxBytes[field] = o.MaxBytes
+		if o.SchemaPath == "" {
+			continue
+		}
+		schema, err := jsonschema.Compile(o.SchemaPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s metadata schema: %w", field, err)
+		}
+		v.schemas[field] = schema
+	}
+
+	return v, nil
+}
+
+func (v *validator) Validate(field enum.MetadataFieldEnum, value model.Metadata) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if max := v.maxBytes[field]; max > 0 && len(b) > max {
+		return fmt.Errorf("exceeds %d bytes", max)
+	}
+	schema, ok := v.schemas[field]
+	if !ok {
+		return nil
+	}
+	// The schema validator only understands values decoded by encoding/json
+	var decoded interface{}
+	err = json.Unmarshal(b, &decoded)
+	if err != nil {
+		return err
+	}
+	err = schema.Validate(decoded)
+	if err, ok := err.(*jsonschema.ValidationError); ok {
+		return fmt.Errorf("%s", validationMessage(err))
+	}
+
+	return err
+}
+
+// Merge applies an RFC 7396 merge patch to target and returns the result,
+// leaving target untouched. A null patch clears the metadata.
+func Merge(target model.Metadata, patch json.RawMessage) (model.Metadata, error) {
+	var p interface{}
+	err := json.Unmarshal(patch, &p)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return model.Metadata{}, nil
+	}
+	if _, ok := p.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("must be an object")
+	}
+	merged := mergePatch(map[string]interface{}(target), p).(map[string]interface{})
+
+	return model.Metadata(merged), nil
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t := map[string]interface{}{}
+	if tm, ok := target.(map[string]interface{}); ok {
+		for k, v := range tm {
+			t[k] = v
+		}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+
+	return t
+}
+
+// validationMessage flattens a schema validation error to its innermost
+// causes, e.g. "/plan: value must be one of \"free\", \"pro\"".
+func validationMessage(err *jsonschema.ValidationError) string {
+	if len(err.Causes) == 0 {
+		location := err.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+
+		return location + ": " + err.Message
+	}
+	messages := make([]string, len(err.Causes))
+	for i, cause := range err.Causes {
+		messages[i] = validationMessage(cause)
+	}
+
+	return strings.Join(messages, "; ")
+}
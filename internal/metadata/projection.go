@@ -0,0 +1,72 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+)
+
+type claimKey struct {
+	field enum.MetadataFieldEnum
+	key   string
+}
+
+// Projection selects the metadata keys copied into access token claims.
+type Projection []claimKey
+
+// ParseProjection parses a comma separated list of <field>.<key>, e.g.
+// "public.plan,app.roles". Private metadata can't be projected as access
+// tokens are readable by their holder.
+func ParseProjection(spec string) (Projection, error) {
+	projection := Projection{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		field, key, ok := strings.Cut(item, ".")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid metadata claim %q, expected <field>.<key>", item)
+		}
+		switch enum.MetadataFieldEnum(field) {
+		case enum.MetadataFieldPublic, enum.MetadataFieldApp:
+		default:
+			return nil, fmt.Errorf("invalid metadata claim %q, only public and app metadata can be projected", item)
+		}
+		projection = append(projection, claimKey{field: enum.MetadataFieldEnum(field), key: key})
+	}
+
+	return projection, nil
+}
+
+// Claims returns the projected keys present on the user, grouped by field,
+// e.g. {"public": {"plan": "pro"}}. It returns nil when there are none.
+func (p Projection) Claims(user *model.User) map[string]interface{} {
+	var claims map[string]interface{}
+	for _, ck := range p {
+		var source model.Metadata
+		switch ck.field {
+		case enum.MetadataFieldPublic:
+			source = user.PublicMetadata
+		case enum.MetadataFieldApp:
+			source = user.AppMetadata
+		}
+		value, ok := source[ck.key]
+		if !ok {
+			continue
+		}
+		if claims == nil {
+			claims = map[string]interface{}{}
+		}
+		group, ok := claims[string(ck.field)].(map[string]interface{})
+		if !ok {
+			group = map[string]interface{}{}
+			claims[string(ck.field)] = group
+		}
+		group[ck.key] = value
+	}
+
+	return claims
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+type OutcomeEnum string
+
+const (
+	OutcomeSuccess          OutcomeEnum = "success"
+	OutcomeFailure          OutcomeEnum = "failure"
+	OutcomeInvalid          OutcomeEnum = "invalid"
+	OutcomeExpired          OutcomeEnum = "expired"
+	OutcomeAttemptsExceeded OutcomeEnum = "attempts_exceeded"
+)
+
+const namespace = "auth"
+
+// The registry only holds the collectors of this package and the go runtime,
+// not whatever dependencies register globally.
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http requests by route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	otpsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "otps_sent_total",
+		Help:      "OTPs sent by scope and outcome.",
+	}, []string{"scope", "outcome"})
+	otpsVerified = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "otps_verified_total",
+		Help:      "OTP verifications by scope and outcome.",
+	}, []string{"scope", "outcome"})
+	rateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by rate limiters by kind.",
+	}, []string{"kind"})
+	tokensIssued = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_issued_total",
+		Help:      "Access tokens issued.",
+	})
+	tokensRevoked = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_revoked_total",
+		Help:      "Access tokens revoked.",
+	})
+	emailSendFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "email_send_failures_total",
+		Help:      "Failed attempts to send an email.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestDuration,
+		otpsSent,
+		otpsVerified,
+		rateLimitRejections,
+		tokensIssued,
+		tokensRevoked,
+		emailSendFailures,
+	)
+}
+
+// Handler serves the metrics in the prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func ObserveRequest(method string, route string, status int, duration time.Duration) {
+	httpRequestDuration.WithLabelValues(method, route, strconv.Itoa(status)).Observe(duration.Seconds())
+}
+
+// OutcomeOf returns the outcome of an operation failing with err.
+func OutcomeOf(err error) OutcomeEnum {
+	if err != nil {
+		return OutcomeFailure
+	}
+
+	return OutcomeSuccess
+}
+
+func OtpSent(scope string, outcome OutcomeEnum) {
+	otpsSent.WithLabelValues(scope, string(outcome)).Inc()
+}
+
+func OtpVerified(scope string, outcome OutcomeEnum) {
+	otpsVerified.WithLabelValues(scope, string(outcome)).Inc()
+}
+
+func RateLimitRejected(kind string) {
+	rateLimitRejections.WithLabelValues(kind).Inc()
+}
+
+func TokensIssued(n int) {
+	tokensIssued.Add(float64(n))
+}
+
+func TokensRevoked(n int) {
+	tokensRevoked.Add(float64(n))
+}
+
+func EmailSendFailed() {
+	emailSendFailures.Inc()
+}
+
+// RegisterDBStats exposes the stats of the connection pool of db, labelled
+// with name.
+func RegisterDBStats(db *sql.DB, name string) error {
+	return registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterRedisStats exposes the stats of the connection pool of client.
+func RegisterRedisStats(client redis.UniversalClient) error {
+	return registry.Register(&redisCollector{client: client})
+}
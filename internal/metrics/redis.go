@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	redisHitsDesc = prometheus.NewDesc(
+		namespace+"_redis_pool_hits_total",
+		"Times a free connection was found in the pool.",
+		nil, nil,
+	)
+	redisMissesDesc = prometheus.NewDesc(
+		namespace+"_redis_pool_misses_total",
+		"Times a free connection was not found in the pool.",
+		nil, nil,
+	)
+	redisTimeoutsDesc = prometheus.NewDesc(
+		namespace+"_redis_pool_timeouts_total",
+		"Times waiting for a connection timed out.",
+		nil, nil,
+	)
+	redisTotalConnsDesc = prometheus.NewDesc(
+		namespace+"_redis_pool_total_connections",
+		"Connections in the pool.",
+		nil, nil,
+	)
+	redisIdleConnsDesc = prometheus.NewDesc(
+		namespace+"_redis_pool_idle_connections",
+		"Idle connections in the pool.",
+		nil, nil,
+	)
+	redisStaleConnsDesc = prometheus.NewDesc(
+		namespace+"_redis_pool_stale_connections_total",
+		"Stale connections removed from the pool.",
+		nil, nil,
+	)
+)
+
+// redisCollector reads the pool stats of a redis client on every scrape.
+// With sentinel or cluster they add up the pools of every node.
+type redisCollector struct {
+	client redis.UniversalClient
+}
+
+func (c *redisCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- redisHitsDesc
+	ch <- redisMissesDesc
+	ch <- redisTimeoutsDesc
+	ch <- redisTotalConnsDesc
+	ch <- redisIdleConnsDesc
+	ch <- redisStaleConnsDesc
+}
+
+func (c *redisCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.client.PoolStats()
+	ch <- prometheus.MustNewConstMetric(redisHitsDesc, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(redisMissesDesc, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(redisTimeoutsDesc, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(redisTotalConnsDesc, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(redisIdleConnsDesc, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(redisStaleConnsDesc, prometheus.CounterValue, float64(stats.StaleConns))
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	chimiddleware "github.com/go-chi/chi/middleware"
+	"github.com/nkbhasker/go-auth-starter/internal/logger"
+)
+
+type accessLogContextKey struct{}
+
+// accessLog collects what handlers further down learn about the request,
+// which the access log interceptor can't see in their contexts.
+type accessLog struct {
+	userID string
+}
+
+type accessLogInterceptor struct{}
+
+func NewAccessLogInterceptor() *accessLogInterceptor {
+	return &accessLogInterceptor{}
+}
+
+// HandlerFunc logs every request once it is served, with its route pattern
+// rather than path so that ids in paths don't end up in logs. It must run
+// after the request id interceptor.
+func (i *accessLogInterceptor) HandlerFunc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startAt := time.Now()
+		entry := &accessLog{}
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), accessLogContextKey{}, entry)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", routePattern(r)),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Float64("latency_ms", float64(time.Since(startAt).Microseconds())/1000),
+		}
+		if entry.userID != "" {
+			attrs = append(attrs, slog.String("user_id", entry.userID))
+		}
+		logger.FromContext(r.Context()).LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// setAccessLogUserID records the signed in user of the request for its
+// access log.
+func setAccessLogUserID(ctx context.Context, userID string) {
+	if entry, ok := ctx.Value(accessLogContextKey{}).(*accessLog); ok {
+		entry.userID = userID
+	}
+}
+
+// routePattern returns the pattern of the route that served r, unmatched for
+// requests that matched none.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.RoutePattern() == "" {
+		return "unmatched"
+	}
+
+	return rctx.RoutePattern()
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+)
+
+type adminInterceptor struct {
+	apiKey string
+}
+
+func NewAdminInterceptor(apiKey string) *adminInterceptor {
+	return &adminInterceptor{
+		apiKey: apiKey,
+	}
+}
+
+// HandlerFunc only lets through requests that carry the admin api key as a
+// bearer token. Admin routes are disabled when no api key is configured.
+func (a *adminInterceptor) HandlerFunc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := func() error {
+			// No api key can be right
+			if a.apiKey == "" {
+				return httperrors.ErrForbidden
+			}
+			apiKey, err := extractTokenFromHeader(r.Header)
+			if err != nil {
+				return err
+			}
+			if subtle.ConstantTimeCompare([]byte(apiKey), []byte(a.apiKey)) != 1 {
+				return httperrors.NewHttpError(http.StatusUnauthorized, "invalid_api_key", "invalid admin api key")
+			}
+
+			return nil
+		}()
+		if err != nil {
+			httperrors.Render(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -5,22 +5,26 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/go-chi/render"
 	"github.com/nkbhasker/go-auth-starter/internal/core"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/logger"
 	"github.com/nkbhasker/go-auth-starter/internal/misc"
+	"github.com/nkbhasker/go-auth-starter/internal/repo"
 )
 
 type authInterceptor struct {
-	jwtHelper misc.JwtHelper
+	jwtHelper      misc.JwtHelper
+	userStatusRepo repo.UserStatusRepo
 }
 
 const (
 	Bearer string = "bearer"
 )
 
-func NewAuthInterceptor(jwtHelper misc.JwtHelper) *authInterceptor {
+func NewAuthInterceptor(jwtHelper misc.JwtHelper, userStatusRepo repo.UserStatusRepo) *authInterceptor {
 	return &authInterceptor{
-		jwtHelper: jwtHelper,
+		jwtHelper:      jwtHelper,
+		userStatusRepo: userStatusRepo,
 	}
 }
 
@@ -36,17 +40,33 @@ func (a *authInterceptor) HandlerFunc(next http.Handler) http.Handler {
 			if err != nil {
 				return nil, err
 			}
-			return core.NewIdentity(claims.ID, claims.Subject)
+			identity, err := core.NewIdentity(claims.ID, claims.Subject)
+			if err != nil {
+				return nil, err
+			}
+			err = a.userStatusRepo.Verify(ctx, identity.UserID())
+			if err != nil {
+				return nil, err
+			}
+
+			return identity, nil
 		}()
-		if err != nil {
-			render.Status(r, http.StatusUnauthorized)
-			render.JSON(w, r, map[string]interface{}{
-				"success": false,
-				"error":   err.Error(),
-			})
+		switch {
+		case errors.Is(err, repo.ErrUserSuspended):
+			httperrors.Render(w, r, httperrors.ErrUserSuspended)
+			return
+		case errors.Is(err, repo.ErrUnavailable):
+			httperrors.Render(w, r, httperrors.ErrUnavailable.WithCause(err))
+			return
+		case err != nil:
+			httperrors.Render(w, r, httperrors.ErrUnauthorized.WithCause(err))
 			return
 		}
 
+		userID := identity.UserID().String()
+		setAccessLogUserID(ctx, userID)
+		ctx = logger.NewContext(ctx, logger.FromContext(ctx).With("user_id", userID))
+
 		next.ServeHTTP(w, r.WithContext(core.IdentityToContext(ctx, identity)))
 	})
 }
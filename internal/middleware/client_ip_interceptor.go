@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/nkbhasker/go-auth-starter/internal/misc"
+)
+
+type clientIPInterceptor struct {
+	trustedProxies []netip.Prefix
+}
+
+func NewClientIPInterceptor(trustedProxies []netip.Prefix) *clientIPInterceptor {
+	return &clientIPInterceptor{
+		trustedProxies: trustedProxies,
+	}
+}
+
+// HandlerFunc resolves the client ip of every request once, trusting the
+// forwarded headers of trustedProxies only, for misc.GetIP to return.
+func (i *clientIPInterceptor) HandlerFunc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := misc.NewIPContext(r.Context(), misc.ClientIP(r, i.trustedProxies))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	chimiddleware "github.com/go-chi/chi/middleware"
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
+)
+
+type metricsInterceptor struct{}
+
+func NewMetricsInterceptor() *metricsInterceptor {
+	return &metricsInterceptor{}
+}
+
+// HandlerFunc observes the latency of every request by its route pattern,
+// which keeps the number of series bounded whatever the paths requested.
+func (i *metricsInterceptor) HandlerFunc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startAt := time.Now()
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.ObserveRequest(r.Method, routePattern(r), status, time.Since(startAt))
+	})
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/core"
+	httperrors "github.com/nkbhasker/go-auth-starter/internal/errors"
+	"github.com/nkbhasker/go-auth-starter/internal/misc"
+)
+
+// Bodies are buffered to read the rate limit key, larger ones aren't limited
+// by body fields
+const rateLimitMaxBodyBytes = 1 << 20
+
+// RateLimitKeyFunc returns the identifier a request is rate limited by. An
+// empty identifier lets the request through unlimited, e.g. when the body
+// has no email, leaving it to the handler to reject the request.
+type RateLimitKeyFunc func(r *http.Request) string
+
+type rateLimitInterceptor struct {
+	limiter core.RateLimiter
+	key     RateLimitKeyFunc
+}
+
+func NewRateLimitInterceptor(limiter core.RateLimiter, key RateLimitKeyFunc) *rateLimitInterceptor {
+	return &rateLimitInterceptor{
+		limiter: limiter,
+		key:     key,
+	}
+}
+
+// HandlerFunc rejects requests over the limit with 429 and Retry-After, and
+// reports the state of the limit in the RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers.
+func (i *rateLimitInterceptor) HandlerFunc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identifier := i.key(r)
+		if identifier == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		result, err := i.limiter.Evaluate(r.Context(), identifier)
+		if err != nil {
+			httperrors.Render(w, r, httperrors.ErrUnavailable.WithCause(err))
+			return
+		}
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("RateLimit-Reset", seconds(result.Reset))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", seconds(result.RetryAfter))
+			httperrors.Render(w, r, httperrors.ErrRateLimited)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitByIP limits requests by client ip.
+func RateLimitByIP(r *http.Request) string {
+	return misc.GetIP(r)
+}
+
+// RateLimitByUserID limits requests by the signed in user, it must run after
+// the auth interceptor.
+func RateLimitByUserID(r *http.Request) string {
+	userId := core.IdentityFromContext(r.Context()).UserID()
+	if userId == nil {
+		return ""
+	}
+
+	return userId.String()
+}
+
+// RateLimitByClientID limits requests by the X-Client-ID header.
+func RateLimitByClientID(r *http.Request) string {
+	return r.Header.Get("X-Client-ID")
+}
+
+// RateLimitByBodyField limits requests by a string field of their json body,
+// e.g. the email an otp is verified for. The body is left for the handler
+// to read.
+func RateLimitByBodyField(field string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		body, err := io.ReadAll(io.LimitReader(r.Body, rateLimitMaxBodyBytes))
+		// Hand the handler the whole body, even past what was read
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if err != nil {
+			return ""
+		}
+		fields := map[string]json.RawMessage{}
+		if json.Unmarshal(body, &fields) != nil {
+			return ""
+		}
+		var value string
+		if json.Unmarshal(fields[field], &value) != nil {
+			return ""
+		}
+
+		return value
+	}
+}
+
+// seconds formats d as whole seconds, rounding up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
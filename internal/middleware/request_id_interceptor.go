@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/nkbhasker/go-auth-starter/internal/logger"
+)
+
+const HeaderRequestID = "X-Request-ID"
+
+// Request ids sent by clients or proxies are kept when they are reasonable
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestIDInterceptor struct {
+	logger *slog.Logger
+}
+
+func NewRequestIDInterceptor(logger *slog.Logger) *requestIDInterceptor {
+	return &requestIDInterceptor{
+		logger: logger,
+	}
+}
+
+// HandlerFunc gives every request an id, the X-Request-ID header when valid,
+// echoes it in the response and puts a logger with it in the context.
+func (i *requestIDInterceptor) HandlerFunc(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(HeaderRequestID)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(HeaderRequestID, requestID)
+		ctx := logger.NewContext(r.Context(), i.logger.With("request_id", requestID))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand never fails on supported platforms
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -1,12 +1,81 @@
 package misc
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"fmt"
 )
 
+var ErrInvalidSealedValue = fmt.Errorf("invalid sealed value")
+
+// Sealer encrypts values stored at rest with AES-GCM.
+type Sealer interface {
+	Seal(plaintext string) (string, error)
+	Open(sealed string) (string, error)
+}
+
+type sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer returns a Sealer using key, which must be 32 bytes long.
+func NewSealer(key []byte) (Sealer, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("sealer key must be 32 bytes long")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealer{aead: aead}, nil
+}
+
+// DeriveKey derives a 32 bytes key for purpose from secret, so that one
+// secret can back keys for unrelated uses.
+func DeriveKey(secret string, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+
+	return mac.Sum(nil)
+}
+
+// Seal returns the base64 encoded nonce followed by the ciphertext.
+func (s *sealer) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *sealer) Open(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return "", ErrInvalidSealedValue
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", ErrInvalidSealedValue
+	}
+
+	return string(plaintext), nil
+}
+
 func Base64ToPrivateKey(str string) (*rsa.PrivateKey, error) {
 	keyBytes, err := base64.StdEncoding.DecodeString(str)
 	if err != nil {
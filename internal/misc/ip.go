@@ -0,0 +1,102 @@
+package misc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/muhlemmer/httpforwarded"
+)
+
+type ipContextKey struct{}
+
+// NewIPContext returns ctx carrying the client ip of its request.
+func NewIPContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ipContextKey{}, ip)
+}
+
+// GetIP returns the client ip of r, as resolved by ClientIP when the request
+// went through the client ip interceptor, otherwise its peer address.
+func GetIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ipContextKey{}).(string); ok {
+		return ip
+	}
+
+	return remoteHost(r.RemoteAddr)
+}
+
+// ClientIP returns the ip of the client of r. The Forwarded header, or
+// X-Forwarded-For without it, is only read on requests from trustedProxies,
+// walking its addresses from the nearest hop back to the first one that
+// isn't a trusted proxy, as any hop before it may be forged by the client.
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	ip, ok := parseIP(r.RemoteAddr)
+	if !ok {
+		return remoteHost(r.RemoteAddr)
+	}
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(ip, trustedProxies); i-- {
+		hop, ok := parseIP(hops[i])
+		if !ok {
+			// Obfuscated or unknown hops can't be followed further
+			break
+		}
+		ip = hop
+	}
+
+	return ip.String()
+}
+
+// forwardedFor returns the addresses of the hops a request was forwarded by,
+// from the client to the nearest proxy.
+func forwardedFor(r *http.Request) []string {
+	if values := r.Header.Values("Forwarded"); len(values) != 0 {
+		hops, err := httpforwarded.ParseParameter("for", values)
+		if err == nil {
+			return hops
+		}
+
+		return nil
+	}
+	hops := []string{}
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	return hops
+}
+
+func isTrustedProxy(ip netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseIP parses an address with or without a port, ipv6 ones possibly in
+// brackets.
+func parseIP(addr string) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(strings.Trim(remoteHost(addr), "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return ip.Unmap(), true
+}
+
+// remoteHost strips the port of addr when it has one.
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
@@ -0,0 +1,64 @@
+package misc
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trustedProxies := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwarded     string
+		xForwardedFor string
+		want          string
+	}{
+		{"peer", "203.0.113.7:51234", "", "", "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:51234", "", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:443", "", "198.51.100.1", "198.51.100.1"},
+		{"forged hop", "10.0.0.1:443", "", "192.0.2.66, 198.51.100.1", "198.51.100.1"},
+		{"proxy chain", "10.0.0.1:443", "", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"only proxies", "10.0.0.1:443", "", "10.0.0.2", "10.0.0.2"},
+		{"no forwarded header", "10.0.0.1:443", "", "", "10.0.0.1"},
+		{"forwarded", "10.0.0.1:443", `for=198.51.100.1;proto=https`, "", "198.51.100.1"},
+		{"forwarded over x-forwarded-for", "10.0.0.1:443", `for="198.51.100.1:4711"`, "192.0.2.66", "198.51.100.1"},
+		{"forwarded ipv6", "10.0.0.1:443", `for="[2001:db8::1]:4711"`, "", "2001:db8::1"},
+		{"unknown hop", "10.0.0.1:443", `for=unknown`, "", "10.0.0.1"},
+		{"ipv6 peer", "[2001:db8::2]:443", "", "198.51.100.1", "2001:db8::2"},
+		{"ipv6 trusted proxy", "[2001:db8:ffff::1]:443", "", "198.51.100.1", "198.51.100.1"},
+		{"ipv4 mapped trusted proxy", "[::ffff:10.0.0.1]:443", "", "198.51.100.1", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("Forwarded", tt.forwarded)
+			}
+			if tt.xForwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.xForwardedFor)
+			}
+			if got := ClientIP(r, trustedProxies); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:51234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := GetIP(r); got != "203.0.113.7" {
+		t.Errorf("GetIP() = %q, want the peer address without port", got)
+	}
+	r = r.WithContext(NewIPContext(r.Context(), "198.51.100.1"))
+	if got := GetIP(r); got != "198.51.100.1" {
+		t.Errorf("GetIP() = %q, want the resolved client ip", got)
+	}
+}
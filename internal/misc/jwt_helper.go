@@ -12,7 +12,7 @@ import (
 )
 
 type JwtHelper interface {
-	NewAccessToken(sub string, name string, expiresIn time.Duration) (string, string, error)
+	NewAccessToken(sub string, name string, metadata map[string]interface{}, expiresIn time.Duration) (string, string, error)
 	VerifyAccessToken(accessToken string) (*claims, error)
 }
 
@@ -25,6 +25,8 @@ type jwtHelper struct {
 type claims struct {
 	jwt.RegisteredClaims
 	Name string `json:"name"`
+	// User metadata projected into the token
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
 
 func NewJwtHelper(issuer string, base64Str string) (JwtHelper, error) {
@@ -50,7 +52,12 @@ func NewJwtHelper(issuer string, base64Str string) (JwtHelper, error) {
 	}, nil
 }
 
-func (j *jwtHelper) NewAccessToken(sub string, name string, expiresIn time.Duration) (string, string, error) {
+func (j *jwtHelper) NewAccessToken(
+	sub string,
+	name string,
+	metadata map[string]interface{},
+	expiresIn time.Duration,
+) (string, string, error) {
 	registeredClaims := jwt.RegisteredClaims{
 		ID:       uuid.NewString(),
 		Issuer:   j.issuer,
@@ -63,6 +70,7 @@ func (j *jwtHelper) NewAccessToken(sub string, name string, expiresIn time.Durat
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, &claims{
 		Name:             name,
+		Metadata:         metadata,
 		RegisteredClaims: registeredClaims,
 	})
 	token.Header["kid"] = j.kid
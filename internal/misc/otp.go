@@ -1,32 +1,113 @@
 package misc
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"time"
 )
 
+type OtpAlphabetEnum string
+
 const (
-	length = 6
-	digits = "0123456789"
+	OtpAlphabetNumeric      OtpAlphabetEnum = "numeric"
+	OtpAlphabetAlphanumeric OtpAlphabetEnum = "alphanumeric"
+	// Alphanumeric without characters that are easily mistaken for one
+	// another: 0, O, 1, I and L
+	OtpAlphabetUnambiguous OtpAlphabetEnum = "unambiguous"
 )
 
-func GenerateOtp() (string, error) {
-	max := len(digits) - 1
-	str := make([]byte, length)
-	randBytes := make([]byte, length)
-	if _, err := rand.Read(randBytes); err != nil {
-		return "", err
+var otpAlphabets = map[OtpAlphabetEnum]string{
+	OtpAlphabetNumeric:      "0123456789",
+	OtpAlphabetAlphanumeric: "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	OtpAlphabetUnambiguous:  "23456789ABCDEFGHJKMNPQRSTUVWXYZ",
+}
+
+const (
+	minOtpLength = 4
+	maxOtpLength = 32
+	saltLength   = 16
+)
+
+// OtpPolicy describes the otps issued for a scope.
+type OtpPolicy struct {
+	Length    int
+	Alphabet  OtpAlphabetEnum
+	ExpiresIn time.Duration
+}
+
+func NewOtpPolicy(length int, alphabet OtpAlphabetEnum, expiryInMinutes int) (OtpPolicy, error) {
+	if length < minOtpLength || length > maxOtpLength {
+		return OtpPolicy{}, fmt.Errorf("otp length must be between %d and %d", minOtpLength, maxOtpLength)
+	}
+	if _, ok := otpAlphabets[alphabet]; !ok {
+		return OtpPolicy{}, fmt.Errorf("invalid otp alphabet %q", alphabet)
+	}
+	if expiryInMinutes < 1 {
+		return OtpPolicy{}, fmt.Errorf("otp expiry must be at least a minute")
 	}
-	for i, rb := range randBytes {
-		str[i] = digits[int(rb)%max]
+
+	return OtpPolicy{
+		Length:    length,
+		Alphabet:  alphabet,
+		ExpiresIn: time.Duration(expiryInMinutes * int(time.Minute)),
+	}, nil
+}
+
+// Generate returns a random otp following the policy. Random bytes that would
+// bias the result towards the start of the alphabet are rejected, so that
+// every character is equally likely.
+func (p OtpPolicy) Generate() (string, error) {
+	alphabet := otpAlphabets[p.Alphabet]
+	// Largest multiple of the alphabet size that fits in a byte, bytes at or
+	// above it are discarded
+	limit := 256 - 256%len(alphabet)
+	str := make([]byte, 0, p.Length)
+	randBytes := make([]byte, p.Length*2)
+	for len(str) < p.Length {
+		if _, err := rand.Read(randBytes); err != nil {
+			return "", err
+		}
+		for _, rb := range randBytes {
+			if int(rb) >= limit {
+				continue
+			}
+			str = append(str, alphabet[int(rb)%len(alphabet)])
+			if len(str) == p.Length {
+				break
+			}
+		}
 	}
 
 	return string(str), nil
 }
 
+// NewOtpSalt returns a random hex encoded salt to hash an otp with.
+func NewOtpSalt() (string, error) {
+	b := make([]byte, saltLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// HashOtp returns the hex encoded HMAC-SHA256 of otp keyed with salt.
+func HashOtp(salt, otp string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(otp))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateOtp compares two otp hashes in constant time.
 func ValidateOtp(want, got string) bool {
 	if want == "" || got == "" {
 		return false
 	}
 
-	return want == got
+	return subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
 }
@@ -0,0 +1,146 @@
+package misc
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+var testAlphabets = []OtpAlphabetEnum{
+	OtpAlphabetNumeric,
+	OtpAlphabetAlphanumeric,
+	OtpAlphabetUnambiguous,
+}
+
+func TestNewOtpPolicy(t *testing.T) {
+	tests := []struct {
+		name            string
+		length          int
+		alphabet        OtpAlphabetEnum
+		expiryInMinutes int
+		wantErr         bool
+	}{
+		{"shortest", minOtpLength, OtpAlphabetNumeric, 5, false},
+		{"longest", maxOtpLength, OtpAlphabetUnambiguous, 5, false},
+		{"too short", minOtpLength - 1, OtpAlphabetNumeric, 5, true},
+		{"too long", maxOtpLength + 1, OtpAlphabetNumeric, 5, true},
+		{"unknown alphabet", 6, OtpAlphabetEnum("hex"), 5, true},
+		{"no expiry", 6, OtpAlphabetNumeric, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewOtpPolicy(tt.length, tt.alphabet, tt.expiryInMinutes)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewOtpPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateLengthAndCharset(t *testing.T) {
+	for _, alphabet := range testAlphabets {
+		for _, length := range []int{minOtpLength, 6, 8, maxOtpLength} {
+			policy, err := NewOtpPolicy(length, alphabet, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 200; i++ {
+				otp, err := policy.Generate()
+				if err != nil {
+					t.Fatalf("Generate() error = %v", err)
+				}
+				if len(otp) != length {
+					t.Fatalf("%s otp %q has length %d, want %d", alphabet, otp, len(otp), length)
+				}
+				for _, c := range otp {
+					if !strings.ContainsRune(otpAlphabets[alphabet], c) {
+						t.Fatalf("%s otp %q has %q outside of its alphabet", alphabet, otp, c)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestGenerateUnambiguousExcludesLookalikes(t *testing.T) {
+	if strings.ContainsAny(otpAlphabets[OtpAlphabetUnambiguous], "0O1IL") {
+		t.Errorf("unambiguous alphabet %q has lookalike characters", otpAlphabets[OtpAlphabetUnambiguous])
+	}
+}
+
+// TestGenerateUniform checks with a chi-square test that every character of
+// the alphabet is equally likely, overall and at every position. Rejection
+// sampling in Generate is what keeps alphabets whose size doesn't divide 256
+// from favouring their first characters.
+func TestGenerateUniform(t *testing.T) {
+	const (
+		length  = 8
+		samples = 20000
+		// z-score of a one sided p-value of 1e-4, the test fails about once
+		// every 10000 runs for a fair generator
+		z = 3.719
+		// Same p-value shared by the tests of every position
+		zPerPosition = 4.215
+	)
+	for _, alphabet := range testAlphabets {
+		t.Run(string(alphabet), func(t *testing.T) {
+			chars := otpAlphabets[alphabet]
+			policy, err := NewOtpPolicy(length, alphabet, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			overall := make([]int, len(chars))
+			positions := make([][]int, length)
+			for i := range positions {
+				positions[i] = make([]int, len(chars))
+			}
+			for i := 0; i < samples; i++ {
+				otp, err := policy.Generate()
+				if err != nil {
+					t.Fatalf("Generate() error = %v", err)
+				}
+				for pos := 0; pos < length; pos++ {
+					index := strings.IndexByte(chars, otp[pos])
+					overall[index]++
+					positions[pos][index]++
+				}
+			}
+			critical := chiSquareCritical(len(chars)-1, z)
+			if stat := chiSquare(overall); stat > critical {
+				t.Errorf("overall chi-square = %.2f, above %.2f, counts %v", stat, critical, overall)
+			}
+			criticalPerPosition := chiSquareCritical(len(chars)-1, zPerPosition)
+			for pos, counts := range positions {
+				if stat := chiSquare(counts); stat > criticalPerPosition {
+					t.Errorf("position %d chi-square = %.2f, above %.2f, counts %v", pos, stat, criticalPerPosition, counts)
+				}
+			}
+		})
+	}
+}
+
+// chiSquare returns the statistic of counts against a uniform distribution.
+func chiSquare(counts []int) float64 {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	expected := float64(total) / float64(len(counts))
+	stat := 0.0
+	for _, c := range counts {
+		d := float64(c) - expected
+		stat += d * d / expected
+	}
+
+	return stat
+}
+
+// chiSquareCritical approximates the critical value of the chi-square
+// distribution with df degrees of freedom at the z-score z, using the
+// Wilson-Hilferty transformation.
+func chiSquareCritical(df int, z float64) float64 {
+	k := float64(df)
+	v := 2 / (9 * k)
+
+	return k * math.Pow(1-v+z*math.Sqrt(v), 3)
+}
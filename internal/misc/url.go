@@ -0,0 +1,16 @@
+package misc
+
+import (
+	"net/url"
+	"strings"
+)
+
+// TokenUrl appends token to base as the token query parameter.
+func TokenUrl(base string, token string) string {
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+
+	return base + sep + "token=" + url.QueryEscape(token)
+}
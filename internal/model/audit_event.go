@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+type AuditEvent struct {
+	ID        uid.Identifier          `json:"id" gorm:"primaryKey;type:bigint;serializer:id;" kind:"aev"`
+	UserID    uid.Identifier          `json:"userId" gorm:"type:bigint;serializer:id;index:idx_audit_event_user_id" kind:"user"`
+	Email     *string                 `json:"email" gorm:"index:idx_audit_event_email"`
+	Type      enum.AuditEventTypeEnum `json:"type" gorm:"type:audit_event_type;not null"`
+	Outcome   enum.AuditOutcomeEnum   `json:"outcome" gorm:"type:audit_outcome;not null"`
+	IP        *string                 `json:"ip"`
+	UserAgent *string                 `json:"userAgent"`
+	Reason    *string                 `json:"reason"`
+	CreatedAt time.Time               `json:"createdAt" gorm:"not null;index:idx_audit_event_created_at"`
+}
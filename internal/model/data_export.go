@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+// DataExport is a copy of everything stored about a user, built in the
+// background and downloaded with the token emailed to them.
+type DataExport struct {
+	ID            uid.Identifier            `json:"id" gorm:"primaryKey;type:bigint;serializer:id;" kind:"dex"`
+	UserID        uid.Identifier            `json:"userId" gorm:"type:bigint;serializer:id;not null;index:idx_data_export_user_id" kind:"user"`
+	Status        enum.DataExportStatusEnum `json:"status" gorm:"type:data_export_status;not null;index:idx_data_export_status_next_attempt_at,priority:1"`
+	Attempts      int                       `json:"-" gorm:"not null"`
+	NextAttemptAt time.Time                 `json:"-" gorm:"not null;index:idx_data_export_status_next_attempt_at,priority:2"`
+	LastError     *string                   `json:"-"`
+	TokenHash     *string                   `json:"-" gorm:"index:idx_data_export_token_hash,unique,where:token_hash IS NOT NULL"`
+	Archive       *string                   `json:"-" gorm:"type:jsonb"`
+	CreatedAt     time.Time                 `json:"createdAt" gorm:"not null"`
+	ReadyAt       *time.Time                `json:"readyAt"`
+	ExpiresAt     *time.Time                `json:"expiresAt" gorm:"index:idx_data_export_expires_at"`
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+type EmailMessage struct {
+	ID         uid.Identifier `json:"id" gorm:"primaryKey;type:bigint;serializer:id;" kind:"eml"`
+	Recipients StringList     `json:"recipients" gorm:"type:jsonb;not null"`
+	Subject    string         `json:"subject" gorm:"not null"`
+	Body       string         `json:"-" gorm:"not null"`
+	Text       string         `json:"-" gorm:"not null;default:''"`
+	// Body and text are sealed while the message is dead lettered
+	IsSealed      bool                 `json:"-" gorm:"not null;default:false"`
+	Status        enum.EmailStatusEnum `json:"status" gorm:"type:email_status;not null;index:idx_email_message_status_next_attempt_at,priority:1"`
+	Attempts      int                  `json:"attempts" gorm:"not null"`
+	NextAttemptAt time.Time            `json:"nextAttemptAt" gorm:"not null;index:idx_email_message_status_next_attempt_at,priority:2"`
+	LastError     *string              `json:"lastError"`
+	CreatedAt     time.Time            `json:"createdAt" gorm:"not null"`
+	SentAt        *time.Time           `json:"sentAt"`
+}
+
+type StringList []string
+
+func (l *StringList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	default:
+		return fmt.Errorf("invalid string list")
+	}
+}
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
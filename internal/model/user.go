@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/nkbhasker/go-auth-starter/internal/enum"
 	"github.com/nkbhasker/go-auth-starter/internal/uid"
 )
@@ -16,4 +18,24 @@ type User struct {
 	IsBot            bool                       `json:"-"`
 	Gender           *enum.GenderEnum           `json:"gender" gorm:"type:gender"`
 	IdentityProvider *enum.IdentityProviderEnum `json:"identityProvider" gorm:"type:identity_provider"`
+	Locale           *string                    `json:"locale"`
+	Avatar           *string                    `json:"avatar"`
+	Status           enum.UserStatusEnum        `json:"status" gorm:"type:user_status;not null;default:ACTIVE"`
+	StatusReason     *string                    `json:"-"`
+	SuspendedUntil   *time.Time                 `json:"suspendedUntil"`
+	DeleteAfter      *time.Time                 `json:"deleteAfter"`
+	PublicMetadata   Metadata                   `json:"publicMetadata" gorm:"type:jsonb;not null;default:'{}'"`
+	PrivateMetadata  Metadata                   `json:"-" gorm:"type:jsonb;not null;default:'{}'"`
+	AppMetadata      Metadata                   `json:"appMetadata" gorm:"type:jsonb;not null;default:'{}'"`
+	UpdatedAt        time.Time                  `json:"updatedAt" gorm:"not null;default:now()"`
+}
+
+// IsSuspended reports whether the user is suspended at the given time.
+// A suspension without an end time lasts until it is lifted explicitly.
+func (u *User) IsSuspended(at time.Time) bool {
+	if u.Status != enum.UserStatusSuspended {
+		return false
+	}
+
+	return u.SuspendedUntil == nil || at.Before(*u.SuspendedUntil)
 }
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+)
+
+type WebhookEndpoint struct {
+	ID        uid.Identifier `json:"id" gorm:"primaryKey;type:bigint;serializer:id;" kind:"whe"`
+	URL       string         `json:"url" gorm:"not null"`
+	Secret    string         `json:"-" gorm:"not null"`
+	Events    EventTypeList  `json:"events" gorm:"type:jsonb;not null"`
+	IsActive  bool           `json:"isActive" gorm:"not null"`
+	CreatedAt time.Time      `json:"createdAt" gorm:"not null"`
+}
+
+type WebhookDelivery struct {
+	ID             uid.Identifier                 `json:"id" gorm:"primaryKey;type:bigint;serializer:id;" kind:"whd"`
+	EndpointID     uid.Identifier                 `json:"endpointId" gorm:"type:bigint;serializer:id;not null;index:idx_webhook_delivery_endpoint_id" kind:"whe"`
+	UserID         uid.Identifier                 `json:"userId" gorm:"type:bigint;serializer:id;index:idx_webhook_delivery_user_id" kind:"user"`
+	EventID        string                         `json:"eventId" gorm:"not null"`
+	EventType      enum.WebhookEventTypeEnum      `json:"eventType" gorm:"type:webhook_event_type;not null"`
+	Payload        string                         `json:"payload" gorm:"type:jsonb;not null"`
+	Status         enum.WebhookDeliveryStatusEnum `json:"status" gorm:"type:webhook_delivery_status;not null;index:idx_webhook_delivery_status_next_attempt_at,priority:1"`
+	Attempts       int                            `json:"attempts" gorm:"not null"`
+	NextAttemptAt  time.Time                      `json:"nextAttemptAt" gorm:"not null;index:idx_webhook_delivery_status_next_attempt_at,priority:2"`
+	ResponseStatus *int                           `json:"responseStatus"`
+	LastError      *string                        `json:"lastError"`
+	CreatedAt      time.Time                      `json:"createdAt" gorm:"not null"`
+	DeliveredAt    *time.Time                     `json:"deliveredAt"`
+}
+
+type EventTypeList []enum.WebhookEventTypeEnum
+
+func (l *EventTypeList) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	default:
+		return fmt.Errorf("invalid event type list")
+	}
+}
+
+func (l EventTypeList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+func (l EventTypeList) Contains(eventType enum.WebhookEventTypeEnum) bool {
+	for _, e := range l {
+		if e == eventType {
+			return true
+		}
+	}
+
+	return false
+}
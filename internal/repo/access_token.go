@@ -3,8 +3,10 @@ package repo
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/nkbhasker/go-auth-starter/internal/metrics"
 	"github.com/nkbhasker/go-auth-starter/internal/misc"
 	"github.com/nkbhasker/go-auth-starter/internal/storage"
 )
@@ -12,7 +14,9 @@ import (
 const accessTokenKey = "atk"
 
 type AccessTokenRepo interface {
-	Create(sub string, name string) (string, error)
+	Create(ctx context.Context, sub string, name string, metadata map[string]interface{}) (string, error)
+	List(ctx context.Context, sub string) ([]string, error)
+	RevokeAll(ctx context.Context, sub string) error
 }
 
 type accessTokenRepo struct {
@@ -21,19 +25,50 @@ type accessTokenRepo struct {
 	ttl        time.Duration
 }
 
-func (r *accessTokenRepo) Create(sub string, name string) (string, error) {
-	id, accessToken, err := r.jwtHelper.NewAccessToken(sub, name, r.ttl)
+func (r *accessTokenRepo) Create(ctx context.Context, sub string, name string, metadata map[string]interface{}) (string, error) {
+	id, accessToken, err := r.jwtHelper.NewAccessToken(sub, name, metadata, r.ttl)
 	if err != nil {
 		return "", err
 	}
-	err = r.addToken(id, sub)
+	err = r.addToken(ctx, id, sub)
 	if err != nil {
 		return "", err
 	}
+	metrics.TokensIssued(1)
 
 	return accessToken, nil
 }
 
+// List returns the ids of all live access tokens issued to sub.
+func (r *accessTokenRepo) List(ctx context.Context, sub string) ([]string, error) {
+	keys, err := r.keys(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("%s_{%s}_", accessTokenKey, sub)
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = strings.TrimPrefix(key, prefix)
+	}
+
+	return ids, nil
+}
+
+func (r *accessTokenRepo) RevokeAll(ctx context.Context, sub string) error {
+	keys, err := r.keys(ctx, sub)
+	if err != nil {
+		return err
+	}
+
+	err = r.cacheStore.Del(ctx, keys...)
+	if err != nil {
+		return err
+	}
+	metrics.TokensRevoked(len(keys))
+
+	return nil
+}
+
 func NewAccessToeknRepo(cacheStore storage.CacheStore, jwtHelper misc.JwtHelper, expiresInMinutes int) AccessTokenRepo {
 	return &accessTokenRepo{
 		cacheStore: cacheStore,
@@ -42,7 +77,15 @@ func NewAccessToeknRepo(cacheStore storage.CacheStore, jwtHelper misc.JwtHelper,
 	}
 }
 
-func (r *accessTokenRepo) addToken(jti, sub string) error {
-	key := fmt.Sprintf("%s_%s_%s", accessTokenKey, sub, jti)
-	return r.cacheStore.WithTTL(r.ttl).Set(context.Background(), key, "1")
+func (r *accessTokenRepo) addToken(ctx context.Context, jti, sub string) error {
+	key := fmt.Sprintf("%s_{%s}_%s", accessTokenKey, sub, jti)
+	return r.cacheStore.WithTTL(r.ttl).Set(ctx, key, "1")
+}
+
+// The keys of a user's tokens share the {sub} hash tag, so that RevokeAll
+// deletes them in one slot with redis cluster.
+func (r *accessTokenRepo) keys(ctx context.Context, sub string) ([]string, error) {
+	pattern := fmt.Sprintf("%s_{%s}_*", accessTokenKey, sub)
+
+	return r.cacheStore.Keys(ctx, pattern)
 }
@@ -0,0 +1,125 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/storage"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+	"gorm.io/gorm"
+)
+
+const maxAuditEventLimit = 100
+
+type AuditEventFilter struct {
+	UserID uid.Identifier
+	Email  *string
+	Type   *enum.AuditEventTypeEnum
+	IP     *string
+	From   *time.Time
+	To     *time.Time
+	// Only events older than this event are returned
+	Before uid.Identifier
+	Limit  int
+}
+
+// AuditEventRepo is append only, events are only updated to anonymize them
+// and are only deleted once they fall out of the retention period.
+type AuditEventRepo interface {
+	Record(ctx context.Context, event model.AuditEvent) error
+	Search(ctx context.Context, filter AuditEventFilter) ([]*model.AuditEvent, error)
+	DeleteBefore(ctx context.Context, before time.Time) (int64, error)
+	// Anonymize strips the personal data from the events of the user or,
+	// when set, of its email, keeping the events themselves
+	Anonymize(ctx context.Context, userId uid.Identifier, email *string) error
+	WithTx(tx *gorm.DB) AuditEventRepo
+}
+
+type auditEventRepo struct {
+	dbStore     storage.DBStore
+	idGenerator uid.IdGenerator
+}
+
+func NewAuditEventRepo(dbStore storage.DBStore, idGenerator uid.IdGenerator) AuditEventRepo {
+	return &auditEventRepo{
+		dbStore:     dbStore,
+		idGenerator: idGenerator,
+	}
+}
+
+func (r auditEventRepo) WithTx(tx *gorm.DB) AuditEventRepo {
+	return NewAuditEventRepo(r.dbStore.WithTx(tx), r.idGenerator)
+}
+
+func (r auditEventRepo) Record(ctx context.Context, event model.AuditEvent) error {
+	if event.ID == nil {
+		id, err := r.idGenerator.NextFromFieldTag(event, uid.FieldNameID)
+		if err != nil {
+			return err
+		}
+		event.ID = id
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	return dbError(r.dbStore.DB().WithContext(ctx).Create(&event).Error)
+}
+
+func (r auditEventRepo) Search(ctx context.Context, filter AuditEventFilter) ([]*model.AuditEvent, error) {
+	query := r.dbStore.DB().WithContext(ctx)
+	if filter.UserID != nil {
+		query = query.Where(`"user_id" = ?`, filter.UserID)
+	}
+	if filter.Email != nil {
+		query = query.Where(`"email" = ?`, *filter.Email)
+	}
+	if filter.Type != nil {
+		query = query.Where(`"type" = ?`, *filter.Type)
+	}
+	if filter.IP != nil {
+		query = query.Where(`"ip" = ?`, *filter.IP)
+	}
+	if filter.From != nil {
+		query = query.Where(`"created_at" >= ?`, *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where(`"created_at" < ?`, *filter.To)
+	}
+	if filter.Before != nil {
+		query = query.Where(`"id" < ?`, filter.Before)
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > maxAuditEventLimit {
+		limit = maxAuditEventLimit
+	}
+	events := []*model.AuditEvent{}
+	err := query.Order(`"id" DESC`).Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return events, nil
+}
+
+func (r auditEventRepo) DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := r.dbStore.DB().WithContext(ctx).Where(`"created_at" < ?`, before).Delete(&model.AuditEvent{})
+
+	return result.RowsAffected, dbError(result.Error)
+}
+
+func (r auditEventRepo) Anonymize(ctx context.Context, userId uid.Identifier, email *string) error {
+	query := r.dbStore.DB().WithContext(ctx).Model(&model.AuditEvent{}).Where(`"user_id" = ?`, userId)
+	if email != nil {
+		query = query.Or(`"email" = ?`, *email)
+	}
+	err := query.Updates(map[string]interface{}{
+		"email":      nil,
+		"ip":         nil,
+		"user_agent": nil,
+	}).Error
+
+	return dbError(err)
+}
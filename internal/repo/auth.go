@@ -2,59 +2,207 @@ package repo
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/nkbhasker/go-auth-starter/internal/misc"
 	"github.com/nkbhasker/go-auth-starter/internal/storage"
 	"github.com/nkbhasker/go-auth-starter/internal/uid"
-	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
 )
 
 type AuthKeyEnum string
 
+const undoTokenLength = 32
+
 const (
-	AuthKeyOTP AuthKeyEnum = "OTP"
+	AuthKeyOTP             AuthKeyEnum = "OTP"
+	AuthKeyEmailChangeUndo AuthKeyEnum = "EMAIL_CHANGE_UNDO"
+)
+
+var (
+	ErrOtpExpired          = fmt.Errorf("otp expired")
+	ErrInvalidOtp          = fmt.Errorf("invalid otp")
+	ErrOtpAttemptsExceeded = fmt.Errorf("too many invalid otp attempts")
+	ErrInvalidUndoToken    = fmt.Errorf("invalid or expired undo token")
 )
 
+// storedOtp is what is stored for an otp, the whole value is compared and
+// swapped so that concurrent attempts are all counted.
+type storedOtp struct {
+	Salt     string `json:"salt"`
+	Hash     string `json:"hash"`
+	Attempts int    `json:"attempts"`
+}
+
 type AuthRepo interface {
-	SaveOTP(ctx context.Context, key string, otp string) error
-	GetOTP(ctx context.Context, key string) (string, error)
+	SaveOTP(ctx context.Context, key string, otp string, expiresIn time.Duration) error
+	VerifyOTP(ctx context.Context, key string, otp string) error
+	DeleteOTP(ctx context.Context, key string) error
+	SaveEmailChangeUndo(ctx context.Context, change EmailChangeUndo, expiresIn time.Duration) (string, error)
+	ConsumeEmailChangeUndo(ctx context.Context, token string) (*EmailChangeUndo, error)
+	WithTx(tx *gorm.DB) AuthRepo
+}
+
+// EmailChangeUndo is what an email change undo token grants, reverting the
+// user's email from NewEmail back to OldEmail.
+type EmailChangeUndo struct {
+	UserID   string `json:"userId"`
+	OldEmail string `json:"oldEmail"`
+	NewEmail string `json:"newEmail"`
 }
 
 type authRepo struct {
-	dbStore      storage.DBStore
-	cacheStore   storage.CacheStore
-	idGenerator  uid.IdGenerator
-	otpExpiresIn time.Duration
+	dbStore        storage.DBStore
+	cacheStore     storage.CacheStore
+	idGenerator    uid.IdGenerator
+	otpMaxAttempts int
 }
 
 func NewAuthRepo(
 	dbStore storage.DBStore,
 	cacheStore storage.CacheStore,
 	idGenerator uid.IdGenerator,
-	otpExpiryInMintues int,
+	otpMaxAttempts int,
 ) AuthRepo {
 	return &authRepo{
-		dbStore:      dbStore,
-		cacheStore:   cacheStore,
-		idGenerator:  idGenerator,
-		otpExpiresIn: time.Duration(otpExpiryInMintues * int(time.Minute)),
+		dbStore:        dbStore,
+		cacheStore:     cacheStore,
+		idGenerator:    idGenerator,
+		otpMaxAttempts: otpMaxAttempts,
+	}
+}
+
+func (r *authRepo) WithTx(tx *gorm.DB) AuthRepo {
+	return NewAuthRepo(r.dbStore.WithTx(tx), r.cacheStore, r.idGenerator, r.otpMaxAttempts)
+}
+
+// SaveOTP stores a salted hash of the otp for expiresIn, replacing any
+// previous otp and its attempt count. Otps are case insensitive.
+func (r *authRepo) SaveOTP(ctx context.Context, key string, otp string, expiresIn time.Duration) error {
+	salt, err := misc.NewOtpSalt()
+	if err != nil {
+		return err
+	}
+
+	return r.cacheStore.WithTTL(expiresIn).Set(ctx, strings.ToLower(key), storedOtp{
+		Salt: salt,
+		Hash: misc.HashOtp(salt, normalizeOtp(otp)),
+	})
+}
+
+// VerifyOTP checks otp against the stored one and consumes it on success.
+// Every wrong attempt counts against the stored otp, which is invalidated
+// after otpMaxAttempts of them.
+func (r *authRepo) VerifyOTP(ctx context.Context, key string, otp string) error {
+	key = strings.ToLower(key)
+	for {
+		value, err := r.cacheStore.Get(ctx, key)
+		if err == storage.ErrCacheMiss {
+			return ErrOtpExpired
+		}
+		if err != nil {
+			return err
+		}
+		stored := storedOtp{}
+		err = json.Unmarshal([]byte(value), &stored)
+		if err != nil {
+			return err
+		}
+		if misc.ValidateOtp(stored.Hash, misc.HashOtp(stored.Salt, normalizeOtp(otp))) {
+			deleted, err := r.cacheStore.CompareAndDelete(ctx, key, value)
+			if err != nil {
+				return err
+			}
+			// Consumed by a concurrent request or replaced by a new otp
+			if !deleted {
+				return ErrOtpExpired
+			}
+
+			return nil
+		}
+		stored.Attempts++
+		var changed bool
+		if stored.Attempts >= r.otpMaxAttempts {
+			changed, err = r.cacheStore.CompareAndDelete(ctx, key, value)
+		} else {
+			var next []byte
+			next, err = json.Marshal(stored)
+			if err != nil {
+				return err
+			}
+			changed, err = r.cacheStore.CompareAndSwap(ctx, key, value, string(next))
+		}
+		if err != nil {
+			return err
+		}
+		// Another attempt changed the otp in the meantime, count this one
+		// against its new state
+		if !changed {
+			continue
+		}
+		if stored.Attempts >= r.otpMaxAttempts {
+			return ErrOtpAttemptsExceeded
+		}
+
+		return ErrInvalidOtp
 	}
 }
 
-func (r *authRepo) SaveOTP(ctx context.Context, key string, otp string) error {
-	return r.cacheStore.DB().Set(ctx, strings.ToLower(key), otp, r.otpExpiresIn).Err()
+func (r *authRepo) DeleteOTP(ctx context.Context, key string) error {
+	return r.cacheStore.Del(ctx, strings.ToLower(key))
 }
 
-func (r *authRepo) GetOTP(ctx context.Context, key string) (string, error) {
-	result := r.cacheStore.DB().Get(ctx, strings.ToLower(key))
-	if result.Err() == redis.Nil {
-		return "", fmt.Errorf("otp expired")
+// SaveEmailChangeUndo stores change for expiresIn and returns the token that
+// redeems it. Only a hash of the token is stored.
+func (r *authRepo) SaveEmailChangeUndo(ctx context.Context, change EmailChangeUndo, expiresIn time.Duration) (string, error) {
+	b := make([]byte, undoTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	value, err := json.Marshal(change)
+	if err != nil {
+		return "", err
 	}
-	if result.Err() != nil {
-		return "", result.Err()
+	err = r.cacheStore.WithTTL(expiresIn).Set(ctx, emailChangeUndoKey(token), value)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeEmailChangeUndo redeems an undo token, which can only be done once.
+func (r *authRepo) ConsumeEmailChangeUndo(ctx context.Context, token string) (*EmailChangeUndo, error) {
+	value, err := r.cacheStore.GetDel(ctx, emailChangeUndoKey(token))
+	if err == storage.ErrCacheMiss {
+		return nil, ErrInvalidUndoToken
 	}
+	if err != nil {
+		return nil, err
+	}
+	change := &EmailChangeUndo{}
+	err = json.Unmarshal([]byte(value), change)
+	if err != nil {
+		return nil, err
+	}
+
+	return change, nil
+}
+
+func emailChangeUndoKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return strings.ToLower(fmt.Sprintf("%s_%s", AuthKeyEmailChangeUndo, hex.EncodeToString(sum[:])))
+}
 
-	return result.Val(), nil
+func normalizeOtp(otp string) string {
+	return strings.ToUpper(strings.TrimSpace(otp))
 }
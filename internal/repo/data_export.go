@@ -0,0 +1,214 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/storage"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+	"gorm.io/gorm"
+)
+
+const exportTokenLength = 32
+
+var (
+	ErrInvalidExportToken = fmt.Errorf("invalid or expired export token")
+	ErrDataExportNotReady = fmt.Errorf("no data export is ready, request one first")
+)
+
+type DataExportRepo interface {
+	// Request queues an export of the user, returning the one already
+	// pending if any
+	Request(ctx context.Context, userId uid.Identifier) (*model.DataExport, error)
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*model.DataExport, error)
+	Update(ctx context.Context, export *model.DataExport) error
+	// Complete stores the archive of export until expiresAt and returns the
+	// token that downloads it
+	Complete(ctx context.Context, export *model.DataExport, archive []byte, expiresAt time.Time) (string, error)
+	GetByToken(ctx context.Context, token string) (*model.DataExport, error)
+	// GetLatestReady returns the latest export of the user that is ready to
+	// download, ErrDataExportNotReady if none is
+	GetLatestReady(ctx context.Context, userId uid.Identifier) (*model.DataExport, error)
+	DeleteExpired(ctx context.Context, at time.Time) (int64, error)
+	DeleteByUser(ctx context.Context, userId uid.Identifier) error
+	WithTx(tx *gorm.DB) DataExportRepo
+}
+
+type dataExportRepo struct {
+	dbStore     storage.DBStore
+	idGenerator uid.IdGenerator
+}
+
+func NewDataExportRepo(dbStore storage.DBStore, idGenerator uid.IdGenerator) DataExportRepo {
+	return &dataExportRepo{
+		dbStore:     dbStore,
+		idGenerator: idGenerator,
+	}
+}
+
+func (r dataExportRepo) WithTx(tx *gorm.DB) DataExportRepo {
+	return NewDataExportRepo(r.dbStore.WithTx(tx), r.idGenerator)
+}
+
+func (r dataExportRepo) Request(ctx context.Context, userId uid.Identifier) (*model.DataExport, error) {
+	export := &model.DataExport{}
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Where(`"user_id" = ? AND "status" = ?`, userId, enum.DataExportStatusPending).
+		Limit(1).
+		Find(export).
+		Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if export.ID != nil {
+		return export, nil
+	}
+	now := time.Now()
+	export = &model.DataExport{
+		UserID:        userId,
+		Status:        enum.DataExportStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	id, err := r.idGenerator.NextFromFieldTag(*export, uid.FieldNameID)
+	if err != nil {
+		return nil, err
+	}
+	export.ID = id
+	err = r.dbStore.DB().WithContext(ctx).Create(export).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return export, nil
+}
+
+// Claim locks due exports for the lease duration by pushing their next
+// attempt forward, so that concurrent workers never build the same export.
+func (r dataExportRepo) Claim(ctx context.Context, limit int, lease time.Duration) ([]*model.DataExport, error) {
+	exports := []*model.DataExport{}
+	now := time.Now()
+	err := r.dbStore.DB().WithContext(ctx).Raw(`
+		UPDATE "data_exports" SET "next_attempt_at" = ?
+		WHERE "id" IN (
+			SELECT "id" FROM "data_exports"
+			WHERE "status" = ? AND "next_attempt_at" <= ?
+			ORDER BY "next_attempt_at"
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		now.Add(lease), enum.DataExportStatusPending, now, limit,
+	).Scan(&exports).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return exports, nil
+}
+
+func (r dataExportRepo) Update(ctx context.Context, export *model.DataExport) error {
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Model(export).
+		Select("status", "attempts", "next_attempt_at", "last_error", "token_hash", "archive", "ready_at", "expires_at").
+		Updates(export).
+		Error
+
+	return dbError(err)
+}
+
+func (r dataExportRepo) Complete(
+	ctx context.Context,
+	export *model.DataExport,
+	archive []byte,
+	expiresAt time.Time,
+) (string, error) {
+	b := make([]byte, exportTokenLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	tokenHash := exportTokenHash(token)
+	value := string(archive)
+	now := time.Now()
+	export.Status = enum.DataExportStatusReady
+	export.LastError = nil
+	export.TokenHash = &tokenHash
+	export.Archive = &value
+	export.ReadyAt = &now
+	export.ExpiresAt = &expiresAt
+	err := r.Update(ctx, export)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetByToken returns the ready export downloaded with token. The token can
+// be used again until the export expires.
+func (r dataExportRepo) GetByToken(ctx context.Context, token string) (*model.DataExport, error) {
+	export := &model.DataExport{}
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Where(`"token_hash" = ? AND "status" = ? AND "expires_at" > ?`, exportTokenHash(token), enum.DataExportStatusReady, time.Now()).
+		Limit(1).
+		Find(export).
+		Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if export.ID == nil {
+		return nil, ErrInvalidExportToken
+	}
+
+	return export, nil
+}
+
+func (r dataExportRepo) GetLatestReady(ctx context.Context, userId uid.Identifier) (*model.DataExport, error) {
+	export := &model.DataExport{}
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Where(`"user_id" = ? AND "status" = ? AND "expires_at" > ?`, userId, enum.DataExportStatusReady, time.Now()).
+		Order(`"ready_at" DESC`).
+		Limit(1).
+		Find(export).
+		Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if export.ID == nil {
+		return nil, ErrDataExportNotReady
+	}
+
+	return export, nil
+}
+
+// DeleteExpired deletes the exports, and so their archive, that expired
+// before at.
+func (r dataExportRepo) DeleteExpired(ctx context.Context, at time.Time) (int64, error) {
+	result := r.dbStore.DB().WithContext(ctx).Where(`"expires_at" < ?`, at).Delete(&model.DataExport{})
+
+	return result.RowsAffected, dbError(result.Error)
+}
+
+func (r dataExportRepo) DeleteByUser(ctx context.Context, userId uid.Identifier) error {
+	err := r.dbStore.DB().WithContext(ctx).Where(`"user_id" = ?`, userId).Delete(&model.DataExport{}).Error
+
+	return dbError(err)
+}
+
+func exportTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
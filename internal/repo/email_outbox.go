@@ -0,0 +1,200 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/storage"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+	"gorm.io/gorm"
+)
+
+const maxEmailMessageLimit = 100
+
+var (
+	ErrEmailMessageNotFound = fmt.Errorf("email message not found")
+	ErrEmailNotDeadLettered = fmt.Errorf("only dead lettered emails can be resent")
+)
+
+type EmailOutboxRepo interface {
+	Enqueue(ctx context.Context, recipients []string, subject string, html string, text string) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*model.EmailMessage, error)
+	Update(ctx context.Context, message *model.EmailMessage) error
+	List(ctx context.Context, status *enum.EmailStatusEnum, before uid.Identifier, limit int) ([]*model.EmailMessage, error)
+	ListByRecipient(ctx context.Context, email string) ([]*model.EmailMessage, error)
+	DeleteByRecipient(ctx context.Context, email string) error
+	// DeleteSentBefore deletes the messages sent before the given time
+	DeleteSentBefore(ctx context.Context, before time.Time) (int64, error)
+	Resend(ctx context.Context, id uid.Identifier) (*model.EmailMessage, error)
+	WithTx(tx *gorm.DB) EmailOutboxRepo
+}
+
+type emailOutboxRepo struct {
+	dbStore     storage.DBStore
+	idGenerator uid.IdGenerator
+}
+
+func NewEmailOutboxRepo(dbStore storage.DBStore, idGenerator uid.IdGenerator) EmailOutboxRepo {
+	return &emailOutboxRepo{
+		dbStore:     dbStore,
+		idGenerator: idGenerator,
+	}
+}
+
+func (r emailOutboxRepo) WithTx(tx *gorm.DB) EmailOutboxRepo {
+	return NewEmailOutboxRepo(r.dbStore.WithTx(tx), r.idGenerator)
+}
+
+func (r emailOutboxRepo) Enqueue(ctx context.Context, recipients []string, subject string, html string, text string) error {
+	message := model.EmailMessage{
+		Recipients:    recipients,
+		Subject:       subject,
+		Body:          html,
+		Text:          text,
+		Status:        enum.EmailStatusPending,
+		NextAttemptAt: time.Now(),
+		CreatedAt:     time.Now(),
+	}
+	id, err := r.idGenerator.NextFromFieldTag(message, uid.FieldNameID)
+	if err != nil {
+		return err
+	}
+	message.ID = id
+
+	return dbError(r.dbStore.DB().WithContext(ctx).Create(&message).Error)
+}
+
+// Claim locks due messages for the lease duration by pushing their next
+// attempt forward, so concurrent workers never send the same message twice
+// and messages of a crashed worker are retried.
+func (r emailOutboxRepo) Claim(ctx context.Context, limit int, lease time.Duration) ([]*model.EmailMessage, error) {
+	messages := []*model.EmailMessage{}
+	now := time.Now()
+	err := r.dbStore.DB().WithContext(ctx).Raw(`
+		UPDATE "email_messages" SET "next_attempt_at" = ?
+		WHERE "id" IN (
+			SELECT "id" FROM "email_messages"
+			WHERE "status" = ? AND "next_attempt_at" <= ?
+			ORDER BY "next_attempt_at"
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		now.Add(lease), enum.EmailStatusPending, now, limit,
+	).Scan(&messages).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return messages, nil
+}
+
+func (r emailOutboxRepo) Update(ctx context.Context, message *model.EmailMessage) error {
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Model(message).
+		Select("body", "text", "is_sealed", "status", "attempts", "next_attempt_at", "last_error", "sent_at").
+		Updates(message).
+		Error
+
+	return dbError(err)
+}
+
+func (r emailOutboxRepo) List(
+	ctx context.Context,
+	status *enum.EmailStatusEnum,
+	before uid.Identifier,
+	limit int,
+) ([]*model.EmailMessage, error) {
+	query := r.dbStore.DB().WithContext(ctx)
+	if status != nil {
+		query = query.Where(`"status" = ?`, *status)
+	}
+	if before != nil {
+		query = query.Where(`"id" < ?`, before)
+	}
+	if limit <= 0 || limit > maxEmailMessageLimit {
+		limit = maxEmailMessageLimit
+	}
+	messages := []*model.EmailMessage{}
+	err := query.Order(`"id" DESC`).Limit(limit).Find(&messages).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return messages, nil
+}
+
+// ListByRecipient returns every message sent or to be sent to email.
+func (r emailOutboxRepo) ListByRecipient(ctx context.Context, email string) ([]*model.EmailMessage, error) {
+	recipients, err := json.Marshal([]string{email})
+	if err != nil {
+		return nil, err
+	}
+	messages := []*model.EmailMessage{}
+	err = r.dbStore.DB().
+		WithContext(ctx).
+		Where(`"recipients" @> ?::jsonb`, string(recipients)).
+		Order(`"id"`).
+		Find(&messages).
+		Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return messages, nil
+}
+
+// DeleteByRecipient deletes every message sent or to be sent to email.
+func (r emailOutboxRepo) DeleteByRecipient(ctx context.Context, email string) error {
+	recipients, err := json.Marshal([]string{email})
+	if err != nil {
+		return err
+	}
+	err = r.dbStore.DB().
+		WithContext(ctx).
+		Where(`"recipients" @> ?::jsonb`, string(recipients)).
+		Delete(&model.EmailMessage{}).
+		Error
+
+	return dbError(err)
+}
+
+func (r emailOutboxRepo) DeleteSentBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := r.dbStore.DB().
+		WithContext(ctx).
+		Where(`"status" = ? AND "sent_at" < ?`, enum.EmailStatusSent, before).
+		Delete(&model.EmailMessage{})
+
+	return result.RowsAffected, dbError(result.Error)
+}
+
+// Resend moves a dead lettered message back to the queue with a fresh
+// attempt budget. Sent messages can't be resent as their body is discarded.
+func (r emailOutboxRepo) Resend(ctx context.Context, id uid.Identifier) (*model.EmailMessage, error) {
+	message := &model.EmailMessage{}
+	err := r.dbStore.DB().WithContext(ctx).Find(message, id).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if message.ID == nil {
+		return nil, ErrEmailMessageNotFound
+	}
+	if message.Status != enum.EmailStatusDead {
+		return nil, ErrEmailNotDeadLettered
+	}
+	message.Status = enum.EmailStatusPending
+	message.Attempts = 0
+	message.NextAttemptAt = time.Now()
+	message.LastError = nil
+	err = r.Update(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
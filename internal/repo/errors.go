@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrConflict is wrapped by errors of writes violating a unique index
+	ErrConflict = fmt.Errorf("conflict")
+	// ErrUnavailable is wrapped by errors of the database being unreachable,
+	// too slow or the request being cancelled
+	ErrUnavailable = fmt.Errorf("database unavailable")
+)
+
+// ConflictError is the error of a write violating a unique index. Field is
+// the field of the index when clients may be told about it, empty otherwise.
+type ConflictError struct {
+	Field      string
+	Constraint string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s violated", ErrConflict, e.Constraint)
+	}
+
+	return fmt.Sprintf("%s: %s already in use", ErrConflict, e.Field)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// uniqueIndexFields names the field of each unique index in conflict errors.
+var uniqueIndexFields = map[string]string{
+	"idx_email": "email",
+	"idx_phone": "phone",
+}
+
+const (
+	pgUniqueViolation = "23505"
+	pgQueryCanceled   = "57014"
+	// Failures of transactions that may succeed when retried
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	// Classes of errors where the server can't be used right now
+	pgClassConnectionException   = "08"
+	pgClassInsufficientResources = "53"
+	pgClassOperatorIntervention  = "57"
+)
+
+// dbError classifies a database error as ErrConflict or ErrUnavailable,
+// leaving other errors as they are.
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+	pgErr := &pgconn.PgError{}
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == pgUniqueViolation:
+			return &ConflictError{
+				Field:      uniqueIndexFields[pgErr.ConstraintName],
+				Constraint: pgErr.ConstraintName,
+			}
+		case pgErr.Code == pgQueryCanceled,
+			pgErr.Code[:2] == pgClassConnectionException,
+			pgErr.Code[:2] == pgClassInsufficientResources,
+			pgErr.Code[:2] == pgClassOperatorIntervention:
+			return fmt.Errorf("%w: %s", ErrUnavailable, pgErr.Message)
+		}
+
+		return err
+	}
+	netErr := (net.Error)(nil)
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		pgconn.Timeout(err) ||
+		// Failed dials too, which pgconn wraps in its connect errors
+		errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+// isRetryableTx reports whether err is a transaction failing only because
+// of concurrent transactions.
+func isRetryableTx(err error) bool {
+	pgErr := &pgconn.PgError{}
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected
+}
@@ -1,21 +1,51 @@
 package repo
 
 import (
+	"context"
+	"math/rand"
+	"time"
+
 	"github.com/nkbhasker/go-auth-starter/internal/misc"
 	"github.com/nkbhasker/go-auth-starter/internal/storage"
 	"github.com/nkbhasker/go-auth-starter/internal/uid"
+	"gorm.io/gorm"
+)
+
+const (
+	txMaxAttempts = 3
+	txRetryDelay  = 20 * time.Millisecond
 )
 
 type Repo interface {
 	UserRepo() UserRepo
 	AuthRepo() AuthRepo
 	AccessTokenRepo() AccessTokenRepo
+	UserStatusRepo() UserStatusRepo
+	AuditEventRepo() AuditEventRepo
+	WebhookRepo() WebhookRepo
+	EmailOutboxRepo() EmailOutboxRepo
+	DataExportRepo() DataExportRepo
+	// InTx runs fn in a transaction with the repos bound to it, committing
+	// when fn returns nil and rolling back otherwise. The transaction is
+	// retried from the start on serialization failures and deadlocks, so fn
+	// must not have effects outside of it. Called again from within fn it
+	// runs in a savepoint. AuthRepo and AccessTokenRepo keep writing to the
+	// cache store, which is never rolled back.
+	InTx(ctx context.Context, fn func(Repo) error) error
 }
 
 type repo struct {
+	dbStore storage.DBStore
+	// The transaction the repos are bound to, nil outside of InTx
+	tx              *gorm.DB
 	userRepo        UserRepo
 	authRepo        AuthRepo
 	accessTokenRepo AccessTokenRepo
+	userStatusRepo  UserStatusRepo
+	auditEventRepo  AuditEventRepo
+	webhookRepo     WebhookRepo
+	emailOutboxRepo EmailOutboxRepo
+	dataExportRepo  DataExportRepo
 }
 
 type RepoOptions struct {
@@ -24,14 +54,22 @@ type RepoOptions struct {
 	IdGenerator                uid.IdGenerator
 	JwtHelper                  misc.JwtHelper
 	AccessTokenExpiryInMinutes int
-	OtpExpiryInMinutes         int
+	OtpMaxAttempts             int
+	UserStatusExpiryInSeconds  int
 }
 
 func NewRepo(options RepoOptions) Repo {
+	userRepo := NewUserRepo(options.DBStore, options.IdGenerator)
 	return &repo{
-		userRepo:        NewUserRepo(options.DBStore, options.IdGenerator),
-		authRepo:        NewAuthRepo(options.DBStore, options.CacheStore, options.IdGenerator, options.OtpExpiryInMinutes),
+		dbStore:         options.DBStore,
+		userRepo:        userRepo,
+		authRepo:        NewAuthRepo(options.DBStore, options.CacheStore, options.IdGenerator, options.OtpMaxAttempts),
 		accessTokenRepo: NewAccessToeknRepo(options.CacheStore, options.JwtHelper, options.AccessTokenExpiryInMinutes),
+		userStatusRepo:  NewUserStatusRepo(options.DBStore, options.CacheStore, userRepo, options.UserStatusExpiryInSeconds),
+		auditEventRepo:  NewAuditEventRepo(options.DBStore, options.IdGenerator),
+		webhookRepo:     NewWebhookRepo(options.DBStore, options.IdGenerator),
+		emailOutboxRepo: NewEmailOutboxRepo(options.DBStore, options.IdGenerator),
+		dataExportRepo:  NewDataExportRepo(options.DBStore, options.IdGenerator),
 	}
 }
 
@@ -46,3 +84,66 @@ func (r repo) AuthRepo() AuthRepo {
 func (r repo) AccessTokenRepo() AccessTokenRepo {
 	return r.accessTokenRepo
 }
+
+func (r repo) UserStatusRepo() UserStatusRepo {
+	return r.userStatusRepo
+}
+
+func (r repo) AuditEventRepo() AuditEventRepo {
+	return r.auditEventRepo
+}
+
+func (r repo) WebhookRepo() WebhookRepo {
+	return r.webhookRepo
+}
+
+func (r repo) EmailOutboxRepo() EmailOutboxRepo {
+	return r.emailOutboxRepo
+}
+
+func (r repo) DataExportRepo() DataExportRepo {
+	return r.dataExportRepo
+}
+
+func (r repo) InTx(ctx context.Context, fn func(Repo) error) error {
+	if r.tx != nil {
+		// Gorm uses a savepoint for transactions within a transaction
+		return r.tx.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(r.withTx(tx))
+		})
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = r.dbStore.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(r.withTx(tx))
+		})
+		if attempt == txMaxAttempts || !isRetryableTx(err) {
+			break
+		}
+		// Jittered backoff so that the conflicting transactions don't collide again
+		delay := txRetryDelay << (attempt - 1)
+		delay += time.Duration(rand.Int63n(int64(delay)))
+		select {
+		case <-ctx.Done():
+			return dbError(ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+
+	return dbError(err)
+}
+
+func (r repo) withTx(tx *gorm.DB) Repo {
+	return &repo{
+		dbStore:         r.dbStore.WithTx(tx),
+		tx:              tx,
+		userRepo:        r.userRepo.WithTx(tx),
+		authRepo:        r.authRepo.WithTx(tx),
+		accessTokenRepo: r.accessTokenRepo,
+		userStatusRepo:  r.userStatusRepo.WithTx(tx),
+		auditEventRepo:  r.auditEventRepo.WithTx(tx),
+		webhookRepo:     r.webhookRepo.WithTx(tx),
+		emailOutboxRepo: r.emailOutboxRepo.WithTx(tx),
+		dataExportRepo:  r.dataExportRepo.WithTx(tx),
+	}
+}
@@ -1,8 +1,11 @@
 package repo
 
 import (
+	"context"
 	"fmt"
+	"time"
 
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
 	"github.com/nkbhasker/go-auth-starter/internal/model"
 	"github.com/nkbhasker/go-auth-starter/internal/storage"
 	"github.com/nkbhasker/go-auth-starter/internal/uid"
@@ -10,14 +13,21 @@ import (
 )
 
 var ErrUserNotFound = fmt.Errorf("user not found")
+var ErrUserModified = fmt.Errorf("user was modified concurrently")
 
 type UserRepo interface {
 	New(options model.User) (*model.User, error)
-	Create(user *model.User) error
-	Update(user *model.User) error
-	Get(id uid.Identifier) (*model.User, error)
-	GetByEmail(email string) (*model.User, error)
+	Create(ctx context.Context, user *model.User) error
+	Update(ctx context.Context, user *model.User) error
+	Patch(ctx context.Context, user *model.User, columns map[string]interface{}) error
+	Get(ctx context.Context, id uid.Identifier) (*model.User, error)
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	Delete(ctx context.Context, user *model.User) error
+	ListDueForDeletion(ctx context.Context, at time.Time, limit int) ([]*model.User, error)
 	WithTx(tx *gorm.DB) UserRepo
+	// WithPrimary returns the repo reading from the primary, for reads
+	// followed by writes that must see the latest data
+	WithPrimary() UserRepo
 }
 
 type userRepo struct {
@@ -36,6 +46,10 @@ func (r userRepo) WithTx(tx *gorm.DB) UserRepo {
 	return NewUserRepo(r.dbStore.WithTx(tx), r.idGenerator)
 }
 
+func (r userRepo) WithPrimary() UserRepo {
+	return NewUserRepo(r.dbStore.WithPrimary(), r.idGenerator)
+}
+
 func (r userRepo) New(options model.User) (*model.User, error) {
 	if options.ID == nil {
 		id, err := r.idGenerator.NextFromFieldTag(options, uid.FieldNameID)
@@ -44,19 +58,22 @@ func (r userRepo) New(options model.User) (*model.User, error) {
 		}
 		options.ID = id
 	}
+	if options.Status == "" {
+		options.Status = enum.UserStatusActive
+	}
 
 	return &options, nil
 }
 
-func (r userRepo) Create(user *model.User) error {
-	return r.dbStore.DB().Save(user).Error
+func (r userRepo) Create(ctx context.Context, user *model.User) error {
+	return dbError(r.dbStore.DB().WithContext(ctx).Save(user).Error)
 }
 
-func (r userRepo) Get(id uid.Identifier) (*model.User, error) {
+func (r userRepo) Get(ctx context.Context, id uid.Identifier) (*model.User, error) {
 	user := &model.User{}
-	err := r.dbStore.DB().Find(user, id).Error
+	err := r.dbStore.ReadDB().WithContext(ctx).Find(user, id).Error
 	if err != nil {
-		return nil, nil
+		return nil, dbError(err)
 	}
 	if user.ID == nil {
 		return nil, ErrUserNotFound
@@ -65,11 +82,11 @@ func (r userRepo) Get(id uid.Identifier) (*model.User, error) {
 	return user, nil
 }
 
-func (r userRepo) GetByEmail(email string) (*model.User, error) {
+func (r userRepo) GetByEmail(ctx context.Context, email string) (*model.User, error) {
 	user := &model.User{}
-	err := r.dbStore.DB().Where(`"email"= ?`, email).Find(user).Error
+	err := r.dbStore.ReadDB().WithContext(ctx).Where(`"email"= ?`, email).Find(user).Error
 	if err != nil {
-		return nil, nil
+		return nil, dbError(err)
 	}
 	if user.ID == nil {
 		return nil, ErrUserNotFound
@@ -78,6 +95,51 @@ func (r userRepo) GetByEmail(email string) (*model.User, error) {
 	return user, nil
 }
 
-func (r userRepo) Update(user *model.User) error {
-	return r.dbStore.DB().Model(user).Updates(user).Error
+func (r userRepo) Update(ctx context.Context, user *model.User) error {
+	return dbError(r.dbStore.DB().WithContext(ctx).Model(user).Updates(user).Error)
+}
+
+// Patch sets the given columns, only if the user wasn't updated since it was
+// read, and returns ErrUserModified otherwise. A nil value clears the column.
+func (r userRepo) Patch(ctx context.Context, user *model.User, columns map[string]interface{}) error {
+	values := map[string]interface{}{}
+	for k, v := range columns {
+		values[k] = v
+	}
+	// Postgres keeps microseconds, truncate so the version read back matches
+	updatedAt := time.Now().Truncate(time.Microsecond)
+	values["updated_at"] = updatedAt
+	result := r.dbStore.DB().
+		WithContext(ctx).
+		Model(&model.User{}).
+		Where(`"id" = ? AND "updated_at" = ?`, user.ID, user.UpdatedAt).
+		UpdateColumns(values)
+	if result.Error != nil {
+		return dbError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserModified
+	}
+	user.UpdatedAt = updatedAt
+
+	return nil
+}
+
+func (r userRepo) Delete(ctx context.Context, user *model.User) error {
+	return dbError(r.dbStore.DB().WithContext(ctx).Delete(user).Error)
+}
+
+func (r userRepo) ListDueForDeletion(ctx context.Context, at time.Time, limit int) ([]*model.User, error) {
+	users := []*model.User{}
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Where(`"status" = ? AND "delete_after" <= ?`, enum.UserStatusPendingDeletion, at).
+		Limit(limit).
+		Find(&users).
+		Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return users, nil
 }
@@ -0,0 +1,141 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/storage"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+	"gorm.io/gorm"
+)
+
+const userStatusKey = "ust"
+
+var ErrUserSuspended = fmt.Errorf("user suspended")
+
+type UserStatusRepo interface {
+	Verify(ctx context.Context, id uid.Identifier) error
+	Update(ctx context.Context, id uid.Identifier, status enum.UserStatusEnum, reason *string, until *time.Time) error
+	ScheduleDeletion(ctx context.Context, id uid.Identifier, at time.Time) error
+	CancelDeletion(ctx context.Context, id uid.Identifier) error
+	Invalidate(ctx context.Context, id uid.Identifier) error
+	WithTx(tx *gorm.DB) UserStatusRepo
+}
+
+type userStatusRepo struct {
+	dbStore    storage.DBStore
+	cacheStore storage.CacheStore
+	userRepo   UserRepo
+	ttl        time.Duration
+}
+
+func NewUserStatusRepo(
+	dbStore storage.DBStore,
+	cacheStore storage.CacheStore,
+	userRepo UserRepo,
+	cacheExpiryInSeconds int,
+) UserStatusRepo {
+	return &userStatusRepo{
+		dbStore:    dbStore,
+		cacheStore: cacheStore,
+		userRepo:   userRepo,
+		ttl:        time.Duration(cacheExpiryInSeconds * int(time.Second)),
+	}
+}
+
+func (r *userStatusRepo) WithTx(tx *gorm.DB) UserStatusRepo {
+	return &userStatusRepo{
+		dbStore:    r.dbStore.WithTx(tx),
+		cacheStore: r.cacheStore,
+		userRepo:   r.userRepo.WithTx(tx),
+		ttl:        r.ttl,
+	}
+}
+
+// Verify returns ErrUserSuspended if the user is currently suspended. The
+// result is cached for a short while so that authenticated requests don't
+// hit postgres every time.
+func (r *userStatusRepo) Verify(ctx context.Context, id uid.Identifier) error {
+	key := fmt.Sprintf("%s_%s", userStatusKey, id.String())
+	status, err := r.cacheStore.Get(ctx, key)
+	if err == storage.ErrCacheMiss {
+		// A lagging replica could cache a lifted or missed suspension
+		user, err := r.userRepo.WithPrimary().Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		status = string(enum.UserStatusActive)
+		if user.IsSuspended(time.Now()) {
+			status = string(enum.UserStatusSuspended)
+		}
+		err = r.cacheStore.WithTTL(r.ttl).Set(ctx, key, status)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if status == string(enum.UserStatusSuspended) {
+		return ErrUserSuspended
+	}
+
+	return nil
+}
+
+func (r *userStatusRepo) Update(
+	ctx context.Context,
+	id uid.Identifier,
+	status enum.UserStatusEnum,
+	reason *string,
+	until *time.Time,
+) error {
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Model(&model.User{ID: id}).
+		Select("status", "status_reason", "suspended_until").
+		Updates(&model.User{Status: status, StatusReason: reason, SuspendedUntil: until}).
+		Error
+	if err != nil {
+		return dbError(err)
+	}
+
+	return r.Invalidate(ctx, id)
+}
+
+func (r *userStatusRepo) ScheduleDeletion(ctx context.Context, id uid.Identifier, at time.Time) error {
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Model(&model.User{ID: id}).
+		Select("status", "delete_after").
+		Updates(&model.User{Status: enum.UserStatusPendingDeletion, DeleteAfter: &at}).
+		Error
+	if err != nil {
+		return dbError(err)
+	}
+
+	return r.Invalidate(ctx, id)
+}
+
+func (r *userStatusRepo) CancelDeletion(ctx context.Context, id uid.Identifier) error {
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Model(&model.User{ID: id}).
+		Where(`"status" = ?`, enum.UserStatusPendingDeletion).
+		Select("status", "delete_after").
+		Updates(&model.User{Status: enum.UserStatusActive}).
+		Error
+	if err != nil {
+		return dbError(err)
+	}
+
+	return r.Invalidate(ctx, id)
+}
+
+func (r *userStatusRepo) Invalidate(ctx context.Context, id uid.Identifier) error {
+	key := fmt.Sprintf("%s_%s", userStatusKey, id.String())
+
+	return r.cacheStore.Del(ctx, key)
+}
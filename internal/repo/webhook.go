@@ -0,0 +1,274 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/enum"
+	"github.com/nkbhasker/go-auth-starter/internal/model"
+	"github.com/nkbhasker/go-auth-starter/internal/storage"
+	"github.com/nkbhasker/go-auth-starter/internal/uid"
+	"gorm.io/gorm"
+)
+
+const maxWebhookDeliveryLimit = 100
+
+var ErrWebhookEndpointNotFound = fmt.Errorf("webhook endpoint not found")
+var ErrWebhookDeliveryNotFound = fmt.Errorf("webhook delivery not found")
+
+type WebhookRepo interface {
+	NewEndpoint(options model.WebhookEndpoint) (*model.WebhookEndpoint, error)
+	CreateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) error
+	ListEndpoints(ctx context.Context) ([]*model.WebhookEndpoint, error)
+	DeleteEndpoint(ctx context.Context, id uid.Identifier) error
+	// Enqueue publishes an event about the user
+	Enqueue(ctx context.Context, userId uid.Identifier, eventType enum.WebhookEventTypeEnum, data interface{}) error
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*model.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery *model.WebhookDelivery) error
+	ListDeliveries(ctx context.Context, endpointId uid.Identifier, before uid.Identifier, limit int) ([]*model.WebhookDelivery, error)
+	ListDeliveriesByUser(ctx context.Context, userId uid.Identifier) ([]*model.WebhookDelivery, error)
+	DeleteDeliveriesByUser(ctx context.Context, userId uid.Identifier) error
+	Replay(ctx context.Context, id uid.Identifier) (*model.WebhookDelivery, error)
+	WithTx(tx *gorm.DB) WebhookRepo
+}
+
+type webhookRepo struct {
+	dbStore     storage.DBStore
+	idGenerator uid.IdGenerator
+}
+
+type webhookPayload struct {
+	ID        string                    `json:"id"`
+	Type      enum.WebhookEventTypeEnum `json:"type"`
+	CreatedAt time.Time                 `json:"createdAt"`
+	Data      interface{}               `json:"data"`
+}
+
+func NewWebhookRepo(dbStore storage.DBStore, idGenerator uid.IdGenerator) WebhookRepo {
+	return &webhookRepo{
+		dbStore:     dbStore,
+		idGenerator: idGenerator,
+	}
+}
+
+func (r webhookRepo) WithTx(tx *gorm.DB) WebhookRepo {
+	return NewWebhookRepo(r.dbStore.WithTx(tx), r.idGenerator)
+}
+
+func (r webhookRepo) NewEndpoint(options model.WebhookEndpoint) (*model.WebhookEndpoint, error) {
+	if options.ID == nil {
+		id, err := r.idGenerator.NextFromFieldTag(options, uid.FieldNameID)
+		if err != nil {
+			return nil, err
+		}
+		options.ID = id
+	}
+	if options.CreatedAt.IsZero() {
+		options.CreatedAt = time.Now()
+	}
+
+	return &options, nil
+}
+
+func (r webhookRepo) CreateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) error {
+	return dbError(r.dbStore.DB().WithContext(ctx).Create(endpoint).Error)
+}
+
+func (r webhookRepo) ListEndpoints(ctx context.Context) ([]*model.WebhookEndpoint, error) {
+	endpoints := []*model.WebhookEndpoint{}
+	err := r.dbStore.DB().WithContext(ctx).Order(`"id"`).Find(&endpoints).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return endpoints, nil
+}
+
+func (r webhookRepo) DeleteEndpoint(ctx context.Context, id uid.Identifier) error {
+	result := r.dbStore.DB().WithContext(ctx).Delete(&model.WebhookEndpoint{}, id)
+	if result.Error != nil {
+		return dbError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebhookEndpointNotFound
+	}
+
+	return nil
+}
+
+// Enqueue writes one pending delivery per active endpoint subscribed to the
+// event. Deliveries are picked up by the webhook delivery job, so enqueueing
+// within a transaction only publishes the event once it commits.
+func (r webhookRepo) Enqueue(
+	ctx context.Context,
+	userId uid.Identifier,
+	eventType enum.WebhookEventTypeEnum,
+	data interface{},
+) error {
+	endpoints := []*model.WebhookEndpoint{}
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Where(`"is_active" AND "events" @> ?::jsonb`, fmt.Sprintf(`[%q]`, eventType)).
+		Find(&endpoints).
+		Error
+	if err != nil {
+		return dbError(err)
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+	eventId, err := r.idGenerator.Next(uid.KindWebhookEvent)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	payload, err := json.Marshal(&webhookPayload{
+		ID:        eventId.String(),
+		Type:      eventType,
+		CreatedAt: now,
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+	deliveries := make([]*model.WebhookDelivery, len(endpoints))
+	for i, endpoint := range endpoints {
+		delivery, err := r.newDelivery(model.WebhookDelivery{
+			EndpointID:    endpoint.ID,
+			UserID:        userId,
+			EventID:       eventId.String(),
+			EventType:     eventType,
+			Payload:       string(payload),
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+		deliveries[i] = delivery
+	}
+
+	return dbError(r.dbStore.DB().WithContext(ctx).Create(&deliveries).Error)
+}
+
+// ClaimDeliveries locks due deliveries for the lease duration by pushing
+// their next attempt forward, so concurrent workers never send the same
+// delivery twice and deliveries of a crashed worker are retried.
+func (r webhookRepo) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*model.WebhookDelivery, error) {
+	deliveries := []*model.WebhookDelivery{}
+	now := time.Now()
+	err := r.dbStore.DB().WithContext(ctx).Raw(`
+		UPDATE "webhook_deliveries" SET "next_attempt_at" = ?
+		WHERE "id" IN (
+			SELECT "id" FROM "webhook_deliveries"
+			WHERE "status" = ? AND "next_attempt_at" <= ?
+			ORDER BY "next_attempt_at"
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		now.Add(lease), enum.WebhookDeliveryStatusPending, now, limit,
+	).Scan(&deliveries).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return deliveries, nil
+}
+
+func (r webhookRepo) UpdateDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	err := r.dbStore.DB().
+		WithContext(ctx).
+		Model(delivery).
+		Select("status", "attempts", "next_attempt_at", "response_status", "last_error", "delivered_at").
+		Updates(delivery).
+		Error
+
+	return dbError(err)
+}
+
+func (r webhookRepo) ListDeliveries(
+	ctx context.Context,
+	endpointId uid.Identifier,
+	before uid.Identifier,
+	limit int,
+) ([]*model.WebhookDelivery, error) {
+	query := r.dbStore.DB().WithContext(ctx).Where(`"endpoint_id" = ?`, endpointId)
+	if before != nil {
+		query = query.Where(`"id" < ?`, before)
+	}
+	if limit <= 0 || limit > maxWebhookDeliveryLimit {
+		limit = maxWebhookDeliveryLimit
+	}
+	deliveries := []*model.WebhookDelivery{}
+	err := query.Order(`"id" DESC`).Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return deliveries, nil
+}
+
+// ListDeliveriesByUser returns every delivery of the events about the user.
+func (r webhookRepo) ListDeliveriesByUser(ctx context.Context, userId uid.Identifier) ([]*model.WebhookDelivery, error) {
+	deliveries := []*model.WebhookDelivery{}
+	err := r.dbStore.DB().WithContext(ctx).Where(`"user_id" = ?`, userId).Order(`"id"`).Find(&deliveries).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return deliveries, nil
+}
+
+// DeleteDeliveriesByUser deletes the deliveries of the events of the user,
+// their payloads carrying its data, including those still pending.
+func (r webhookRepo) DeleteDeliveriesByUser(ctx context.Context, userId uid.Identifier) error {
+	err := r.dbStore.DB().WithContext(ctx).Where(`"user_id" = ?`, userId).Delete(&model.WebhookDelivery{}).Error
+
+	return dbError(err)
+}
+
+// Replay queues a new delivery of the same event to the same endpoint. The
+// original delivery is left untouched so the log keeps every attempt.
+func (r webhookRepo) Replay(ctx context.Context, id uid.Identifier) (*model.WebhookDelivery, error) {
+	original := &model.WebhookDelivery{}
+	err := r.dbStore.DB().WithContext(ctx).Find(original, id).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if original.ID == nil {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	now := time.Now()
+	delivery, err := r.newDelivery(model.WebhookDelivery{
+		EndpointID:    original.EndpointID,
+		UserID:        original.UserID,
+		EventID:       original.EventID,
+		EventType:     original.EventType,
+		Payload:       original.Payload,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.dbStore.DB().WithContext(ctx).Create(delivery).Error
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return delivery, nil
+}
+
+func (r webhookRepo) newDelivery(options model.WebhookDelivery) (*model.WebhookDelivery, error) {
+	id, err := r.idGenerator.NextFromFieldTag(options, uid.FieldNameID)
+	if err != nil {
+		return nil, err
+	}
+	options.ID = id
+	options.Status = enum.WebhookDeliveryStatusPending
+
+	return &options, nil
+}
@@ -3,44 +3,165 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"math"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/nkbhasker/go-auth-starter/internal/health"
 	"github.com/redis/go-redis/v9"
 )
 
+var (
+	ErrCacheMiss = errors.New("cache miss")
+	ErrWrongType = errors.New("key holds the wrong kind of value")
+)
+
+// ZMember is a member of a sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// CacheStore is a key value store with expiring keys. Values are strings,
+// structs are stored as json, or sorted sets. Operating on a key holding the
+// other kind of value returns ErrWrongType.
 type CacheStore interface {
 	health.Checker
-	DB() *redis.Client
 	CloseDB() error
+	// WithTTL returns the store writing keys that expire after ttl, zero
+	// meaning never
 	WithTTL(ttl time.Duration) CacheStore
+	// WithKeepTTL returns the store keeping the expiry of overwritten keys
 	WithKeepTTL() CacheStore
 	Set(ctx context.Context, key string, value interface{}) error
-	Get(ctx context.Context, key string) error
+	// Get returns ErrCacheMiss when key doesn't exist
+	Get(ctx context.Context, key string) (string, error)
+	// GetDel gets and deletes key at once, returning ErrCacheMiss when key
+	// doesn't exist
+	GetDel(ctx context.Context, key string) (string, error)
+	Del(ctx context.Context, keys ...string) error
+	// Keys returns the keys matching pattern, where * matches any sequence
+	// of characters
+	Keys(ctx context.Context, pattern string) ([]string, error)
+	// CompareAndSwap sets key to new only if its value is old, keeping its
+	// expiry. It reports whether the value was swapped.
+	CompareAndSwap(ctx context.Context, key string, old string, new string) (bool, error)
+	// CompareAndDelete deletes key only if its value is old. It reports
+	// whether the key was deleted.
+	CompareAndDelete(ctx context.Context, key string, old string) (bool, error)
+	// Sorted sets order their members by score, then member. Writes set the
+	// expiry of the key to the ttl of the store when it has one, otherwise
+	// keep it, and a set is deleted with its last member.
+
+	// ZAdd adds members or updates their scores
+	ZAdd(ctx context.Context, key string, members ...ZMember) error
+	// ZAddGT adds members or updates their scores when greater than the
+	// current ones
+	ZAddGT(ctx context.Context, key string, members ...ZMember) error
+	// ZIncrBy adds increment to the score of member, starting from zero,
+	// and returns the new score
+	ZIncrBy(ctx context.Context, key string, member string, increment float64) (float64, error)
+	ZRem(ctx context.Context, key string, members ...string) error
+	// ZRemRangeByScore removes the members scored between min and max
+	// included, which can be infinite
+	ZRemRangeByScore(ctx context.Context, key string, min float64, max float64) error
+	ZCard(ctx context.Context, key string) (int, error)
+	// ZRangeWithScores returns the members ranked from start to stop
+	// included, negative ranks counting from the last member
+	ZRangeWithScores(ctx context.Context, key string, start int, stop int) ([]ZMember, error)
+}
+
+// RedisCacheStore is a CacheStore backed by redis, giving access to the
+// client for what the CacheStore can't express, e.g. scripts. Keys used
+// together by an operation must share a hash tag, e.g. atk_{usr_1}_*, to
+// stay in one slot with redis cluster.
+type RedisCacheStore interface {
+	CacheStore
+	DB() redis.UniversalClient
 }
 
+type RedisModeEnum string
+
+const (
+	RedisModeStandalone RedisModeEnum = "standalone"
+	RedisModeSentinel   RedisModeEnum = "sentinel"
+	RedisModeCluster    RedisModeEnum = "cluster"
+)
+
+type RedisOptions struct {
+	Mode RedisModeEnum
+	// Url of the server in standalone mode
+	Url string
+	// Sentinel addresses in sentinel mode, seed nodes in cluster mode
+	Addrs            []string
+	MasterName       string
+	Username         string
+	Password         string
+	SentinelPassword string
+	DB               int
+}
+
+var compareAndSwapScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+	return 1
+end
+return 0
+`)
+
+var compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
 type cacheStore struct {
-	db  *redis.Client
+	db  redis.UniversalClient
 	ttl time.Duration
 }
 
-func InitCacheStore(redisUrl string) (CacheStore, error) {
-	opt, err := redis.ParseURL(redisUrl)
-	if err != nil {
-		return nil, err
+func InitCacheStore(options RedisOptions) (RedisCacheStore, error) {
+	var client redis.UniversalClient
+	switch options.Mode {
+	case RedisModeSentinel:
+		// Failover to the replica promoted by the sentinels
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       options.MasterName,
+			SentinelAddrs:    options.Addrs,
+			SentinelPassword: options.SentinelPassword,
+			Username:         options.Username,
+			Password:         options.Password,
+			DB:               options.DB,
+		})
+	case RedisModeCluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    options.Addrs,
+			Username: options.Username,
+			Password: options.Password,
+		})
+	default:
+		opt, err := redis.ParseURL(options.Url)
+		if err != nil {
+			return nil, err
+		}
+		client = redis.NewClient(opt)
 	}
-	client := redis.NewClient(opt)
 
-	err = client.Ping(context.Background()).Err()
+	err := client.Ping(context.Background()).Err()
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
 
 	return &cacheStore{db: client}, nil
 }
 
-func (s cacheStore) DB() *redis.Client {
+func (s cacheStore) DB() redis.UniversalClient {
 	return s.db
 }
 
@@ -57,8 +178,150 @@ func (s *cacheStore) Set(ctx context.Context, key string, value interface{}) err
 	return s.db.Set(ctx, key, v, s.ttl).Err()
 }
 
-func (s *cacheStore) Get(ctx context.Context, key string) error {
-	return s.db.Get(ctx, key).Err()
+func (s *cacheStore) Get(ctx context.Context, key string) (string, error) {
+	value, err := s.db.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", ErrCacheMiss
+	}
+
+	return value, redisError(err)
+}
+
+func (s *cacheStore) GetDel(ctx context.Context, key string) (string, error) {
+	value, err := s.db.GetDel(ctx, key).Result()
+	if err == redis.Nil {
+		return "", ErrCacheMiss
+	}
+
+	return value, redisError(err)
+}
+
+func (s *cacheStore) Del(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return s.db.Del(ctx, keys...).Err()
+}
+
+func (s *cacheStore) Keys(ctx context.Context, pattern string) ([]string, error) {
+	cluster, ok := s.db.(*redis.ClusterClient)
+	if !ok {
+		return scanKeys(ctx, s.db, pattern)
+	}
+	// Each master only scans its own slots
+	mu := sync.Mutex{}
+	keys := []string{}
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		found, err := scanKeys(ctx, client, pattern)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		keys = append(keys, found...)
+		mu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s *cacheStore) CompareAndSwap(ctx context.Context, key string, old string, new string) (bool, error) {
+	swapped, err := compareAndSwapScript.Run(ctx, s.db, []string{key}, old, new).Int()
+
+	return swapped == 1, redisError(err)
+}
+
+func (s *cacheStore) CompareAndDelete(ctx context.Context, key string, old string) (bool, error) {
+	deleted, err := compareAndDeleteScript.Run(ctx, s.db, []string{key}, old).Int()
+
+	return deleted == 1, redisError(err)
+}
+
+func (s *cacheStore) ZAdd(ctx context.Context, key string, members ...ZMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	return s.zWrite(ctx, key, func(pipe redis.Pipeliner) {
+		pipe.ZAdd(ctx, key, toZ(members)...)
+	})
+}
+
+func (s *cacheStore) ZAddGT(ctx context.Context, key string, members ...ZMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	return s.zWrite(ctx, key, func(pipe redis.Pipeliner) {
+		pipe.ZAddGT(ctx, key, toZ(members)...)
+	})
+}
+
+func (s *cacheStore) ZIncrBy(ctx context.Context, key string, member string, increment float64) (float64, error) {
+	var cmd *redis.FloatCmd
+	err := s.zWrite(ctx, key, func(pipe redis.Pipeliner) {
+		cmd = pipe.ZIncrBy(ctx, key, increment, member)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return cmd.Val(), nil
+}
+
+func (s *cacheStore) ZRem(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+
+	return redisError(s.db.ZRem(ctx, key, values...).Err())
+}
+
+func (s *cacheStore) ZRemRangeByScore(ctx context.Context, key string, min float64, max float64) error {
+	return redisError(s.db.ZRemRangeByScore(ctx, key, formatScore(min), formatScore(max)).Err())
+}
+
+func (s *cacheStore) ZCard(ctx context.Context, key string) (int, error) {
+	count, err := s.db.ZCard(ctx, key).Result()
+
+	return int(count), redisError(err)
+}
+
+func (s *cacheStore) ZRangeWithScores(ctx context.Context, key string, start int, stop int) ([]ZMember, error) {
+	zs, err := s.db.ZRangeWithScores(ctx, key, int64(start), int64(stop)).Result()
+	if err != nil {
+		return nil, redisError(err)
+	}
+	members := make([]ZMember, len(zs))
+	for i, z := range zs {
+		members[i] = ZMember{Member: z.Member.(string), Score: z.Score}
+	}
+
+	return members, nil
+}
+
+// zWrite runs write in a transaction that then sets the expiry of key when
+// the store has a ttl.
+func (s *cacheStore) zWrite(ctx context.Context, key string, write func(pipe redis.Pipeliner)) error {
+	_, err := s.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		write(pipe)
+		if s.ttl > 0 {
+			pipe.PExpire(ctx, key, s.ttl)
+		}
+
+		return nil
+	})
+
+	return redisError(err)
 }
 
 func (s cacheStore) WithTTL(ttl time.Duration) CacheStore {
@@ -77,18 +340,74 @@ func (s cacheStore) WithKeepTTL() CacheStore {
 
 func (s *cacheStore) Check() *health.Health {
 	h := health.NewHealth()
-	res := s.db.InfoMap(context.Background(), "server")
-	if res.Err() != nil {
+	info, err := s.db.Info(context.Background(), "server").Result()
+	if err != nil {
 		h.SetStatus(health.HealthStatusDown)
-		h.SetInfo("error", res.Err().Error())
+		h.SetInfo("error", err.Error())
 	} else {
 		h.SetStatus(health.HealthStatusUp)
-		h.SetInfo("version", res.Val()["Server"]["redis_version"])
+		h.SetInfo("version", infoValue(info, "redis_version"))
 	}
 
 	return h
 }
 
+// infoValue returns the value of field in the output of INFO, a line per
+// field formatted as field:value.
+func infoValue(info string, field string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), field+":"); ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// redisError returns ErrWrongType for the WRONGTYPE errors of redis, also
+// raised from scripts, err otherwise.
+func redisError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "WRONGTYPE") {
+		return ErrWrongType
+	}
+
+	return err
+}
+
+func toZ(members []ZMember) []redis.Z {
+	zs := make([]redis.Z, len(members))
+	for i, member := range members {
+		zs[i] = redis.Z{Score: member.Score, Member: member.Member}
+	}
+
+	return zs
+}
+
+// formatScore formats score as a bound of a score range.
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "+inf"
+	}
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+func scanKeys(ctx context.Context, client redis.Cmdable, pattern string) ([]string, error) {
+	keys := []string{}
+	iter := client.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
 func toValue(value interface{}) (interface{}, error) {
 	k := reflect.Indirect(reflect.ValueOf(value)).Kind()
 	if k == reflect.Struct {
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+var testBackends = []string{"memory", "redis"}
+
+func newTestCacheStore(t *testing.T, backend string) CacheStore {
+	t.Helper()
+	var cacheStore CacheStore
+	if backend == "redis" {
+		server := miniredis.RunT(t)
+		redisStore, err := InitCacheStore(RedisOptions{Url: "redis://" + server.Addr()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cacheStore = redisStore
+	} else {
+		cacheStore = NewMemoryCacheStore()
+	}
+	t.Cleanup(func() {
+		cacheStore.CloseDB()
+	})
+
+	return cacheStore
+}
+
+func TestCacheStoreValues(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			cacheStore := newTestCacheStore(t, backend)
+			_, err := cacheStore.Get(ctx, "missing")
+			if !errors.Is(err, ErrCacheMiss) {
+				t.Fatalf("Get() error = %v, want %v", err, ErrCacheMiss)
+			}
+			values := []struct {
+				key   string
+				value interface{}
+				want  string
+			}{
+				{"string", "value", "value"},
+				{"int", 42, "42"},
+				{"struct", struct {
+					Name string `json:"name"`
+				}{"jane"}, `{"name":"jane"}`},
+			}
+			for _, v := range values {
+				err := cacheStore.Set(ctx, v.key, v.value)
+				if err != nil {
+					t.Fatalf("Set(%q) error = %v", v.key, err)
+				}
+				got, err := cacheStore.Get(ctx, v.key)
+				if err != nil || got != v.want {
+					t.Errorf("Get(%q) = %q, %v, want %q", v.key, got, err, v.want)
+				}
+			}
+			got, err := cacheStore.GetDel(ctx, "string")
+			if err != nil || got != "value" {
+				t.Errorf("GetDel() = %q, %v, want %q", got, err, "value")
+			}
+			_, err = cacheStore.GetDel(ctx, "string")
+			if !errors.Is(err, ErrCacheMiss) {
+				t.Errorf("GetDel() of a deleted key error = %v, want %v", err, ErrCacheMiss)
+			}
+			err = cacheStore.Del(ctx, "int", "struct", "missing")
+			if err != nil {
+				t.Fatalf("Del() error = %v", err)
+			}
+			keys, err := cacheStore.Keys(ctx, "*")
+			if err != nil || len(keys) != 0 {
+				t.Errorf("Keys() = %v, %v, want none", keys, err)
+			}
+		})
+	}
+}
+
+func TestCacheStoreKeys(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			cacheStore := newTestCacheStore(t, backend)
+			for _, key := range []string{"atk_{usr_1}_a", "atk_{usr_1}_b", "atk_{usr_2}_a", "rtk_{usr_1}_a"} {
+				err := cacheStore.Set(ctx, key, "1")
+				if err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+			}
+			err := cacheStore.ZAdd(ctx, "atk_{usr_1}_z", ZMember{Member: "a", Score: 1})
+			if err != nil {
+				t.Fatalf("ZAdd() error = %v", err)
+			}
+			keys, err := cacheStore.Keys(ctx, "atk_{usr_1}_*")
+			if err != nil {
+				t.Fatalf("Keys() error = %v", err)
+			}
+			sort.Strings(keys)
+			want := []string{"atk_{usr_1}_a", "atk_{usr_1}_b", "atk_{usr_1}_z"}
+			if !reflect.DeepEqual(keys, want) {
+				t.Errorf("Keys() = %v, want %v", keys, want)
+			}
+		})
+	}
+}
+
+func TestCacheStoreCompareAndSwap(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			cacheStore := newTestCacheStore(t, backend)
+			err := cacheStore.Set(ctx, "key", "old")
+			if err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			tests := []struct {
+				name string
+				old  string
+				new  string
+				want bool
+			}{
+				{"matching", "old", "new", true},
+				{"stale", "old", "newer", false},
+				{"missing", "", "new", false},
+			}
+			for _, tt := range tests {
+				key := "key"
+				if tt.name == "missing" {
+					key = "missing"
+				}
+				swapped, err := cacheStore.CompareAndSwap(ctx, key, tt.old, tt.new)
+				if err != nil || swapped != tt.want {
+					t.Errorf("CompareAndSwap() %s = %v, %v, want %v", tt.name, swapped, err, tt.want)
+				}
+			}
+			deleted, err := cacheStore.CompareAndDelete(ctx, "key", "old")
+			if err != nil || deleted {
+				t.Errorf("CompareAndDelete() of a stale value = %v, %v, want false", deleted, err)
+			}
+			deleted, err = cacheStore.CompareAndDelete(ctx, "key", "new")
+			if err != nil || !deleted {
+				t.Errorf("CompareAndDelete() = %v, %v, want true", deleted, err)
+			}
+			_, err = cacheStore.Get(ctx, "key")
+			if !errors.Is(err, ErrCacheMiss) {
+				t.Errorf("Get() after CompareAndDelete() error = %v, want %v", err, ErrCacheMiss)
+			}
+		})
+	}
+}
+
+func TestCacheStoreSortedSets(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			cacheStore := newTestCacheStore(t, backend).WithTTL(time.Minute)
+			err := cacheStore.ZAdd(ctx, "zset",
+				ZMember{Member: "c", Score: 3},
+				ZMember{Member: "b", Score: 1},
+				ZMember{Member: "a", Score: 1},
+				ZMember{Member: "d", Score: 4.5},
+			)
+			if err != nil {
+				t.Fatalf("ZAdd() error = %v", err)
+			}
+			ranges := []struct {
+				start int
+				stop  int
+				want  []ZMember
+			}{
+				// Members of equal score are ordered by member
+				{0, 0, []ZMember{{"a", 1}}},
+				{0, -1, []ZMember{{"a", 1}, {"b", 1}, {"c", 3}, {"d", 4.5}}},
+				{-2, 10, []ZMember{{"c", 3}, {"d", 4.5}}},
+				{3, 1, []ZMember{}},
+				{5, 6, []ZMember{}},
+			}
+			for _, r := range ranges {
+				got, err := cacheStore.ZRangeWithScores(ctx, "zset", r.start, r.stop)
+				if err != nil {
+					t.Fatalf("ZRangeWithScores() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, r.want) {
+					t.Errorf("ZRangeWithScores(%d, %d) = %v, want %v", r.start, r.stop, got, r.want)
+				}
+			}
+
+			err = cacheStore.ZAddGT(ctx, "zset", ZMember{Member: "a", Score: 0}, ZMember{Member: "b", Score: 2}, ZMember{Member: "e", Score: 5})
+			if err != nil {
+				t.Fatalf("ZAddGT() error = %v", err)
+			}
+			score, err := cacheStore.ZIncrBy(ctx, "zset", "c", -0.5)
+			if err != nil || score != 2.5 {
+				t.Errorf("ZIncrBy() = %v, %v, want 2.5", score, err)
+			}
+			score, err = cacheStore.ZIncrBy(ctx, "zset", "f", 6)
+			if err != nil || score != 6 {
+				t.Errorf("ZIncrBy() of a new member = %v, %v, want 6", score, err)
+			}
+			got, err := cacheStore.ZRangeWithScores(ctx, "zset", 0, -1)
+			if err != nil {
+				t.Fatalf("ZRangeWithScores() error = %v", err)
+			}
+			want := []ZMember{{"a", 1}, {"b", 2}, {"c", 2.5}, {"d", 4.5}, {"e", 5}, {"f", 6}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ZRangeWithScores() = %v, want %v", got, want)
+			}
+
+			err = cacheStore.ZRemRangeByScore(ctx, "zset", math.Inf(-1), 2)
+			if err != nil {
+				t.Fatalf("ZRemRangeByScore() error = %v", err)
+			}
+			err = cacheStore.ZRem(ctx, "zset", "d", "missing")
+			if err != nil {
+				t.Fatalf("ZRem() error = %v", err)
+			}
+			count, err := cacheStore.ZCard(ctx, "zset")
+			if err != nil || count != 3 {
+				t.Errorf("ZCard() = %d, %v, want 3", count, err)
+			}
+
+			// Sets are deleted with their last member
+			err = cacheStore.ZRemRangeByScore(ctx, "zset", 0, math.Inf(1))
+			if err != nil {
+				t.Fatalf("ZRemRangeByScore() error = %v", err)
+			}
+			keys, err := cacheStore.Keys(ctx, "*")
+			if err != nil || len(keys) != 0 {
+				t.Errorf("Keys() = %v, %v, want none", keys, err)
+			}
+			count, err = cacheStore.ZCard(ctx, "zset")
+			if err != nil || count != 0 {
+				t.Errorf("ZCard() of a missing key = %d, %v, want 0", count, err)
+			}
+		})
+	}
+}
+
+func TestCacheStoreWrongType(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			cacheStore := newTestCacheStore(t, backend)
+			err := cacheStore.Set(ctx, "string", "value")
+			if err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			err = cacheStore.ZAdd(ctx, "zset", ZMember{Member: "a", Score: 1})
+			if err != nil {
+				t.Fatalf("ZAdd() error = %v", err)
+			}
+			if err := cacheStore.ZAdd(ctx, "string", ZMember{Member: "a", Score: 1}); !errors.Is(err, ErrWrongType) {
+				t.Errorf("ZAdd() of a string error = %v, want %v", err, ErrWrongType)
+			}
+			if _, err := cacheStore.ZIncrBy(ctx, "string", "a", 1); !errors.Is(err, ErrWrongType) {
+				t.Errorf("ZIncrBy() of a string error = %v, want %v", err, ErrWrongType)
+			}
+			if _, err := cacheStore.ZCard(ctx, "string"); !errors.Is(err, ErrWrongType) {
+				t.Errorf("ZCard() of a string error = %v, want %v", err, ErrWrongType)
+			}
+			if _, err := cacheStore.Get(ctx, "zset"); !errors.Is(err, ErrWrongType) {
+				t.Errorf("Get() of a sorted set error = %v, want %v", err, ErrWrongType)
+			}
+			if _, err := cacheStore.CompareAndSwap(ctx, "zset", "a", "b"); !errors.Is(err, ErrWrongType) {
+				t.Errorf("CompareAndSwap() of a sorted set error = %v, want %v", err, ErrWrongType)
+			}
+			// Set overwrites any kind of value
+			err = cacheStore.Set(ctx, "zset", "value")
+			if err != nil {
+				t.Fatalf("Set() of a sorted set error = %v", err)
+			}
+		})
+	}
+}
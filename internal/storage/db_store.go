@@ -1,42 +1,142 @@
 package storage
 
 import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/nkbhasker/go-auth-starter/internal/health"
 	"github.com/nkbhasker/go-auth-starter/internal/uid"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
+	"gorm.io/plugin/dbresolver"
 )
 
 type DBStore interface {
 	health.Checker
+	// DB runs every statement on the primary
 	DB() *gorm.DB
+	// ReadDB runs reads on a replica when there are any, which may lag
+	// behind the primary. Use it where slightly stale data is fine.
+	ReadDB() *gorm.DB
 	CloseDB() error
 	WithTx(tx *gorm.DB) DBStore
+	// WithPrimary returns the store running reads on the primary too
+	WithPrimary() DBStore
+	// Pools returns the connection pools by name, primary and replica_<n>
+	// in the order of the replica urls
+	Pools() map[string]*sql.DB
+}
+
+type DBOptions struct {
+	Url         string
+	ReplicaUrls []string
+	// Limits of each connection pool, the primary's and every replica's
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// Statements running longer are cancelled by postgres, zero for no limit
+	StatementTimeout time.Duration
+	// silent, error, warn or info
+	LogLevel string
 }
 
 type dbStore struct {
-	db *gorm.DB
+	db       *gorm.DB
+	readDB   *gorm.DB
+	resolver *dbresolver.DBResolver
+	pools    map[string]*sql.DB
 }
 
 func init() {
 	registerSerializers()
 }
 
-func InitDBStore(postgresUrl string) (DBStore, error) {
-	db, err := gorm.Open(postgres.Open(postgresUrl), &gorm.Config{})
+func InitDBStore(options DBOptions) (DBStore, error) {
+	logLevel, err := parseLogLevel(options.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := openPostgres(options.Url, options.StatementTimeout)
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: primary}), &gorm.Config{
+		Logger: logger.Default.LogMode(logLevel),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(options.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(options.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(options.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(options.ConnMaxIdleTime)
+	pools := map[string]*sql.DB{"primary": sqlDB}
+	if len(options.ReplicaUrls) == 0 {
+		return &dbStore{db: db, readDB: db, pools: pools}, nil
+	}
+
+	replicas := make([]gorm.Dialector, len(options.ReplicaUrls))
+	for i, url := range options.ReplicaUrls {
+		replica, err := openPostgres(url, options.StatementTimeout)
+		if err != nil {
+			return nil, err
+		}
+		replicas[i] = postgres.New(postgres.Config{Conn: replica})
+		pools[fmt.Sprintf("replica_%d", i)] = replica
+	}
+	// Reads go to a random replica unless forced on the primary
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: replicas,
+		Policy:   dbresolver.RandomPolicy{},
+	}).
+		SetMaxOpenConns(options.MaxOpenConns).
+		SetMaxIdleConns(options.MaxIdleConns).
+		SetConnMaxLifetime(options.ConnMaxLifetime).
+		SetConnMaxIdleTime(options.ConnMaxIdleTime)
+	err = db.Use(resolver)
 	if err != nil {
 		return nil, err
 	}
 
-	return &dbStore{db: db}, nil
+	return &dbStore{
+		// A session keeps the write clause on every statement chained from it
+		db:       db.Clauses(dbresolver.Write).Session(&gorm.Session{}),
+		readDB:   db,
+		resolver: resolver,
+		pools:    pools,
+	}, nil
 }
 
 func (s dbStore) DB() *gorm.DB {
 	return s.db
 }
 
+func (s dbStore) ReadDB() *gorm.DB {
+	return s.readDB
+}
+
 func (s *dbStore) CloseDB() error {
+	if s.resolver != nil {
+		// Closes the replicas along with the primary
+		return s.resolver.Call(func(connPool gorm.ConnPool) error {
+			if sqlDB, ok := connPool.(interface{ Close() error }); ok {
+				return sqlDB.Close()
+			}
+
+			return nil
+		})
+	}
 	sqlDB, err := s.db.DB()
 	if err != nil {
 		return err
@@ -46,10 +146,25 @@ func (s *dbStore) CloseDB() error {
 
 func (s dbStore) WithTx(tx *gorm.DB) DBStore {
 	return &dbStore{
-		db: tx,
+		db:     tx,
+		readDB: tx,
+		pools:  s.pools,
+	}
+}
+
+func (s dbStore) WithPrimary() DBStore {
+	return &dbStore{
+		db:       s.db,
+		readDB:   s.db,
+		resolver: s.resolver,
+		pools:    s.pools,
 	}
 }
 
+func (s dbStore) Pools() map[string]*sql.DB {
+	return s.pools
+}
+
 func (s *dbStore) Check() *health.Health {
 	var version string
 	h := health.NewHealth()
@@ -64,6 +179,35 @@ func (s *dbStore) Check() *health.Health {
 	return h
 }
 
+// openPostgres returns a connection pool to the database at url, setting
+// the statement timeout of its connections.
+func openPostgres(url string, statementTimeout time.Duration) (*sql.DB, error) {
+	config, err := pgx.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	if statementTimeout > 0 {
+		config.RuntimeParams["statement_timeout"] = strconv.FormatInt(statementTimeout.Milliseconds(), 10)
+	}
+
+	return stdlib.OpenDB(*config), nil
+}
+
+func parseLogLevel(level string) (logger.LogLevel, error) {
+	switch level {
+	case "silent":
+		return logger.Silent, nil
+	case "error":
+		return logger.Error, nil
+	case "", "warn":
+		return logger.Warn, nil
+	case "info":
+		return logger.Info, nil
+	default:
+		return 0, fmt.Errorf("unknown db log level %q", level)
+	}
+}
+
 func registerSerializers() {
 	schema.RegisterSerializer("id", uid.NewIdSerializer())
 }
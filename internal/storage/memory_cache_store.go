@@ -0,0 +1,424 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nkbhasker/go-auth-starter/internal/health"
+)
+
+const (
+	memoryCacheShards = 32
+	// Expired keys are invisible right away and reclaimed by a sweep
+	memoryCacheSweepInterval = time.Minute
+)
+
+// memoryCacheEntry holds a string value, or a sorted set when zset isn't
+// nil.
+type memoryCacheEntry struct {
+	value     string
+	zset      map[string]float64
+	expiresAt time.Time
+}
+
+func (e memoryCacheEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+type memoryCacheShard struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheData struct {
+	shards [memoryCacheShards]*memoryCacheShard
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type memoryCacheStore struct {
+	data    *memoryCacheData
+	ttl     time.Duration
+	keepTTL bool
+}
+
+// NewMemoryCacheStore returns a CacheStore held in process, for single node
+// deployments and tests. Keys are spread over sharded maps to limit lock
+// contention.
+func NewMemoryCacheStore() CacheStore {
+	data := &memoryCacheData{stop: make(chan struct{})}
+	for i := range data.shards {
+		data.shards[i] = &memoryCacheShard{entries: map[string]memoryCacheEntry{}}
+	}
+	go data.sweep()
+
+	return &memoryCacheStore{data: data}
+}
+
+func (s *memoryCacheStore) CloseDB() error {
+	s.data.once.Do(func() {
+		close(s.data.stop)
+	})
+
+	return nil
+}
+
+func (s memoryCacheStore) WithTTL(ttl time.Duration) CacheStore {
+	return &memoryCacheStore{
+		data: s.data,
+		ttl:  ttl,
+	}
+}
+
+func (s memoryCacheStore) WithKeepTTL() CacheStore {
+	return &memoryCacheStore{
+		data:    s.data,
+		keepTTL: true,
+	}
+}
+
+func (s *memoryCacheStore) Set(ctx context.Context, key string, value interface{}) error {
+	v, err := toValue(value)
+	if err != nil {
+		return err
+	}
+	str := toString(v)
+	now := time.Now()
+	shard := s.data.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry := memoryCacheEntry{value: str}
+	if current, ok := shard.entries[key]; ok && s.keepTTL && !current.expired(now) {
+		entry.expiresAt = current.expiresAt
+	} else if s.ttl > 0 {
+		entry.expiresAt = now.Add(s.ttl)
+	}
+	shard.entries[key] = entry
+
+	return nil
+}
+
+func (s *memoryCacheStore) Get(ctx context.Context, key string) (string, error) {
+	shard := s.data.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry, ok := shard.get(key, time.Now())
+	if !ok {
+		return "", ErrCacheMiss
+	}
+	if entry.zset != nil {
+		return "", ErrWrongType
+	}
+
+	return entry.value, nil
+}
+
+func (s *memoryCacheStore) GetDel(ctx context.Context, key string) (string, error) {
+	shard := s.data.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry, ok := shard.get(key, time.Now())
+	if !ok {
+		return "", ErrCacheMiss
+	}
+	if entry.zset != nil {
+		return "", ErrWrongType
+	}
+	delete(shard.entries, key)
+
+	return entry.value, nil
+}
+
+func (s *memoryCacheStore) Del(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		shard := s.data.shard(key)
+		shard.mu.Lock()
+		delete(shard.entries, key)
+		shard.mu.Unlock()
+	}
+
+	return nil
+}
+
+func (s *memoryCacheStore) Keys(ctx context.Context, pattern string) ([]string, error) {
+	now := time.Now()
+	keys := []string{}
+	for _, shard := range s.data.shards {
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			if !entry.expired(now) && matchPattern(pattern, key) {
+				keys = append(keys, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+
+	return keys, nil
+}
+
+func (s *memoryCacheStore) CompareAndSwap(ctx context.Context, key string, old string, new string) (bool, error) {
+	shard := s.data.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry, ok := shard.get(key, time.Now())
+	if ok && entry.zset != nil {
+		return false, ErrWrongType
+	}
+	if !ok || entry.value != old {
+		return false, nil
+	}
+	entry.value = new
+	shard.entries[key] = entry
+
+	return true, nil
+}
+
+func (s *memoryCacheStore) CompareAndDelete(ctx context.Context, key string, old string) (bool, error) {
+	shard := s.data.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry, ok := shard.get(key, time.Now())
+	if ok && entry.zset != nil {
+		return false, ErrWrongType
+	}
+	if !ok || entry.value != old {
+		return false, nil
+	}
+	delete(shard.entries, key)
+
+	return true, nil
+}
+
+func (s *memoryCacheStore) ZAdd(ctx context.Context, key string, members ...ZMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	return s.withZSet(key, true, func(zset map[string]float64) {
+		for _, member := range members {
+			zset[member.Member] = member.Score
+		}
+	})
+}
+
+func (s *memoryCacheStore) ZAddGT(ctx context.Context, key string, members ...ZMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	return s.withZSet(key, true, func(zset map[string]float64) {
+		for _, member := range members {
+			if score, ok := zset[member.Member]; !ok || member.Score > score {
+				zset[member.Member] = member.Score
+			}
+		}
+	})
+}
+
+func (s *memoryCacheStore) ZIncrBy(ctx context.Context, key string, member string, increment float64) (float64, error) {
+	var score float64
+	err := s.withZSet(key, true, func(zset map[string]float64) {
+		score = zset[member] + increment
+		zset[member] = score
+	})
+
+	return score, err
+}
+
+func (s *memoryCacheStore) ZRem(ctx context.Context, key string, members ...string) error {
+	return s.withZSet(key, false, func(zset map[string]float64) {
+		for _, member := range members {
+			delete(zset, member)
+		}
+	})
+}
+
+func (s *memoryCacheStore) ZRemRangeByScore(ctx context.Context, key string, min float64, max float64) error {
+	return s.withZSet(key, false, func(zset map[string]float64) {
+		for member, score := range zset {
+			if score >= min && score <= max {
+				delete(zset, member)
+			}
+		}
+	})
+}
+
+func (s *memoryCacheStore) ZCard(ctx context.Context, key string) (int, error) {
+	count := 0
+	err := s.withZSet(key, false, func(zset map[string]float64) {
+		count = len(zset)
+	})
+
+	return count, err
+}
+
+func (s *memoryCacheStore) ZRangeWithScores(ctx context.Context, key string, start int, stop int) ([]ZMember, error) {
+	members := []ZMember{}
+	err := s.withZSet(key, false, func(zset map[string]float64) {
+		n := len(zset)
+		if start < 0 {
+			start = max(0, start+n)
+		}
+		if stop < 0 {
+			stop += n
+		}
+		stop = min(stop, n-1)
+		if start > stop {
+			return
+		}
+		for member, score := range zset {
+			members = append(members, ZMember{Member: member, Score: score})
+		}
+		sort.Slice(members, func(i, j int) bool {
+			if members[i].Score != members[j].Score {
+				return members[i].Score < members[j].Score
+			}
+			return members[i].Member < members[j].Member
+		})
+		members = members[start : stop+1]
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// withZSet runs fn on the sorted set of key with its shard locked, an empty
+// set when key doesn't exist. Writes create the set and set its expiry to the
+// ttl of the store, sets left empty are deleted.
+func (s *memoryCacheStore) withZSet(key string, write bool, fn func(zset map[string]float64)) error {
+	now := time.Now()
+	shard := s.data.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	entry, ok := shard.get(key, now)
+	if ok && entry.zset == nil {
+		return ErrWrongType
+	}
+	if !ok {
+		entry = memoryCacheEntry{zset: map[string]float64{}}
+	}
+	fn(entry.zset)
+	if len(entry.zset) == 0 {
+		delete(shard.entries, key)
+		return nil
+	}
+	if write {
+		if s.ttl > 0 {
+			entry.expiresAt = now.Add(s.ttl)
+		}
+		shard.entries[key] = entry
+	}
+
+	return nil
+}
+
+func (s *memoryCacheStore) Check() *health.Health {
+	keys := 0
+	for _, shard := range s.data.shards {
+		shard.mu.Lock()
+		keys += len(shard.entries)
+		shard.mu.Unlock()
+	}
+
+	return health.NewHealth().
+		SetStatus(health.HealthStatusUp).
+		SetInfo("version", "memory").
+		SetInfo("keys", keys)
+}
+
+func (d *memoryCacheData) shard(key string) *memoryCacheShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return d.shards[h.Sum32()%memoryCacheShards]
+}
+
+func (d *memoryCacheData) sweep() {
+	ticker := time.NewTicker(memoryCacheSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now := <-ticker.C:
+			for _, shard := range d.shards {
+				shard.mu.Lock()
+				for key, entry := range shard.entries {
+					if entry.expired(now) {
+						delete(shard.entries, key)
+					}
+				}
+				shard.mu.Unlock()
+			}
+		}
+	}
+}
+
+// get returns the live entry of key, the shard must be locked.
+func (s *memoryCacheShard) get(key string, now time.Time) (memoryCacheEntry, bool) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return entry, false
+	}
+	if entry.expired(now) {
+		delete(s.entries, key)
+		return entry, false
+	}
+
+	return entry, true
+}
+
+// toString formats values the way redis stores them.
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// matchPattern reports whether str matches pattern, where * matches any
+// sequence of characters, including none.
+func matchPattern(pattern string, str string) bool {
+	p, s := 0, 0
+	star, match := -1, 0
+	for s < len(str) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, s
+			p++
+		case p < len(pattern) && pattern[p] == str[s]:
+			p++
+			s++
+		case star != -1:
+			// Let the last * swallow one more character
+			p = star + 1
+			match++
+			s = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
@@ -0,0 +1,8 @@
+package templates
+
+import "embed"
+
+// FS holds the default email templates compiled into the binary.
+//
+//go:embed *.html en es hi
+var FS embed.FS
@@ -14,8 +14,9 @@ type KindEnum string
 type FieldNameEnum string
 
 const (
-	KindUser    KindEnum      = "usr"
-	FieldNameID FieldNameEnum = "ID"
+	KindUser         KindEnum      = "usr"
+	KindWebhookEvent KindEnum      = "evt"
+	FieldNameID      FieldNameEnum = "ID"
 )
 
 type IdGenerator interface {
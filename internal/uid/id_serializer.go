@@ -19,6 +19,10 @@ func NewIdSerializer() IdSerializer {
 }
 
 func (idSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	// Nullable identifiers are left unset
+	if dbValue == nil && !field.NotNull && !field.PrimaryKey {
+		return nil
+	}
 	if dbValue == nil {
 		return errors.New("nil value")
 	}
@@ -37,6 +41,9 @@ func (idSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.V
 }
 
 func (idSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	if fieldValue == nil && !field.NotNull && !field.PrimaryKey {
+		return nil, nil
+	}
 	v, ok := fieldValue.(Identifier)
 	if !ok {
 		return nil, errors.New("invalid identifier")
@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderId        = "X-Webhook-Id"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const signatureVersion = "v1"
+
+type Sender interface {
+	Send(ctx context.Context, request Request) (int, error)
+}
+
+type Request struct {
+	URL       string
+	Secret    string
+	EventID   string
+	EventType string
+	Payload   []byte
+}
+
+type sender struct {
+	client *http.Client
+}
+
+func NewSender(timeoutInSeconds int) Sender {
+	return &sender{
+		client: &http.Client{
+			Timeout: time.Duration(timeoutInSeconds * int(time.Second)),
+		},
+	}
+}
+
+// Send posts the signed payload and returns the response status. Any non 2xx
+// response is treated as a failed delivery.
+func (s *sender) Send(ctx context.Context, request Request) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, request.URL, bytes.NewReader(request.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderId, request.EventID)
+	req.Header.Set(HeaderEvent, request.EventType)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(request.Secret, timestamp, request.Payload))
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected response status %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+// Sign returns the signature header value for a payload. Receivers recompute
+// HMAC-SHA256 over "<timestamp>.<payload>" with the shared secret and should
+// reject stale timestamps to prevent replays.
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return fmt.Sprintf("%s=%s", signatureVersion, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type Task func(ctx context.Context) error
+
+// Worker runs tasks periodically in the background until the context passed
+// to Start is cancelled.
+type Worker interface {
+	Schedule(name string, interval time.Duration, task Task)
+	Start(ctx context.Context)
+	Wait()
+}
+
+type job struct {
+	name     string
+	task     Task
+	interval time.Duration
+}
+
+type worker struct {
+	jobs []job
+	wg   sync.WaitGroup
+}
+
+func NewWorker() Worker {
+	return &worker{}
+}
+
+// Schedule registers a task to run every interval. It must be called before
+// Start.
+func (w *worker) Schedule(name string, interval time.Duration, task Task) {
+	w.jobs = append(w.jobs, job{
+		name:     name,
+		task:     task,
+		interval: interval,
+	})
+}
+
+func (w *worker) Start(ctx context.Context) {
+	for _, j := range w.jobs {
+		w.wg.Add(1)
+		go func(j job) {
+			defer w.wg.Done()
+			ticker := time.NewTicker(j.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					run(ctx, j)
+				}
+			}
+		}(j)
+	}
+}
+
+// Wait blocks until every running task has returned.
+func (w *worker) Wait() {
+	w.wg.Wait()
+}
+
+func run(ctx context.Context, j job) {
+	err := j.task(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "job failed", "job", j.name, "error", err)
+	}
+}
@@ -0,0 +1,4 @@
+-- Create enum type "user_status"
+CREATE TYPE "public"."user_status" AS ENUM ('ACTIVE', 'SUSPENDED', 'PENDING_DELETION');
+-- Modify "users" table
+ALTER TABLE "public"."users" ADD COLUMN "status" "public"."user_status" NOT NULL DEFAULT 'ACTIVE', ADD COLUMN "status_reason" text NULL, ADD COLUMN "suspended_until" timestamptz NULL;
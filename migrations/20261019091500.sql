@@ -0,0 +1,2 @@
+-- Modify "users" table
+ALTER TABLE "public"."users" ADD COLUMN "delete_after" timestamptz NULL;
@@ -0,0 +1,30 @@
+-- Create enum type "audit_event_type"
+CREATE TYPE "public"."audit_event_type" AS ENUM ('OTP_REQUESTED', 'OTP_VERIFIED', 'OTP_FAILED', 'SIGN_IN', 'TOKEN_ISSUED', 'TOKEN_REVOKED', 'EMAIL_CHANGED', 'PROFILE_UPDATED');
+-- Create enum type "audit_outcome"
+CREATE TYPE "public"."audit_outcome" AS ENUM ('SUCCESS', 'FAILURE');
+-- Create "audit_events" table
+CREATE TABLE "public"."audit_events" (
+  "id" bigint NOT NULL,
+  "user_id" bigint NULL,
+  "email" text NULL,
+  "type" "public"."audit_event_type" NOT NULL,
+  "outcome" "public"."audit_outcome" NOT NULL,
+  "ip" text NULL,
+  "user_agent" text NULL,
+  "reason" text NULL,
+  "created_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_audit_event_created_at" to table: "audit_events"
+CREATE INDEX "idx_audit_event_created_at" ON "public"."audit_events" ("created_at");
+-- Create index "idx_audit_event_email" to table: "audit_events"
+CREATE INDEX "idx_audit_event_email" ON "public"."audit_events" ("email");
+-- Create index "idx_audit_event_user_id" to table: "audit_events"
+CREATE INDEX "idx_audit_event_user_id" ON "public"."audit_events" ("user_id");
+-- Audit events are append only
+CREATE FUNCTION "public"."reject_audit_event_update"() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit events are append only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER "audit_events_append_only" BEFORE UPDATE ON "public"."audit_events" FOR EACH ROW EXECUTE FUNCTION "public"."reject_audit_event_update"();
@@ -0,0 +1,34 @@
+-- Create enum type "webhook_event_type"
+CREATE TYPE "public"."webhook_event_type" AS ENUM ('user.created', 'user.updated', 'email.changed', 'user.deleted');
+-- Create enum type "webhook_delivery_status"
+CREATE TYPE "public"."webhook_delivery_status" AS ENUM ('PENDING', 'SUCCEEDED', 'FAILED');
+-- Create "webhook_endpoints" table
+CREATE TABLE "public"."webhook_endpoints" (
+  "id" bigint NOT NULL,
+  "url" text NOT NULL,
+  "secret" text NOT NULL,
+  "events" jsonb NOT NULL,
+  "is_active" boolean NOT NULL,
+  "created_at" timestamptz NOT NULL,
+  PRIMARY KEY ("id")
+);
+-- Create "webhook_deliveries" table
+CREATE TABLE "public"."webhook_deliveries" (
+  "id" bigint NOT NULL,
+  "endpoint_id" bigint NOT NULL,
+  "event_id" text NOT NULL,
+  "event_type" "public"."webhook_event_type" NOT NULL,
+  "payload" jsonb NOT NULL,
+  "status" "public"."webhook_delivery_status" NOT NULL,
+  "attempts" bigint NOT NULL,
+  "next_attempt_at" timestamptz NOT NULL,
+  "response_status" bigint NULL,
+  "last_error" text NULL,
+  "created_at" timestamptz NOT NULL,
+  "delivered_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_webhook_delivery_endpoint_id" to table: "webhook_deliveries"
+CREATE INDEX "idx_webhook_delivery_endpoint_id" ON "public"."webhook_deliveries" ("endpoint_id");
+-- Create index "idx_webhook_delivery_status_next_attempt_at" to table: "webhook_deliveries"
+CREATE INDEX "idx_webhook_delivery_status_next_attempt_at" ON "public"."webhook_deliveries" ("status", "next_attempt_at");
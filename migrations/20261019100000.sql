@@ -0,0 +1,18 @@
+-- Create enum type "email_status"
+CREATE TYPE "public"."email_status" AS ENUM ('PENDING', 'SENT', 'DEAD');
+-- Create "email_messages" table
+CREATE TABLE "public"."email_messages" (
+  "id" bigint NOT NULL,
+  "recipients" jsonb NOT NULL,
+  "subject" text NOT NULL,
+  "body" text NOT NULL,
+  "status" "public"."email_status" NOT NULL,
+  "attempts" bigint NOT NULL,
+  "next_attempt_at" timestamptz NOT NULL,
+  "last_error" text NULL,
+  "created_at" timestamptz NOT NULL,
+  "sent_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_email_message_status_next_attempt_at" to table: "email_messages"
+CREATE INDEX "idx_email_message_status_next_attempt_at" ON "public"."email_messages" ("status", "next_attempt_at");
@@ -0,0 +1,4 @@
+-- Modify "email_messages" table
+ALTER TABLE "public"."email_messages" ADD COLUMN "text" text NOT NULL DEFAULT '';
+-- Modify "users" table
+ALTER TABLE "public"."users" ADD COLUMN "locale" text NULL;
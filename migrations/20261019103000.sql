@@ -0,0 +1,4 @@
+-- Modify enum "audit_event_type"
+ALTER TYPE "public"."audit_event_type" ADD VALUE 'EMAIL_CHANGE_REQUESTED' AFTER 'EMAIL_CHANGED';
+-- Modify enum "audit_event_type"
+ALTER TYPE "public"."audit_event_type" ADD VALUE 'EMAIL_CHANGE_UNDONE' AFTER 'EMAIL_CHANGE_REQUESTED';
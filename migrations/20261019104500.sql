@@ -0,0 +1,2 @@
+-- Modify "users" table
+ALTER TABLE "public"."users" ADD COLUMN "avatar" text NULL, ADD COLUMN "updated_at" timestamptz NOT NULL DEFAULT now();
@@ -0,0 +1,2 @@
+-- Modify "users" table
+ALTER TABLE "public"."users" ADD COLUMN "public_metadata" jsonb NOT NULL DEFAULT '{}', ADD COLUMN "private_metadata" jsonb NOT NULL DEFAULT '{}', ADD COLUMN "app_metadata" jsonb NOT NULL DEFAULT '{}';
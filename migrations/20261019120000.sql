@@ -0,0 +1,4 @@
+-- Modify enum "audit_event_type"
+ALTER TYPE "public"."audit_event_type" ADD VALUE 'USER_SUSPENDED' AFTER 'PROFILE_UPDATED';
+-- Modify enum "audit_event_type"
+ALTER TYPE "public"."audit_event_type" ADD VALUE 'USER_REACTIVATED' AFTER 'USER_SUSPENDED';
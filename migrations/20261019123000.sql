@@ -0,0 +1,29 @@
+-- Create enum type "data_export_status"
+CREATE TYPE "public"."data_export_status" AS ENUM ('PENDING', 'READY', 'FAILED');
+-- Modify "webhook_deliveries" table
+ALTER TABLE "public"."webhook_deliveries" ADD COLUMN "user_id" bigint NULL;
+-- Create index "idx_webhook_delivery_user_id" to table: "webhook_deliveries"
+CREATE INDEX "idx_webhook_delivery_user_id" ON "public"."webhook_deliveries" ("user_id");
+-- Create "data_exports" table
+CREATE TABLE "public"."data_exports" (
+  "id" bigint NOT NULL,
+  "user_id" bigint NOT NULL,
+  "status" "public"."data_export_status" NOT NULL,
+  "attempts" bigint NOT NULL,
+  "next_attempt_at" timestamptz NOT NULL,
+  "last_error" text NULL,
+  "token_hash" text NULL,
+  "archive" jsonb NULL,
+  "created_at" timestamptz NOT NULL,
+  "ready_at" timestamptz NULL,
+  "expires_at" timestamptz NULL,
+  PRIMARY KEY ("id")
+);
+-- Create index "idx_data_export_expires_at" to table: "data_exports"
+CREATE INDEX "idx_data_export_expires_at" ON "public"."data_exports" ("expires_at");
+-- Create index "idx_data_export_status_next_attempt_at" to table: "data_exports"
+CREATE INDEX "idx_data_export_status_next_attempt_at" ON "public"."data_exports" ("status", "next_attempt_at");
+-- Create index "idx_data_export_token_hash" to table: "data_exports"
+CREATE UNIQUE INDEX "idx_data_export_token_hash" ON "public"."data_exports" ("token_hash") WHERE (token_hash IS NOT NULL);
+-- Create index "idx_data_export_user_id" to table: "data_exports"
+CREATE INDEX "idx_data_export_user_id" ON "public"."data_exports" ("user_id");
@@ -0,0 +1,2 @@
+-- Modify "email_messages" table
+ALTER TABLE "public"."email_messages" ADD COLUMN "is_sealed" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,12 @@
+-- Audit events are append only, except for the personal data account
+-- deletion anonymizes
+CREATE OR REPLACE FUNCTION "public"."reject_audit_event_update"() RETURNS trigger AS $$
+BEGIN
+  IF NEW."email" IS NULL AND NEW."ip" IS NULL AND NEW."user_agent" IS NULL
+    AND ROW(NEW."id", NEW."user_id", NEW."type", NEW."outcome", NEW."reason", NEW."created_at")
+      IS NOT DISTINCT FROM ROW(OLD."id", OLD."user_id", OLD."type", OLD."outcome", OLD."reason", OLD."created_at") THEN
+    RETURN NEW;
+  END IF;
+  RAISE EXCEPTION 'audit events are append only';
+END;
+$$ LANGUAGE plpgsql;